@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var aggregateCmd = &cobra.Command{
+	Use:   "aggregate [YYYY-MM...]",
+	Short: "Recalculate monthly averages",
+	Long: `Recalculate the monthly averages of the given months from the stored samples.
+Without arguments the previous and the current month are recalculated.
+
+Monthly averages are calculated in one of two modes:
+
+  samples    the average of all samples of the bucket in the month (default)
+  prorated   the average of the samples scaled by the fraction of the month the
+             bucket existed, so the time before it was created and after it was
+             deleted counts as zero usage
+
+Gaps in the samples can be filled with synthetic points, one per collection
+interval, before averaging. With --fill linear the points are interpolated
+between the samples around the gap, with --fill carry the sample before the gap
+is repeated. --fill-threshold restricts filling to longer gaps. The fill method
+and the number of synthetic points are stored with each average.
+
+Use this command to recalculate past months after changing the mode. Where the
+retention policy removed the raw samples of a month, the hourly and daily
+rollups are used instead; buckets without any data left in a month keep their
+averages.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		agg, err := aggregationOptions()
+		if err != nil {
+			return configErrorf("%w", err)
+		}
+
+		var months []time.Time
+		for _, arg := range args {
+			t, err := parseDate(arg)
+			if err != nil {
+				return configErrorf("invalid month %q: %w", arg, err)
+			}
+			months = append(months, t)
+		}
+		if len(months) == 0 {
+			now := time.Now().UTC()
+			current := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+			months = append(months, current.AddDate(0, -1, 0), current)
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		for _, m := range months {
+			if err := database.CalculateMonthlyAverages(m.Year(), int(m.Month()), agg); err != nil {
+				return dbErrorf("failed to calculate monthly averages for %d-%02d: %w", m.Year(), m.Month(), err)
+			}
+			fmt.Printf("Calculated %s monthly averages for %d-%02d.\n", agg.Mode, m.Year(), m.Month())
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(aggregateCmd)
+
+	// Add flags to the aggregate command
+	addAggregationFlags(aggregateCmd)
+}
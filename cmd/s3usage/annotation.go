@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+var (
+	// Bucket an annotation applies to
+	annotationBucket string
+	// Only list annotations on or after this date
+	annotationSince string
+)
+
+var annotationCmd = &cobra.Command{
+	Use:   "annotation",
+	Short: "Manage annotations shown in the history",
+	Long: `Commands to add, list and delete annotations, e.g. "customer migrated to new
+cluster". Annotations are attached to a point in time and shown as markers in the
+history. Annotations without --bucket apply to all buckets.`,
+}
+
+var annotationAddCmd = &cobra.Command{
+	Use:   "add [text]",
+	Short: "Add an annotation",
+	Args:  cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		a := models.Annotation{
+			BucketName: annotationBucket,
+			Timestamp:  time.Now().UTC(),
+			Text:       strings.Join(args, " "),
+			Author:     author,
+		}
+		if a.Author == "" {
+			a.Author = defaultAuthor()
+		}
+		if atTime != "" {
+			var err error
+			if a.Timestamp, err = parseDate(atTime); err != nil {
+				return configErrorf("invalid --time: %w", err)
+			}
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		id, err := database.AddAnnotation(a)
+		if err != nil {
+			return dbErrorf("%w", err)
+		}
+		fmt.Printf("Added annotation %d.\n", id)
+		return nil
+	},
+}
+
+var annotationListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List annotations",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var since time.Time
+		if annotationSince != "" {
+			var err error
+			if since, err = parseDate(annotationSince); err != nil {
+				return configErrorf("invalid --since date: %w", err)
+			}
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		annotations, err := database.GetAnnotations(annotationBucket, since, time.Now().AddDate(100, 0, 0))
+		if err != nil {
+			return dbErrorf("failed to retrieve annotations: %w", err)
+		}
+
+		return render(annotations, func() {
+			if len(annotations) == 0 {
+				fmt.Println("No annotations recorded")
+				return
+			}
+
+			// Print the results
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+			fmt.Fprintln(w, "ID\tDate\tBucket\tAuthor\tText")
+			fmt.Fprintln(w, "--\t----\t------\t------\t----")
+
+			for _, a := range annotations {
+				bucket := a.BucketName
+				if bucket == "" {
+					bucket = "(all buckets)"
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n",
+					a.ID,
+					a.Timestamp.Local().Format("2006-01-02 15:04:05"),
+					bucket,
+					a.Author,
+					a.Text,
+				)
+			}
+			w.Flush()
+		})
+	},
+}
+
+var annotationDeleteCmd = &cobra.Command{
+	Use:   "delete [annotation-id]",
+	Short: "Delete an annotation",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return configErrorf("invalid annotation ID %q", args[0])
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		if err := database.DeleteAnnotation(id); err != nil {
+			return dbErrorf("%w", err)
+		}
+		fmt.Printf("Deleted annotation %d.\n", id)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(annotationCmd)
+	annotationCmd.AddCommand(annotationAddCmd)
+	annotationCmd.AddCommand(annotationListCmd)
+	annotationCmd.AddCommand(annotationDeleteCmd)
+
+	// Add flags to the annotation commands
+	annotationAddCmd.Flags().StringVar(&annotationBucket, "bucket", "", "Bucket the annotation applies to (default: all buckets)")
+	annotationAddCmd.Flags().StringVar(&atTime, "time", "", "Time of the annotation (RFC 3339 or YYYY-MM-DD, default: now)")
+	annotationAddCmd.Flags().StringVar(&author, "author", "", "Author of the annotation (default: current user)")
+	annotationListCmd.Flags().StringVar(&annotationBucket, "bucket", "", "Only list annotations of this bucket and those for all buckets")
+	annotationListCmd.Flags().StringVar(&annotationSince, "since", "", "Only list annotations on or after this date (YYYY-MM-DD)")
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+var (
+	// Only show anomalies of this bucket
+	anomaliesBucket string
+	// Only show anomalies found by this rule
+	anomaliesRule string
+	// Only show anomalies on or after this date
+	anomaliesSince string
+)
+
+// Defaults of the anomaly rules
+const (
+	defaultMaxChangePct  = 50
+	defaultZScore        = 4
+	defaultDivergencePct = 50
+	defaultAnomalyWindow = 30 * 24 * time.Hour
+	defaultMinSamples    = 7
+	// Small buckets change by large percentages all the time
+	defaultMinSizeBytes = 1 << 30
+)
+
+// anomalyRules returns the configured anomaly rules, rules that are not set
+// use their defaults
+func anomalyRules() (models.AnomalyRules, error) {
+	cfg := config.Anomalies
+	rules := models.AnomalyRules{
+		MaxChangePct:  defaultMaxChangePct,
+		ZScore:        defaultZScore,
+		DivergencePct: defaultDivergencePct,
+		Window:        defaultAnomalyWindow,
+		MinSamples:    defaultMinSamples,
+		MinSizeBytes:  defaultMinSizeBytes,
+	}
+
+	thresholds := []struct {
+		name  string
+		value *float64
+		dst   *float64
+	}{
+		{"max_change_pct", cfg.MaxChangePct, &rules.MaxChangePct},
+		{"z_score", cfg.ZScore, &rules.ZScore},
+		{"divergence_pct", cfg.DivergencePct, &rules.DivergencePct},
+	}
+	for _, t := range thresholds {
+		if t.value == nil {
+			continue
+		}
+		if *t.value < 0 {
+			return rules, fmt.Errorf("%s must not be negative", t.name)
+		}
+		*t.dst = *t.value
+	}
+
+	if cfg.Window != "" {
+		window, err := parseDuration(cfg.Window)
+		if err != nil || window <= 0 {
+			return rules, fmt.Errorf("invalid window %q, use e.g. 7d or 30d", cfg.Window)
+		}
+		rules.Window = window
+	}
+	if cfg.MinSamples < 0 {
+		return rules, fmt.Errorf("min_samples must not be negative")
+	}
+	if cfg.MinSamples > 0 {
+		rules.MinSamples = cfg.MinSamples
+	}
+	if cfg.MinSize != "" {
+		size, err := parseSize(cfg.MinSize)
+		if err != nil {
+			return rules, fmt.Errorf("invalid min_size: %w", err)
+		}
+		rules.MinSizeBytes = size
+	}
+
+	return rules, nil
+}
+
+var anomaliesCmd = &cobra.Command{
+	Use:   "anomalies",
+	Short: "Show anomalies detected during collection",
+	Long: `Display samples that broke an anomaly rule when they were collected.
+
+Every sample stored by collect is compared with the history of its bucket within
+the anomaly window (default 30 days), using the last value of each hourly or
+daily rollup where raw samples have been removed. The rules are:
+
+  change       the size changed by at least max_change_pct percent since the
+               previous value (default 50)
+  zscore       the size is at least z_score standard deviations away from the
+               mean of the window (default 4), given min_samples values (default 7)
+  divergence   the changes of size and object count since the previous value
+               differ by at least divergence_pct percentage points (default 50),
+               e.g. data disappearing while all objects remain
+
+The rules are configured in the anomalies section of the config file. A threshold
+of 0 disables its rule, and buckets smaller than min_size (default 1GB) before
+and after a sample are not checked; set min_size to 0 to check all buckets:
+
+  anomalies:
+    max_change_pct: 30
+    z_score: 0
+    window: 14d
+    min_size: 10GB`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch anomaliesRule {
+		case "", models.AnomalyChange, models.AnomalyZScore, models.AnomalyDivergence:
+		default:
+			return configErrorf("invalid --rule %q, use change, zscore or divergence", anomaliesRule)
+		}
+		var since time.Time
+		if anomaliesSince != "" {
+			var err error
+			if since, err = parseDate(anomaliesSince); err != nil {
+				return configErrorf("invalid --since date: %w", err)
+			}
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		anomalies, err := database.GetAnomalies(anomaliesBucket, anomaliesRule, since, time.Now())
+		if err != nil {
+			return dbErrorf("failed to retrieve anomalies: %w", err)
+		}
+
+		return render(anomalies, func() {
+			if len(anomalies) == 0 {
+				fmt.Println("No anomalies detected")
+				return
+			}
+
+			// Print the results
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+			fmt.Fprintln(w, "Date\tBucket\tRule\tPrevious\tSize\tPrevious Objects\tObjects\tDetails")
+			fmt.Fprintln(w, "----\t------\t----\t--------\t----\t----------------\t-------\t-------")
+
+			for _, a := range anomalies {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%d\t%s\n",
+					a.Timestamp.Local().Format("2006-01-02 15:04:05"),
+					a.BucketName,
+					a.Rule,
+					formatSize(float64(a.PreviousSizeBytes)),
+					formatSize(float64(a.SizeBytes)),
+					a.PreviousObjectCount,
+					a.ObjectCount,
+					a.Message,
+				)
+			}
+			w.Flush()
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(anomaliesCmd)
+
+	// Add flags to the anomalies command
+	anomaliesCmd.Flags().StringVar(&anomaliesBucket, "bucket", "", "Only show anomalies of this bucket")
+	anomaliesCmd.Flags().StringVar(&anomaliesRule, "rule", "", "Only show anomalies found by this rule: change, zscore or divergence")
+	anomaliesCmd.Flags().StringVar(&anomaliesSince, "since", "", "Only show anomalies on or after this date (YYYY-MM-DD)")
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// Block elements from zero to eight eighths of a character cell
+var blocks = []rune(" ▁▂▃▄▅▆▇█")
+
+// Size of the charts drawn by history --chart
+const (
+	chartHeight   = 8
+	minChartWidth = 20
+)
+
+// terminalWidth returns the width of the terminal stdout is attached to. If
+// stdout is not a terminal, the COLUMNS environment variable is used, or 80 if
+// it is not set either.
+func terminalWidth() int {
+	if n, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && n > 0 {
+		return n
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return 80
+}
+
+// valueRange returns the minimum and maximum of the values that are not NaN
+func valueRange(values []float64) (lo, hi float64, ok bool) {
+	lo, hi = math.Inf(1), math.Inf(-1)
+	for _, v := range values {
+		if math.IsNaN(v) {
+			continue
+		}
+		lo, hi, ok = math.Min(lo, v), math.Max(hi, v), true
+	}
+	return lo, hi, ok
+}
+
+// scale maps a value between lo and hi to a level between 1 and levels, so the
+// smallest value is still visible. If all values are equal, the middle level is used.
+func scale(v, lo, hi float64, levels int) int {
+	if hi == lo {
+		return (levels + 1) / 2
+	}
+	return 1 + int(math.Round((v-lo)/(hi-lo)*float64(levels-1)))
+}
+
+// sparkline draws the values as a line of block elements scaled between their
+// minimum and maximum. Missing values are given as NaN and drawn as spaces.
+func sparkline(values []float64) string {
+	lo, hi, _ := valueRange(values)
+	var sb strings.Builder
+	for _, v := range values {
+		if math.IsNaN(v) {
+			sb.WriteRune(' ')
+			continue
+		}
+		sb.WriteRune(blocks[scale(v, lo, hi, len(blocks)-1)])
+	}
+	return sb.String()
+}
+
+// chartPoint is a value at a point in time drawn by drawChart
+type chartPoint struct {
+	timestamp time.Time
+	value     float64
+}
+
+// drawChart draws a bar chart of the points over time, fitted to the given
+// width. Each column covers the same amount of time and shows the average of
+// its points, columns without points are left empty so gaps remain visible.
+// The bars are scaled between the smallest and largest value, which are shown
+// as labels on the vertical axis.
+func drawChart(w io.Writer, title string, points []chartPoint, width int, format func(float64) string) {
+	if len(points) == 0 {
+		return
+	}
+	start, end := points[0].timestamp, points[len(points)-1].timestamp
+
+	// Leave room for the labels of the vertical axis
+	all := make([]float64, len(points))
+	for i, p := range points {
+		all[i] = p.value
+	}
+	lo, hi, _ := valueRange(all)
+	labelWidth := max(len(format(hi)), len(format(lo)))
+	columns := max(width-labelWidth-2, minChartWidth)
+
+	// Average the points of each column
+	sums := make([]float64, columns)
+	counts := make([]int, columns)
+	for _, p := range points {
+		col := 0
+		if end.After(start) {
+			col = int(math.Round(float64(p.timestamp.Sub(start)) / float64(end.Sub(start)) * float64(columns-1)))
+		}
+		sums[col] += p.value
+		counts[col]++
+	}
+	values := make([]float64, columns)
+	for i := range values {
+		values[i] = math.NaN()
+		if counts[i] > 0 {
+			values[i] = sums[i] / float64(counts[i])
+		}
+	}
+
+	// Bar heights in eighths of a cell
+	lo, hi, _ = valueRange(values)
+	labels := []string{format(hi), format(lo)}
+	labelWidth = max(labelWidth, len(labels[0]), len(labels[1]))
+	heights := make([]int, columns)
+	for i, v := range values {
+		if !math.IsNaN(v) {
+			heights[i] = scale(v, lo, hi, chartHeight*8)
+		}
+	}
+
+	fmt.Fprintf(w, "%s\n\n", title)
+	for row := chartHeight - 1; row >= 0; row-- {
+		label := ""
+		switch row {
+		case chartHeight - 1:
+			label = labels[0]
+		case 0:
+			label = labels[1]
+		}
+
+		var sb strings.Builder
+		for _, h := range heights {
+			sb.WriteRune(blocks[min(max(h-row*8, 0), 8)])
+		}
+		fmt.Fprintf(w, "%*s │%s\n", labelWidth, label, sb.String())
+	}
+	fmt.Fprintf(w, "%*s └%s\n", labelWidth, "", strings.Repeat("─", columns))
+
+	// Label the start and end of the time axis
+	layout := "2006-01-02"
+	if end.Sub(start) < 7*24*time.Hour {
+		layout = "2006-01-02 15:04"
+	}
+	from, to := start.Local().Format(layout), end.Local().Format(layout)
+	gap := max(columns-len(from)-len(to), 1)
+	fmt.Fprintf(w, "%*s  %s%s%s\n", labelWidth, "", from, strings.Repeat(" ", gap), to)
+}
+
+// formatCount formats an object count for the chart labels
+func formatCount(count float64) string {
+	return strconv.FormatInt(int64(math.Round(count)), 10)
+}
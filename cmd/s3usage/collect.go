@@ -2,79 +2,399 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/thannaske/s3usage/pkg/ceph"
 	"github.com/thannaske/s3usage/pkg/db"
+	"github.com/thannaske/s3usage/pkg/models"
 )
 
+var (
+	// File to write the machine-readable summary of the run to
+	summaryPath string
+	// Only collect these buckets
+	onlyBuckets []string
+)
+
+// defaultLockTimeout is how long a collection lock is honored before it is
+// considered stale, e.g. because the collector crashed
+const defaultLockTimeout = time.Hour
+
+// lockOwner identifies this process as the holder of a lock
+func lockOwner() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// lockHeartbeat renews a lock while a long running command holds it
+type lockHeartbeat struct {
+	mu   sync.Mutex
+	lost error
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startHeartbeat renews the named lock every third of ttl until Stop is called.
+// If the lock was lost, cancel is called to abort the work done under it and
+// Err reports the loss.
+func startHeartbeat(database *db.DB, name, owner string, ttl time.Duration, cancel context.CancelFunc) *lockHeartbeat {
+	h := &lockHeartbeat{stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(h.done)
+		ticker := time.NewTicker(ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+			}
+			held, err := database.RenewLock(name, owner, ttl)
+			if err != nil {
+				// The lock is still valid until it expires, try again later
+				fmt.Fprintf(os.Stderr, "Error renewing collection lock: %v\n", err)
+				continue
+			}
+			if !held {
+				h.mu.Lock()
+				h.lost = fmt.Errorf("collection lock %s was lost to another collector", name)
+				h.mu.Unlock()
+				cancel()
+				return
+			}
+		}
+	}()
+	return h
+}
+
+// Err returns an error if the lock was lost
+func (h *lockHeartbeat) Err() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lost
+}
+
+// Stop ends the renewals and waits for a running renewal to finish
+func (h *lockHeartbeat) Stop() {
+	close(h.stop)
+	<-h.done
+}
+
 var collectCmd = &cobra.Command{
 	Use:   "collect",
 	Short: "Collect bucket usage data",
-	Long:  `Collect usage data for all buckets and store it in the database.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Collect usage data for all buckets and store it in the database.
+
+After storing the samples the hourly and daily rollups are updated and data past
+the configured retention periods is removed, see the compact command.
+
+All samples of a run share the same timestamp and are stored in a single
+transaction. If some buckets fail, --on-partial-failure decides whether the
+samples of the remaining buckets are stored (commit, the default) or the whole
+run is discarded (abort).
+
+Only one collection per cluster runs at a time. If another collection holds the
+lock, this run exits without doing anything. The lock is renewed while the run
+is in progress, and the run is aborted if it was taken over by another
+collection nevertheless. Locks of crashed collections are taken over after the
+lock timeout.
+
+Buckets can be selected with --include and --exclude patterns, or include and
+exclude in the config file. Patterns are globs like "logs-*", or regular
+expressions if prefixed with "re:", e.g. "re:^backup-[0-9]+$". A bucket is
+collected if it matches any include pattern (or none are given) and no exclude
+pattern. With --bucket exactly the given buckets are collected.
+
+Buckets that appear in or disappear from the bucket listing are recorded as
+lifecycle events, see the events command. Lifecycle detection always uses the
+complete bucket listing, so excluded buckets are not reported as deleted. With
+--aggregation prorated, the time of the month before a bucket was created and
+after it was deleted counts as zero usage in the monthly averages.
+
+Every stored sample is checked against the anomaly rules in the config file, see
+the anomalies command.
+
+With --summary-json a machine-readable summary of the run is written to the given
+file for monitoring. The exit status is 4 if some buckets could not be collected.`,
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
+		run := models.CollectionRun{Endpoint: config.S3Endpoint, StartedAt: time.Now().UTC()}
+		var collected *models.CollectionResult
+		skipped := false
+		anomalies := 0
+
+		// Write the summary whatever the outcome of the run is
+		if summaryPath != "" {
+			defer func() {
+				summary := collectionSummary(run, collected, err)
+				if skipped {
+					summary.Status = models.RunStatusSkipped
+				}
+				summary.Anomalies = anomalies
+				if writeErr := writeSummary(summaryPath, summary); writeErr != nil && err == nil {
+					err = writeErr
+				}
+			}()
+		}
+
 		// Validate required parameters
 		if config.S3Endpoint == "" || config.S3AccessKey == "" || config.S3SecretKey == "" {
-			fmt.Println("Error: Missing required S3 credentials. Please provide --endpoint, --access-key, and --secret-key.")
-			return
+			return configErrorf("missing required S3 credentials, please provide --endpoint, --access-key, and --secret-key")
+		}
+
+		policy, err := retentionPolicy()
+		if err != nil {
+			return configErrorf("invalid retention policy: %w", err)
+		}
+		agg, err := aggregationOptions()
+		if err != nil {
+			return configErrorf("%w", err)
+		}
+
+		switch config.OnPartialFailure {
+		case "":
+			config.OnPartialFailure = models.PartialFailureCommit
+		case models.PartialFailureCommit, models.PartialFailureAbort:
+		default:
+			return configErrorf("invalid partial failure policy %q, use %s or %s",
+				config.OnPartialFailure, models.PartialFailureCommit, models.PartialFailureAbort)
+		}
+
+		lockTimeout := defaultLockTimeout
+		if config.LockTimeout != "" {
+			lockTimeout, err = parseDuration(config.LockTimeout)
+			if err != nil || lockTimeout <= 0 {
+				return configErrorf("invalid lock timeout %q", config.LockTimeout)
+			}
+		}
+
+		rules, err := anomalyRules()
+		if err != nil {
+			return configErrorf("invalid anomaly rules: %w", err)
+		}
+
+		filter, err := ceph.NewBucketFilter(config.Include, config.Exclude, onlyBuckets)
+		if err != nil {
+			return configErrorf("invalid bucket filter: %w", err)
 		}
 
 		// Initialize the database
-		database, err := db.NewDB(config.DBPath)
+		database, err := openDatabase()
 		if err != nil {
-			fmt.Printf("Error connecting to database: %v\n", err)
-			return
+			return err
 		}
 		defer database.Close()
 
-		err = database.InitDB()
+		// Make sure no other collection for this cluster is running
+		lockName := "collect:" + config.S3Endpoint
+		owner := lockOwner()
+		acquired, holder, err := database.AcquireLock(lockName, owner, lockTimeout)
 		if err != nil {
-			fmt.Printf("Error initializing database: %v\n", err)
-			return
+			return dbErrorf("failed to acquire collection lock: %w", err)
+		}
+		if !acquired {
+			skipped = true
+			fmt.Printf("Another collection for %s is running (%s, started %s). Skipping this run.\n",
+				config.S3Endpoint, holder.Owner, holder.AcquiredAt.Local().Format("2006-01-02 15:04:05"))
+			return nil
+		}
+		defer database.ReleaseLock(lockName, owner)
+
+		// Keep the lock while collecting and abort if it is lost anyway
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		lease := startHeartbeat(database, lockName, owner, lockTimeout, cancel)
+		defer lease.Stop()
+
+		// Record the run in the journal
+		run.ID, err = database.StartCollectionRun(run.Endpoint, run.StartedAt)
+		if err != nil {
+			return dbErrorf("%w", err)
+		}
+		finishRun := func(runErr error) {
+			run.FinishedAt = time.Now().UTC()
+			run.BucketsFailed = len(run.Errors)
+			switch {
+			case runErr != nil:
+				run.Status = models.RunStatusFailed
+				run.Error = runErr.Error()
+			case run.BucketsFailed > 0 && run.BucketsSucceeded == 0:
+				run.Status = models.RunStatusFailed
+			case run.BucketsFailed > 0:
+				run.Status = models.RunStatusPartial
+			default:
+				run.Status = models.RunStatusSuccess
+			}
+			if err := database.FinishCollectionRun(run); err != nil {
+				fmt.Fprintf(os.Stderr, "Error recording collection run: %v\n", err)
+			}
 		}
 
 		// Initialize the S3 client
 		s3Client, err := ceph.NewS3Client(config)
 		if err != nil {
-			fmt.Printf("Error initializing S3 client: %v\n", err)
-			return
+			finishRun(err)
+			return configErrorf("failed to initialize S3 client: %w", err)
 		}
 
-		// Get usage data for all buckets
+		// Get usage data for all buckets, all samples share the run's timestamp
 		fmt.Println("Collecting bucket usage data...")
-		usages, err := s3Client.GetAllBucketsUsage(context.Background())
+		collected, err = s3Client.GetAllBucketsUsage(ctx, run.StartedAt, filter)
+		if lostErr := lease.Err(); lostErr != nil {
+			finishRun(lostErr)
+			return dbErrorf("%w, no usage data was stored", lostErr)
+		}
 		if err != nil {
-			fmt.Printf("Error collecting bucket usage data: %v\n", err)
-			return
+			finishRun(err)
+			return apiErrorf("failed to collect bucket usage data: %w", err)
+		}
+		run.BucketsTotal = len(collected.Usages) + len(collected.Failures)
+		if len(collected.Excluded) > 0 {
+			fmt.Printf("Skipped %d buckets excluded by the bucket filter.\n", len(collected.Excluded))
 		}
+		run.Errors = collected.Failures
 
-		// Store usage data in the database
-		for _, usage := range usages {
-			err = database.StoreBucketUsage(usage)
-			if err != nil {
-				fmt.Printf("Error storing usage data for bucket %s: %v\n", usage.BucketName, err)
-				continue
-			}
+		// Nothing of an aborted run is stored, not even its bucket events
+		if len(collected.Failures) > 0 && config.OnPartialFailure == models.PartialFailureAbort {
+			err := fmt.Errorf("collection aborted because %d of %d buckets failed", len(collected.Failures), run.BucketsTotal)
+			finishRun(err)
+			return partialErrorf("%w, no usage data was stored", err)
+		}
+		if err := lease.Err(); err != nil {
+			finishRun(err)
+			return dbErrorf("%w, no usage data was stored", err)
+		}
+
+		// Store the bucket events, metadata and usage data of the whole run in
+		// a single transaction. The bucket listing succeeded, so buckets
+		// missing from it were deleted.
+		events, err := database.StoreCollectionRun(run.ID, run.Endpoint, collected, run.StartedAt)
+		if err != nil {
+			finishRun(err)
+			return dbErrorf("%w", err)
+		}
+		for _, event := range events {
+			fmt.Printf("Bucket event for %s: %s\n", event.BucketName, event.Type)
+		}
+		run.BucketsSucceeded = len(collected.Usages)
+		for _, usage := range collected.Usages {
 			fmt.Printf("Stored usage data for bucket %s: %d bytes, %d objects\n",
 				usage.BucketName, usage.SizeBytes, usage.ObjectCount)
 		}
 
+		// The samples are stored, so a failed check does not fail the run
+		found, err := database.DetectAnomalies(collected.Usages, rules)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error detecting anomalies: %v\n", err)
+		}
+		anomalies = len(found)
+		for _, a := range found {
+			fmt.Printf("Anomaly in bucket %s (%s): %s\n", a.BucketName, a.Rule, a.Message)
+		}
+
 		// Always calculate monthly averages every time we collect data
-		now := time.Now()
+		now := run.StartedAt
 		fmt.Println("Calculating monthly averages...")
-		err = database.CalculateMonthlyAverages(now.Year(), int(now.Month()))
+		err = database.CalculateMonthlyAverages(now.Year(), int(now.Month()), agg)
 		if err != nil {
-			fmt.Printf("Error calculating monthly averages: %v\n", err)
-			return
+			finishRun(err)
+			return dbErrorf("failed to calculate monthly averages: %w", err)
 		}
 		fmt.Println("Monthly averages calculated successfully.")
 
+		// Keep the rollups up to date and drop data past its retention period
+		fmt.Println("Compacting usage data...")
+		result, err := database.Compact(policy, agg, now, false)
+		if err != nil {
+			finishRun(err)
+			return dbErrorf("failed to compact usage data: %w", err)
+		}
+		printCompactionResult(result)
+
+		// The run is only finished once its data has been processed
+		finishRun(nil)
+		fmt.Printf("Collection run %d finished with status %s (%d of %d buckets collected).\n",
+			run.ID, run.Status, run.BucketsSucceeded, run.BucketsTotal)
+
+		switch run.Status {
+		case models.RunStatusFailed:
+			return apiErrorf("no bucket could be collected")
+		case models.RunStatusPartial:
+			return partialErrorf("%d of %d buckets could not be collected", run.BucketsFailed, run.BucketsTotal)
+		}
+
 		fmt.Println("Collection completed successfully.")
+		return nil
 	},
 }
 
+// collectionSummary builds the machine-readable summary of a collection run
+func collectionSummary(run models.CollectionRun, collected *models.CollectionResult, err error) models.CollectionSummary {
+	summary := models.CollectionSummary{
+		RunID:            run.ID,
+		Endpoint:         run.Endpoint,
+		Status:           run.Status,
+		ExitCode:         exitCode(err),
+		StartedAt:        run.StartedAt,
+		FinishedAt:       run.FinishedAt,
+		BucketsTotal:     run.BucketsTotal,
+		BucketsSucceeded: run.BucketsSucceeded,
+		BucketsFailed:    len(run.Errors),
+		Failures:         run.Errors,
+	}
+	if summary.FinishedAt.IsZero() {
+		summary.FinishedAt = time.Now().UTC()
+	}
+	summary.DurationSeconds = summary.FinishedAt.Sub(summary.StartedAt).Seconds()
+	if err != nil {
+		summary.Error = err.Error()
+		if summary.Status == "" || summary.Status == models.RunStatusSuccess {
+			summary.Status = models.RunStatusFailed
+		}
+	}
+	if summary.Failures == nil {
+		summary.Failures = []models.BucketError{}
+	}
+
+	// Totals only count what was actually stored
+	if collected != nil && run.BucketsSucceeded > 0 {
+		for _, usage := range collected.Usages {
+			summary.TotalSizeBytes += usage.SizeBytes
+			summary.TotalObjects += usage.ObjectCount
+		}
+	}
+	return summary
+}
+
+// writeSummary writes the summary of a collection run as JSON
+func writeSummary(path string, summary models.CollectionSummary) error {
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write summary: %w", err)
+	}
+	return nil
+}
+
 func init() {
 	rootCmd.AddCommand(collectCmd)
+
+	// Add flags to the collect command
+	addRetentionFlags(collectCmd)
+	addAggregationFlags(collectCmd)
+	collectCmd.Flags().StringVar(&config.OnPartialFailure, "on-partial-failure", "", "What to do if some buckets fail: commit or abort (default: commit)")
+	collectCmd.Flags().StringVar(&summaryPath, "summary-json", "", "Write a JSON summary of the run to this file")
+	collectCmd.Flags().StringSliceVar(&config.Include, "include", nil, "Only collect buckets matching these patterns (glob, or regex with re: prefix)")
+	collectCmd.Flags().StringSliceVar(&config.Exclude, "exclude", nil, "Do not collect buckets matching these patterns (glob, or regex with re: prefix)")
+	collectCmd.Flags().StringSliceVar(&onlyBuckets, "bucket", nil, "Only collect these buckets, ignoring the include and exclude patterns")
+	collectCmd.Flags().StringVar(&config.LockTimeout, "lock-timeout", "", "Time after which a collection lock is considered stale (default: 1h)")
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/db"
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+var (
+	// Flag to recalculate all rollups instead of only the most recent ones
+	rebuild bool
+)
+
+// retentionPolicy parses the configured retention periods
+func retentionPolicy() (models.RetentionPolicy, error) {
+	var policy models.RetentionPolicy
+	var err error
+
+	if policy.Raw, err = parseDuration(config.Retention.Raw); err != nil {
+		return policy, fmt.Errorf("raw retention: %w", err)
+	}
+	if policy.Hourly, err = parseDuration(config.Retention.Hourly); err != nil {
+		return policy, fmt.Errorf("hourly retention: %w", err)
+	}
+	if policy.Daily, err = parseDuration(config.Retention.Daily); err != nil {
+		return policy, fmt.Errorf("daily retention: %w", err)
+	}
+
+	// Coarser resolutions have to outlive finer ones, otherwise the history
+	// would have holes once the finer data is removed
+	if policy.Hourly > 0 && (policy.Raw == 0 || policy.Raw > policy.Hourly) {
+		return policy, fmt.Errorf("hourly rollups must be kept at least as long as raw samples")
+	}
+	if policy.Daily > 0 && (policy.Hourly == 0 || policy.Hourly > policy.Daily) {
+		return policy, fmt.Errorf("daily rollups must be kept at least as long as hourly rollups")
+	}
+
+	return policy, nil
+}
+
+// aggregationOptions returns the configured options for calculating monthly averages
+func aggregationOptions() (models.AggregationOptions, error) {
+	opts := models.AggregationOptions{Mode: config.Aggregation}
+	switch opts.Mode {
+	case "":
+		opts.Mode = models.AggregationSamples
+	case models.AggregationSamples, models.AggregationProrated:
+	default:
+		return opts, fmt.Errorf("unknown aggregation mode %q, use %s or %s",
+			opts.Mode, models.AggregationSamples, models.AggregationProrated)
+	}
+
+	opts.Interval = db.DefaultInterval
+	if config.CollectionInterval != "" {
+		interval, err := parseDuration(config.CollectionInterval)
+		if err != nil || interval <= 0 {
+			return opts, fmt.Errorf("invalid collection interval %q", config.CollectionInterval)
+		}
+		opts.Interval = interval
+	}
+
+	switch config.FillMethod {
+	case "", models.FillNone:
+		opts.Fill = models.FillNone
+	case models.FillLinear, models.FillCarry:
+		opts.Fill = config.FillMethod
+	default:
+		return opts, fmt.Errorf("unknown fill method %q, use %s, %s or %s",
+			config.FillMethod, models.FillNone, models.FillLinear, models.FillCarry)
+	}
+	if config.FillThreshold != "" {
+		threshold, err := parseDuration(config.FillThreshold)
+		if err != nil {
+			return opts, fmt.Errorf("invalid fill threshold %q", config.FillThreshold)
+		}
+		opts.FillThreshold = threshold
+	}
+	return opts, nil
+}
+
+// printCompactionResult prints a summary of a compaction run
+func printCompactionResult(result models.CompactionResult) {
+	fmt.Printf("Updated %d hourly and %d daily rollups.\n", result.HourlyRollups, result.DailyRollups)
+	if result.MonthsAveraged > 0 {
+		fmt.Printf("Calculated monthly averages for %d months before removing their raw samples.\n", result.MonthsAveraged)
+	}
+	if result.RawDeleted > 0 || result.HourlyDeleted > 0 || result.DailyDeleted > 0 {
+		fmt.Printf("Removed %d raw samples, %d hourly and %d daily rollups past their retention period.\n",
+			result.RawDeleted, result.HourlyDeleted, result.DailyDeleted)
+	}
+}
+
+var compactCmd = &cobra.Command{
+	Use:   "compact",
+	Short: "Downsample usage data and apply retention",
+	Long: `Downsample raw usage samples into hourly and daily rollups and remove data
+that is older than the configured retention periods.
+
+Retention periods are given per resolution, for example raw samples for 90 days,
+hourly rollups for a year and daily rollups forever:
+
+  s3usage compact --raw-retention 90d --hourly-retention 1y --daily-retention forever
+
+Without any retention periods all data is kept and only the rollups are updated.
+The collect command performs the same compaction after every collection.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		policy, err := retentionPolicy()
+		if err != nil {
+			return configErrorf("invalid retention policy: %w", err)
+		}
+		agg, err := aggregationOptions()
+		if err != nil {
+			return configErrorf("%w", err)
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		fmt.Println("Compacting usage data...")
+		result, err := database.Compact(policy, agg, time.Now(), rebuild)
+		if err != nil {
+			return dbErrorf("failed to compact usage data: %w", err)
+		}
+		printCompactionResult(result)
+
+		fmt.Println("Compaction completed successfully.")
+		return nil
+	},
+}
+
+// addRetentionFlags adds the retention period flags to a command
+func addRetentionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&config.Retention.Raw, "raw-retention", "", "How long to keep raw samples, e.g. 90d (default: forever)")
+	cmd.Flags().StringVar(&config.Retention.Hourly, "hourly-retention", "", "How long to keep hourly rollups, e.g. 1y (default: forever)")
+	cmd.Flags().StringVar(&config.Retention.Daily, "daily-retention", "", "How long to keep daily rollups (default: forever)")
+}
+
+// addAggregationFlags adds the flags controlling monthly averages to a command
+func addAggregationFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&config.Aggregation, "aggregation", "", "How monthly averages are calculated: samples or prorated (default: samples)")
+	cmd.Flags().StringVar(&config.FillMethod, "fill", "", "How gaps in the samples are filled: none, linear or carry (default: none)")
+	cmd.Flags().StringVar(&config.FillThreshold, "fill-threshold", "", "Only fill gaps longer than this, e.g. 3d (default: every gap)")
+	addIntervalFlag(cmd)
+}
+
+// addIntervalFlag adds the flag for the expected collection interval to a command
+func addIntervalFlag(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&config.CollectionInterval, "collection-interval", "", "Expected time between two collections, used to detect gaps (default: 24h)")
+}
+
+func init() {
+	rootCmd.AddCommand(compactCmd)
+
+	// Add flags to the compact command
+	addRetentionFlags(compactCmd)
+	addAggregationFlags(compactCmd)
+	compactCmd.Flags().BoolVar(&rebuild, "rebuild", false, "Recalculate all rollups from the available raw samples")
+}
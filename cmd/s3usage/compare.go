@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+var (
+	// Months to compare
+	compareFrom string
+	compareTo   string
+	// Compare with the same month of the previous year
+	compareYoY bool
+	// Order of the buckets
+	compareSort string
+)
+
+// comparisonOrders sorts the comparisons for --sort. Buckets without a
+// percentage are sorted last by percent.
+var comparisonOrders = map[string]func(a, b models.MonthComparison) bool{
+	"increase": func(a, b models.MonthComparison) bool { return a.SizeChangeBytes > b.SizeChangeBytes },
+	"decrease": func(a, b models.MonthComparison) bool { return a.SizeChangeBytes < b.SizeChangeBytes },
+	"percent": func(a, b models.MonthComparison) bool {
+		if a.SizeChangePct == nil || b.SizeChangePct == nil {
+			return a.SizeChangePct != nil
+		}
+		return *a.SizeChangePct > *b.SizeChangePct
+	},
+	"name": func(a, b models.MonthComparison) bool { return a.BucketName < b.BucketName },
+}
+
+// formatSizeChange formats a signed change of a size
+func formatSizeChange(bytes float64) string {
+	if bytes < 0 {
+		return "-" + formatSize(-bytes)
+	}
+	return "+" + formatSize(bytes)
+}
+
+// formatPercentChange formats an optional change in percent
+func formatPercentChange(pct *float64) string {
+	if pct == nil {
+		return "n/a"
+	}
+	return fmt.Sprintf("%+.1f%%", *pct)
+}
+
+// formatOptionalSize formats an optional size, missing sizes are shown as a dash
+func formatOptionalSize(bytes *float64) string {
+	if bytes == nil {
+		return "-"
+	}
+	return formatSize(*bytes)
+}
+
+var compareCmd = &cobra.Command{
+	Use:   "compare",
+	Short: "Compare the monthly usage of two months",
+	Long: `Compare the monthly averages of all buckets in two months and show the
+absolute and percentage change of each bucket's size and object count.
+
+Buckets that only have an average in the later month are listed as new, buckets
+that only have one in the earlier month as vanished; their missing average counts
+as zero. Without --from the previous month of --to is used, or with --yoy the same
+month of the previous year. Without --to the previous month is compared.
+
+Use --sort to order the buckets by the largest increase (default), the largest
+decrease, the largest change in percent or by name.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if _, ok := comparisonOrders[compareSort]; !ok {
+			return configErrorf("invalid --sort %q, use increase, decrease, percent or name", compareSort)
+		}
+		if compareYoY && compareFrom != "" {
+			return configErrorf("--yoy and --from cannot be used together")
+		}
+
+		now := time.Now().UTC()
+		to := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+		var err error
+		if compareTo != "" {
+			if to, err = parseMonth(compareTo); err != nil {
+				return configErrorf("invalid --to month: %w", err)
+			}
+		}
+		from := to.AddDate(0, -1, 0)
+		if compareYoY {
+			from = to.AddDate(-1, 0, 0)
+		}
+		if compareFrom != "" {
+			if from, err = parseMonth(compareFrom); err != nil {
+				return configErrorf("invalid --from month: %w", err)
+			}
+		}
+		if !from.Before(to) {
+			return configErrorf("--from %s must be before --to %s", from.Format("2006-01"), to.Format("2006-01"))
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		comparisons, err := database.CompareMonths(from.Year(), int(from.Month()), to.Year(), int(to.Month()))
+		if err != nil {
+			return dbErrorf("failed to compare monthly averages: %w", err)
+		}
+
+		less := comparisonOrders[compareSort]
+		sort.SliceStable(comparisons, func(i, j int) bool {
+			return less(comparisons[i], comparisons[j])
+		})
+
+		return render(comparisons, func() {
+			printComparison(from, to, comparisons)
+		})
+	},
+}
+
+// printComparison prints the comparison of two months as a table with totals
+func printComparison(from, to time.Time, comparisons []models.MonthComparison) {
+	fromLabel, toLabel := from.Format("2006-01"), to.Format("2006-01")
+	if len(comparisons) == 0 {
+		fmt.Printf("No data available for %s or %s\n", fromLabel, toLabel)
+		return
+	}
+
+	// Print the results
+	fmt.Printf("Usage Comparison %s to %s\n\n", fromLabel, toLabel)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	fmt.Fprintf(w, "Bucket\t%s\t%s\tChange\tChange %%\tObjects Change\tStatus\n", fromLabel, toLabel)
+	fmt.Fprintln(w, "------\t-------\t-------\t------\t--------\t--------------\t------")
+
+	var fromTotal, toTotal float64
+	var added, vanished int
+	for _, c := range comparisons {
+		status := ""
+		switch c.Status {
+		case models.ComparisonNew:
+			status = "new"
+			added++
+		case models.ComparisonVanished:
+			status = "vanished"
+			vanished++
+		}
+		if c.FromSizeBytes != nil {
+			fromTotal += *c.FromSizeBytes
+		}
+		if c.ToSizeBytes != nil {
+			toTotal += *c.ToSizeBytes
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%+d\t%s\n",
+			c.BucketName,
+			formatOptionalSize(c.FromSizeBytes),
+			formatOptionalSize(c.ToSizeBytes),
+			formatSizeChange(c.SizeChangeBytes),
+			formatPercentChange(c.SizeChangePct),
+			int64(math.Round(c.ObjectCountChange)),
+			status,
+		)
+	}
+
+	var totalPct *float64
+	if fromTotal != 0 {
+		pct := (toTotal - fromTotal) / fromTotal * 100
+		totalPct = &pct
+	}
+	fmt.Fprintln(w, "\t\t\t\t\t\t")
+	fmt.Fprintf(w, "Total\t%s\t%s\t%s\t%s\t\t\n",
+		formatSize(fromTotal),
+		formatSize(toTotal),
+		formatSizeChange(toTotal-fromTotal),
+		formatPercentChange(totalPct),
+	)
+	w.Flush()
+
+	fmt.Printf("\n%d new and %d vanished buckets\n", added, vanished)
+}
+
+func init() {
+	rootCmd.AddCommand(compareCmd)
+
+	// Add flags to the compare command
+	compareCmd.Flags().StringVar(&compareFrom, "from", "", "Earlier month (YYYY-MM, default: the month before --to)")
+	compareCmd.Flags().StringVar(&compareTo, "to", "", "Later month (YYYY-MM, default: previous month)")
+	compareCmd.Flags().BoolVar(&compareYoY, "yoy", false, "Compare with the same month of the previous year")
+	compareCmd.Flags().StringVar(&compareSort, "sort", "increase", "Order of the buckets: increase, decrease, percent or name")
+}
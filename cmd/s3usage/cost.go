@@ -0,0 +1,274 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/models"
+	"github.com/thannaske/s3usage/pkg/pricing"
+)
+
+var (
+	// Month to calculate the cost of
+	costMonth string
+)
+
+// defaultDecimals is the number of decimal places costs are rounded to if the
+// config file does not say otherwise
+const defaultDecimals = 2
+
+// parseRates converts a set of rates from the config file
+func parseRates(cfg models.RateConfig) (pricing.Rates, error) {
+	rates := pricing.Rates{
+		PerMillionObjects: cfg.PerMillionObjects,
+		MinimumCharge:     cfg.MinimumCharge,
+	}
+	switch {
+	case len(cfg.Tiers) > 0:
+		rates.Tiers = make([]pricing.Tier, len(cfg.Tiers))
+		for i, t := range cfg.Tiers {
+			rates.Tiers[i].PerGBMonth = t.PerGBMonth
+			if t.UpTo == "" {
+				continue
+			}
+			size, err := parseSize(t.UpTo)
+			if err != nil || size <= 0 {
+				return rates, fmt.Errorf("invalid up_to %q of tier %d", t.UpTo, i+1)
+			}
+			rates.Tiers[i].UpToBytes = size
+		}
+	case cfg.PerGBMonth != nil:
+		rates.Tiers = []pricing.Tier{{PerGBMonth: *cfg.PerGBMonth}}
+	}
+	return rates, nil
+}
+
+// pricingConfig returns the configured prices
+func pricingConfig() (*pricing.Pricing, error) {
+	cfg := config.Pricing
+	p := &pricing.Pricing{
+		Currency:   cfg.Currency,
+		Decimals:   defaultDecimals,
+		Rounding:   cfg.Rounding,
+		Placements: make(map[string]pricing.Rates),
+		Groups:     make(map[string]pricing.Rates),
+	}
+	if cfg.Decimals != nil {
+		p.Decimals = *cfg.Decimals
+	}
+	if p.Rounding == "" {
+		p.Rounding = pricing.RoundNearest
+	}
+
+	var err error
+	if p.Default, err = parseRates(cfg.RateConfig); err != nil {
+		return nil, fmt.Errorf("default rates: %w", err)
+	}
+	for name, rc := range cfg.Placements {
+		if p.Placements[name], err = parseRates(rc); err != nil {
+			return nil, fmt.Errorf("placement %s: %w", name, err)
+		}
+	}
+	for name, rc := range cfg.Groups {
+		if p.Groups[name], err = parseRates(rc); err != nil {
+			return nil, fmt.Errorf("group %s: %w", name, err)
+		}
+	}
+
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// averagePlacement returns the placement a monthly average is priced with,
+// which is the placement recorded for the month. Averages calculated by older
+// versions did not record it and fall back to the bucket's current placement,
+// in which case recorded is false.
+func averagePlacement(avg models.MonthlyBucketAverage, infos map[string]models.BucketInfo) (placement string, recorded bool) {
+	if avg.Placement != "" {
+		return avg.Placement, true
+	}
+	return infos[avg.BucketName].Placement, false
+}
+
+// averageOwner returns the owner of a bucket in the month of an average, which
+// is the owner recorded for the month or, for averages calculated by older
+// versions, the bucket's current owner
+func averageOwner(avg models.MonthlyBucketAverage, infos map[string]models.BucketInfo) string {
+	if avg.Owner != "" {
+		return avg.Owner
+	}
+	return infos[avg.BucketName].Owner
+}
+
+// warnUnrecordedPlacements tells which buckets were priced without a placement
+// recorded for the month, if placement rates are configured
+func warnUnrecordedPlacements(p *pricing.Pricing, month time.Time, buckets []string) {
+	if len(buckets) == 0 || len(p.Placements) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "Warning: no placement recorded in %s for %s; using the current placement, or the default rates if it is unknown. Run aggregate for the month to record it.\n",
+		month.Format("2006-01"), strings.Join(buckets, ", "))
+}
+
+// formatAmount formats an amount of money with the configured decimals and
+// currency
+func formatAmount(p *pricing.Pricing, amount float64) string {
+	s := fmt.Sprintf("%.*f", p.Decimals, amount)
+	if p.Currency != "" {
+		s += " " + p.Currency
+	}
+	return s
+}
+
+var costCmd = &cobra.Command{
+	Use:   "cost",
+	Short: "Calculate the cost of all buckets in a month",
+	Long: `Apply the prices from the pricing section of the config file to the monthly
+averages of all buckets and show the cost of each bucket and the total.
+
+Storage is charged per GB-month of the average size, where a GB is 1024^3 bytes,
+either at a flat price or in tiers, each tier pricing the part of the size that
+falls into it. Tiers apply to the total size of an owner's buckets that are priced
+with the same rates, and the storage cost of that total is split across the
+buckets by their size: two buckets of 60 GB of the same owner reach a tier
+starting at 100 GB like a single bucket of 120 GB. Buckets without an owner are
+priced on their own. Objects are charged per million of the average object
+count. Buckets that stored any data are charged at least the minimum charge.
+
+Rates can be overridden for the buckets of a placement target and for the
+buckets of a group. The placement and owner are those recorded with the samples
+of the month, so a bucket moved to another placement is priced at the rates of
+the placement it used in that month. Averages calculated before placements were
+recorded use the current placement, and the affected buckets are listed in a
+warning.
+
+Group rates take precedence over placement rates and those over the default
+rates; each rate is looked up separately, so an override only needs to give the
+rates it changes. If a bucket belongs to several groups with rates, the first
+group in alphabetical order is used.
+
+Storage and object costs are rounded separately to the configured decimals
+(default 2) in the configured direction (nearest, up or down), so the line items
+add up to the total.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		p, err := pricingConfig()
+		if err != nil {
+			return configErrorf("invalid pricing: %w", err)
+		}
+
+		now := time.Now().UTC()
+		month := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+		if costMonth != "" {
+			if month, err = parseDate(costMonth); err != nil {
+				return configErrorf("invalid --month: %w", err)
+			}
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		averages, err := database.GetAllMonthlyAverages(month.Year(), int(month.Month()))
+		if err != nil {
+			return dbErrorf("failed to retrieve monthly averages: %w", err)
+		}
+
+		infos, err := database.GetBucketInfos()
+		if err != nil {
+			return dbErrorf("failed to retrieve bucket metadata: %w", err)
+		}
+		bucketInfos := make(map[string]models.BucketInfo, len(infos))
+		for _, info := range infos {
+			bucketInfos[info.Name] = info
+		}
+
+		var groups *groupResolver
+		if len(p.Groups) > 0 {
+			if groups, err = loadGroups(database); err != nil {
+				return err
+			}
+		}
+
+		lines := make([]pricing.Line, 0, len(averages))
+		var unrecorded []string
+		for _, avg := range averages {
+			placement, recorded := averagePlacement(avg, bucketInfos)
+			if !recorded {
+				unrecorded = append(unrecorded, avg.BucketName)
+			}
+			line := pricing.Line{Average: avg, Owner: averageOwner(avg, bucketInfos), Placement: placement}
+			if groups != nil {
+				line.Groups = groups.groupsOf(avg.BucketName, line.Owner)
+			}
+			lines = append(lines, line)
+		}
+		warnUnrecordedPlacements(p, month, unrecorded)
+		items := p.Costs(lines)
+
+		return render(items, func() {
+			printCosts(p, month, items)
+		})
+	},
+}
+
+// printCosts prints the cost of each bucket as a table with totals
+func printCosts(p *pricing.Pricing, month time.Time, items []models.CostItem) {
+	label := month.Format("2006-01")
+	if len(items) == 0 {
+		fmt.Printf("No data available for %s\n", label)
+		return
+	}
+
+	// Print the results
+	fmt.Printf("Cost for %s\n\n", label)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "Bucket\tOwner\tPlacement\tRates\tAvg Size\tAvg Objects\tStorage\tObjects\tMinimum\tTotal")
+	fmt.Fprintln(w, "------\t-----\t---------\t-----\t--------\t-----------\t-------\t-------\t-------\t-----")
+
+	var storage, objects, minimum, total float64
+	for _, item := range items {
+		storage += item.StorageCost
+		objects += item.ObjectCost
+		minimum += item.MinimumCharge
+		total += item.Total
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%.0f\t%s\t%s\t%s\t%s\n",
+			item.BucketName,
+			item.Owner,
+			item.Placement,
+			item.Rates,
+			formatSize(item.AvgSizeBytes),
+			item.AvgObjectCount,
+			formatAmount(p, item.StorageCost),
+			formatAmount(p, item.ObjectCost),
+			formatAmount(p, item.MinimumCharge),
+			formatAmount(p, item.Total),
+		)
+	}
+
+	fmt.Fprintln(w, "\t\t\t\t\t\t\t\t\t")
+	fmt.Fprintf(w, "Total\t\t\t\t\t\t%s\t%s\t%s\t%s\n",
+		formatAmount(p, storage),
+		formatAmount(p, objects),
+		formatAmount(p, minimum),
+		formatAmount(p, total),
+	)
+	w.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(costCmd)
+
+	// Add flags to the cost command
+	costCmd.Flags().StringVar(&costMonth, "month", "", "Month to calculate the cost of (YYYY-MM, default: previous month)")
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+var (
+	// Flag to overwrite an existing backup file
+	overwrite bool
+)
+
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Database maintenance commands",
+	Long:  `Commands to back up the database and to check its integrity.`,
+}
+
+var dbBackupCmd = &cobra.Command{
+	Use:   "backup [path]",
+	Short: "Create a consistent backup of the database",
+	Long: `Write a consistent copy of the database to the given path using SQLite's
+online backup API. Unlike copying the file, this is safe while collect or other
+commands are writing to the database.
+
+The backup is written to a temporary file next to the given path and only
+renamed to it once it is complete, so an existing backup replaced with --force
+is kept if the backup fails.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+
+		if _, err := os.Stat(path); err == nil && !overwrite {
+			return configErrorf("%s already exists, use --force to overwrite it", path)
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		// Write to a temporary file in the same directory, so renaming it
+		// replaces an existing backup atomically
+		tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+		if err != nil {
+			return fmt.Errorf("failed to create temporary backup file: %w", err)
+		}
+		tmpPath := tmp.Name()
+		tmp.Close()
+		defer os.Remove(tmpPath)
+
+		fmt.Printf("Backing up database to %s...\n", path)
+		if err := database.Backup(context.Background(), tmpPath); err != nil {
+			return dbErrorf("failed to back up database: %w", err)
+		}
+		if err := os.Rename(tmpPath, path); err != nil {
+			return fmt.Errorf("failed to move backup into place: %w", err)
+		}
+		fmt.Println("Backup completed successfully.")
+		return nil
+	},
+}
+
+var dbCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Check the integrity of the database",
+	Long: `Run SQLite's integrity check followed by application level checks for
+duplicate samples, negative sizes, monthly averages without any underlying data
+and orphaned rollups. Monthly averages without data are expected after pruning
+and only reported for information. Exits with a non-zero status if an error is
+found; warnings and information are reported but do not fail the check.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		// Progress is only reported in table output
+		progress := func(msg string) {
+			if !machineOutput() {
+				fmt.Println(msg)
+			}
+		}
+
+		progress("Running integrity check...")
+		problems, err := database.IntegrityCheck()
+		if err != nil {
+			return dbErrorf("failed to run integrity check: %w", err)
+		}
+		var issues []models.CheckIssue
+		for _, problem := range problems {
+			issues = append(issues, models.CheckIssue{Check: "integrity", Severity: models.SeverityError, Message: problem})
+		}
+
+		// The application level checks are meaningless on a corrupt file
+		if len(problems) == 0 {
+			progress("Running consistency checks...")
+			found, err := database.CheckConsistency()
+			if err != nil {
+				return dbErrorf("failed to run consistency checks: %w", err)
+			}
+			issues = append(issues, found...)
+		}
+
+		err = render(issues, func() {
+			for _, issue := range issues {
+				if issue.Check == "integrity" {
+					fmt.Printf("  error: %s\n", issue.Message)
+				} else {
+					fmt.Printf("  %s [%s]: %s\n", issue.Severity, issue.Check, issue.Message)
+				}
+			}
+			if len(issues) == 0 {
+				fmt.Println("No problems found.")
+			}
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(problems) > 0 {
+			return dbErrorf("integrity check failed with %d problems", len(problems))
+		}
+		errors := 0
+		for _, issue := range issues {
+			if issue.Severity == models.SeverityError {
+				errors++
+			}
+		}
+		if errors > 0 {
+			return dbErrorf("found %d errors", errors)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(dbCmd)
+	dbCmd.AddCommand(dbBackupCmd)
+	dbCmd.AddCommand(dbCheckCmd)
+
+	// Add flags to the backup command
+	dbBackupCmd.Flags().BoolVar(&overwrite, "force", false, "Overwrite an existing backup file")
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Only show events of this bucket
+	eventsBucket string
+	// Only show events on or after this date
+	eventsSince string
+)
+
+var eventsCmd = &cobra.Command{
+	Use:   "events",
+	Short: "Show bucket lifecycle events",
+	Long: `Display when buckets were created, deleted or reappeared.
+
+Every collection compares the buckets listed by the cluster with the buckets seen
+by previous runs. A bucket missing from a successful listing is recorded as
+deleted, so a deleted bucket can be told apart from a failed collection. Events
+are timestamped with the run that detected them; for deleted buckets the time
+they were last seen is shown as well.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var since time.Time
+		if eventsSince != "" {
+			var err error
+			if since, err = parseDate(eventsSince); err != nil {
+				return configErrorf("invalid --since date: %w", err)
+			}
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		events, err := database.GetBucketEvents(eventsBucket, since, time.Now())
+		if err != nil {
+			return dbErrorf("failed to retrieve bucket events: %w", err)
+		}
+
+		return render(events, func() {
+			if len(events) == 0 {
+				fmt.Println("No bucket events recorded")
+				return
+			}
+
+			// Print the results
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+			fmt.Fprintln(w, "Date\tBucket\tEvent\tRun\tDetails")
+			fmt.Fprintln(w, "----\t------\t-----\t---\t-------")
+
+			for _, event := range events {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n",
+					event.Timestamp.Local().Format("2006-01-02 15:04:05"),
+					event.BucketName,
+					event.Type,
+					event.RunID,
+					event.Details,
+				)
+			}
+			w.Flush()
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(eventsCmd)
+
+	// Add flags to the events command
+	eventsCmd.Flags().StringVar(&eventsBucket, "bucket", "", "Only show events of this bucket")
+	eventsCmd.Flags().StringVar(&eventsSince, "since", "", "Only show events on or after this date (YYYY-MM-DD)")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit codes of the s3usage command
+const (
+	// exitOK means the command completed successfully
+	exitOK = 0
+	// exitFailure is used for errors that do not fall into another category
+	exitFailure = 1
+	// exitConfig means the configuration or the command line arguments are invalid
+	exitConfig = 2
+	// exitAPI means the Ceph RGW Admin API could not be used
+	exitAPI = 3
+	// exitPartial means a collection run succeeded only for some buckets
+	exitPartial = 4
+	// exitDB means the database could not be read or written
+	exitDB = 5
+)
+
+// exitError is an error that determines the exit code of the command
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the exit code for an error returned by a command
+func exitCode(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	var e *exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitFailure
+}
+
+// configErrorf returns an error for invalid configuration or arguments
+func configErrorf(format string, args ...interface{}) error {
+	return &exitError{code: exitConfig, err: fmt.Errorf(format, args...)}
+}
+
+// apiErrorf returns an error for failed Admin API requests
+func apiErrorf(format string, args ...interface{}) error {
+	return &exitError{code: exitAPI, err: fmt.Errorf(format, args...)}
+}
+
+// partialErrorf returns an error for collection runs in which some buckets failed
+func partialErrorf(format string, args ...interface{}) error {
+	return &exitError{code: exitPartial, err: fmt.Errorf(format, args...)}
+}
+
+// dbErrorf returns an error for failed database operations
+func dbErrorf(format string, args ...interface{}) error {
+	return &exitError{code: exitDB, err: fmt.Errorf(format, args...)}
+}
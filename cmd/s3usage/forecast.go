@@ -0,0 +1,311 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/db"
+	"github.com/thannaske/s3usage/pkg/forecast"
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+var (
+	// Number of months to project ahead
+	forecastMonths int
+	// Period of history the trend is fitted to
+	forecastHistory string
+	// Fit a weekly seasonality
+	forecastWeekly bool
+	// Confidence of the prediction interval in percent
+	forecastConfidence float64
+	// Size every bucket is checked against in addition to its quota
+	forecastThreshold string
+	// Size the total of all buckets is checked against
+	forecastCapacity string
+)
+
+// zScores maps the supported confidence levels to the z score of the
+// prediction interval
+var zScores = map[float64]float64{
+	80: 1.2816,
+	90: 1.6449,
+	95: 1.9600,
+	99: 2.5758,
+}
+
+// limitHorizon is how far ahead forecasts look for the day a limit is reached
+const limitHorizon = 10 * 365 * 24 * time.Hour
+
+// totalName is how the total of all buckets is shown in the forecast table
+const totalName = "(all buckets)"
+
+var forecastCmd = &cobra.Command{
+	Use:   "forecast [bucket...]",
+	Short: "Forecast bucket usage and quota exhaustion",
+	Long: `Project the size of buckets into the future and estimate when they reach
+their quota or a threshold.
+
+A linear trend is fitted to the last value of each day within --history (default
+90 days) and projected --months ahead. The prediction interval shows the range the
+size is expected to be in with the given --confidence. With --weekly, a weekly
+seasonality is fitted as well if the history covers at least two weeks.
+
+The projection is checked against the bucket's quota, as reported by the cluster
+during collection, and the --threshold if given, whichever is smaller. The table
+shows the day the projected size reaches the limit and, in parentheses, the day
+the upper bound of the prediction interval reaches it. Limits are looked for up
+to ten years ahead.
+
+Without bucket names all buckets with data are forecast, followed by the total of
+all buckets, which is checked against --capacity. Buckets with fewer than three
+daily values are skipped.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if forecastMonths < 1 {
+			return configErrorf("--months must be at least 1")
+		}
+		history, err := parseDuration(forecastHistory)
+		if err != nil || history <= 0 {
+			return configErrorf("invalid --history %q, use e.g. 30d or 90d", forecastHistory)
+		}
+		z, ok := zScores[forecastConfidence]
+		if !ok {
+			return configErrorf("invalid --confidence %g, use 80, 90, 95 or 99", forecastConfidence)
+		}
+		var threshold, capacity int64
+		if forecastThreshold != "" {
+			if threshold, err = parseSize(forecastThreshold); err != nil {
+				return configErrorf("invalid --threshold: %w", err)
+			}
+		}
+		if forecastCapacity != "" {
+			if capacity, err = parseSize(forecastCapacity); err != nil {
+				return configErrorf("invalid --capacity: %w", err)
+			}
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		now := time.Now().UTC()
+		opts := models.HistoryOptions{
+			Start:      now.Add(-history),
+			End:        now,
+			Resolution: models.ResolutionDaily,
+			Function:   models.AggregateLast,
+		}
+
+		buckets := args
+		if len(buckets) == 0 {
+			if buckets, err = database.GetBucketsWithData(opts.Start, opts.End); err != nil {
+				return dbErrorf("failed to find buckets: %w", err)
+			}
+		}
+
+		infos, err := database.GetBucketInfos()
+		if err != nil {
+			return dbErrorf("failed to retrieve bucket metadata: %w", err)
+		}
+		quotas := make(map[string]int64, len(infos))
+		for _, info := range infos {
+			quotas[info.Name] = info.QuotaSizeBytes
+		}
+
+		f := forecaster{now: now, months: forecastMonths, weekly: forecastWeekly, z: z}
+		var forecasts []models.Forecast
+		var skipped []string
+		for _, bucket := range buckets {
+			points, err := database.GetHistory(bucket, opts)
+			if err != nil {
+				return dbErrorf("failed to retrieve history of bucket %s: %w", bucket, err)
+			}
+			values := make([]forecast.Point, len(points))
+			for i, p := range points {
+				values[i] = forecast.Point{Time: p.Timestamp, Value: p.SizeBytes}
+			}
+
+			limit, source := limitOf(quotas[bucket], threshold)
+			fc, err := f.forecast(values, limit, source)
+			if err != nil {
+				skipped = append(skipped, bucket)
+				continue
+			}
+			fc.BucketName = bucket
+			forecasts = append(forecasts, fc)
+		}
+
+		// Forecast the total of all buckets
+		if len(args) == 0 && len(buckets) > 0 {
+			total, err := f.total(database, buckets, opts, capacity)
+			if err == nil {
+				forecasts = append(forecasts, total)
+			} else if !errors.Is(err, forecast.ErrTooFewPoints) {
+				return err
+			}
+		}
+
+		// Buckets that reach their limit first are listed first
+		sort.SliceStable(forecasts, func(i, j int) bool {
+			a, b := forecasts[i].ReachesLimit, forecasts[j].ReachesLimit
+			if a == nil || b == nil {
+				return a != nil
+			}
+			return a.Before(*b)
+		})
+
+		return render(forecasts, func() {
+			printForecasts(forecasts, skipped)
+		})
+	},
+}
+
+// limitOf returns the smaller of a bucket's quota and the threshold, zero
+// values are not set
+func limitOf(quota, threshold int64) (int64, string) {
+	switch {
+	case quota > 0 && (threshold == 0 || quota <= threshold):
+		return quota, models.LimitQuota
+	case threshold > 0:
+		return threshold, models.LimitThreshold
+	}
+	return 0, ""
+}
+
+// forecaster projects daily values with the settings of the forecast command
+type forecaster struct {
+	now    time.Time
+	months int
+	weekly bool
+	z      float64
+}
+
+// forecast fits a trend to the values and projects it
+func (f forecaster) forecast(values []forecast.Point, limit int64, source string) (models.Forecast, error) {
+	model, err := forecast.Fit(values, f.weekly)
+	if err != nil {
+		return models.Forecast{}, err
+	}
+
+	name := models.ForecastLinear
+	if model.Seasonal != [7]float64{} {
+		name = models.ForecastWeekly
+	}
+	at := f.now.AddDate(0, f.months, 0)
+	p := model.Project(at, f.z)
+	fc := models.Forecast{
+		Model:             name,
+		Samples:           len(values),
+		CurrentSizeBytes:  values[len(values)-1].Value,
+		GrowthBytesPerDay: model.Slope,
+		ForecastTime:      at,
+		ForecastSizeBytes: p.Value,
+		LowerSizeBytes:    p.Lower,
+		UpperSizeBytes:    p.Upper,
+		ConfidencePct:     forecastConfidence,
+		LimitBytes:        limit,
+		LimitSource:       source,
+	}
+
+	if limit > 0 {
+		until := f.now.Add(limitHorizon)
+		if t := model.Reaches(float64(limit), f.now, until, f.z, false); !t.IsZero() {
+			fc.ReachesLimit = &t
+		}
+		if t := model.Reaches(float64(limit), f.now, until, f.z, true); !t.IsZero() {
+			fc.EarliestReachesLimit = &t
+		}
+	}
+	return fc, nil
+}
+
+// total forecasts the sum of the daily values of all buckets
+func (f forecaster) total(database *db.DB, buckets []string, opts models.HistoryOptions, capacity int64) (models.Forecast, error) {
+	points, err := database.GetGroupHistory(totalName, buckets, opts)
+	if err != nil {
+		return models.Forecast{}, dbErrorf("failed to retrieve total history: %w", err)
+	}
+	values := make([]forecast.Point, len(points))
+	for i, p := range points {
+		values[i] = forecast.Point{Time: p.Timestamp, Value: p.SizeBytes}
+	}
+
+	source := ""
+	if capacity > 0 {
+		source = models.LimitThreshold
+	}
+	fc, err := f.forecast(values, capacity, source)
+	fc.Total = true
+	return fc, err
+}
+
+// formatLimitDate formats the day a limit is reached
+func formatLimitDate(t *time.Time) string {
+	if t == nil {
+		return "never"
+	}
+	return t.Local().Format("2006-01-02")
+}
+
+// printForecasts prints the forecasts as a table
+func printForecasts(forecasts []models.Forecast, skipped []string) {
+	if len(forecasts) == 0 {
+		fmt.Println("Not enough usage data to forecast, at least three days are required")
+		return
+	}
+
+	// Print the results
+	first := forecasts[0]
+	fmt.Printf("Usage Forecast for %s (%.0f%% prediction interval)\n\n",
+		first.ForecastTime.Local().Format("2006-01-02"), first.ConfidencePct)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "Bucket\tDays\tCurrent\tPer Day\tForecast\tRange\tLimit\tReaches Limit")
+	fmt.Fprintln(w, "------\t----\t-------\t-------\t--------\t-----\t-----\t-------------")
+
+	for _, fc := range forecasts {
+		name := fc.BucketName
+		if fc.Total {
+			name = totalName
+		}
+		limit, reaches := "-", "-"
+		if fc.LimitBytes > 0 {
+			limit = fmt.Sprintf("%s (%s)", formatSize(float64(fc.LimitBytes)), fc.LimitSource)
+			reaches = fmt.Sprintf("%s (%s)", formatLimitDate(fc.ReachesLimit), formatLimitDate(fc.EarliestReachesLimit))
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s - %s\t%s\t%s\n",
+			name,
+			fc.Samples,
+			formatSize(fc.CurrentSizeBytes),
+			formatSizeChange(fc.GrowthBytesPerDay),
+			formatSize(max(fc.ForecastSizeBytes, 0)),
+			formatSize(max(fc.LowerSizeBytes, 0)),
+			formatSize(max(fc.UpperSizeBytes, 0)),
+			limit,
+			reaches,
+		)
+	}
+	w.Flush()
+
+	if len(skipped) > 0 {
+		fmt.Printf("\nSkipped %d buckets with fewer than three days of data\n", len(skipped))
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(forecastCmd)
+
+	// Add flags to the forecast command
+	forecastCmd.Flags().IntVar(&forecastMonths, "months", 3, "Number of months to project ahead")
+	forecastCmd.Flags().StringVar(&forecastHistory, "history", "90d", "Period of history the trend is fitted to")
+	forecastCmd.Flags().BoolVar(&forecastWeekly, "weekly", false, "Fit a weekly seasonality in addition to the trend")
+	forecastCmd.Flags().Float64Var(&forecastConfidence, "confidence", 95, "Confidence of the prediction interval in percent: 80, 90, 95 or 99")
+	forecastCmd.Flags().StringVar(&forecastThreshold, "threshold", "", "Size every bucket is checked against in addition to its quota, e.g. 500GB")
+	forecastCmd.Flags().StringVar(&forecastCapacity, "capacity", "", "Size the total of all buckets is checked against, e.g. 100TB")
+}
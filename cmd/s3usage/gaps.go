@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	// Month to examine
+	gapsMonth string
+	// Only examine this bucket
+	gapsBucket string
+)
+
+var gapsCmd = &cobra.Command{
+	Use:   "gaps",
+	Short: "Show periods with missing samples",
+	Long: `List the periods of a month in which samples of a bucket are missing.
+
+A period without samples counts as a gap once it is longer than one and a half
+collection intervals (--collection-interval, default 24h). Time before a bucket
+was created and after it was deleted is not counted. Only buckets with at least
+one sample in the month are examined. Where retention removed the raw samples,
+the hourly and daily rollups stand in for them like in the monthly averages, so
+gaps agree with the coverage shown by list.
+
+Without --month the previous month is examined.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		agg, err := aggregationOptions()
+		if err != nil {
+			return configErrorf("%w", err)
+		}
+
+		var start time.Time
+		if gapsMonth != "" {
+			if start, err = parseDate(gapsMonth); err != nil {
+				return configErrorf("invalid month %q: %w", gapsMonth, err)
+			}
+		} else {
+			now := time.Now().UTC()
+			start = time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		gaps, err := database.FindGaps(start.Year(), int(start.Month()), gapsBucket, agg)
+		if err != nil {
+			return dbErrorf("failed to find gaps: %w", err)
+		}
+
+		return render(gaps, func() {
+			if len(gaps) == 0 {
+				fmt.Printf("No gaps found in %d-%02d\n", start.Year(), start.Month())
+				return
+			}
+
+			// Print the results
+			fmt.Printf("Missing Samples in %d-%02d (expected every %s)\n\n", start.Year(), start.Month(), agg.Interval)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+			fmt.Fprintln(w, "Bucket\tFrom\tTo\tDuration")
+			fmt.Fprintln(w, "------\t----\t--\t--------")
+
+			for _, gap := range gaps {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n",
+					gap.BucketName,
+					gap.Start.Local().Format("2006-01-02 15:04:05"),
+					gap.End.Local().Format("2006-01-02 15:04:05"),
+					gap.End.Sub(gap.Start).Round(time.Minute),
+				)
+			}
+			w.Flush()
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(gapsCmd)
+
+	// Add flags to the gaps command
+	addIntervalFlag(gapsCmd)
+	gapsCmd.Flags().StringVar(&gapsMonth, "month", "", "Month to examine (YYYY-MM, default: previous month)")
+	gapsCmd.Flags().StringVar(&gapsBucket, "bucket", "", "Only examine this bucket")
+}
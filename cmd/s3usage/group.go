@@ -0,0 +1,264 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/ceph"
+	"github.com/thannaske/s3usage/pkg/db"
+)
+
+// ungrouped is the name under which buckets without a group are reported
+const ungrouped = "(ungrouped)"
+
+// groupResolver maps buckets to the groups defined in the config file and in
+// the mapping table of the database
+type groupResolver struct {
+	patterns map[string]*ceph.BucketFilter
+	owners   map[string]map[string]bool
+	explicit map[string]map[string]bool
+	// bucketOwners is the owner of each bucket as last reported by the cluster
+	bucketOwners map[string]string
+}
+
+// loadGroups builds the group definitions from the config file and database
+func loadGroups(database *db.DB) (*groupResolver, error) {
+	r := &groupResolver{
+		patterns:     make(map[string]*ceph.BucketFilter),
+		owners:       make(map[string]map[string]bool),
+		explicit:     make(map[string]map[string]bool),
+		bucketOwners: make(map[string]string),
+	}
+	addExplicit := func(group string, buckets []string) {
+		if r.explicit[group] == nil {
+			r.explicit[group] = make(map[string]bool)
+		}
+		for _, b := range buckets {
+			r.explicit[group][b] = true
+		}
+	}
+
+	for name, group := range config.Groups {
+		if len(group.Patterns) > 0 {
+			filter, err := ceph.NewBucketFilter(group.Patterns, nil, nil)
+			if err != nil {
+				return nil, configErrorf("invalid patterns of group %s: %w", name, err)
+			}
+			r.patterns[name] = filter
+		}
+		if len(group.Owners) > 0 {
+			r.owners[name] = make(map[string]bool)
+			for _, owner := range group.Owners {
+				r.owners[name][owner] = true
+			}
+		}
+		addExplicit(name, group.Buckets)
+	}
+
+	members, err := database.GetGroupMembers()
+	if err != nil {
+		return nil, dbErrorf("failed to retrieve group members: %w", err)
+	}
+	for name, buckets := range members {
+		addExplicit(name, buckets)
+	}
+
+	infos, err := database.GetBucketInfos()
+	if err != nil {
+		return nil, dbErrorf("failed to retrieve bucket owners: %w", err)
+	}
+	for _, info := range infos {
+		r.bucketOwners[info.Name] = info.Owner
+	}
+	return r, nil
+}
+
+// names returns the names of all defined groups in alphabetical order
+func (r *groupResolver) names() []string {
+	seen := make(map[string]bool)
+	for name := range r.patterns {
+		seen[name] = true
+	}
+	for name := range r.owners {
+		seen[name] = true
+	}
+	for name := range r.explicit {
+		seen[name] = true
+	}
+
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// contains reports whether the bucket belongs to the group
+func (r *groupResolver) contains(group, bucket string) bool {
+	return r.containsOwned(group, bucket, "")
+}
+
+// containsOwned reports whether the bucket belongs to the group when owned by
+// the given owner, or by its current owner if owner is empty
+func (r *groupResolver) containsOwned(group, bucket, owner string) bool {
+	if r.explicit[group][bucket] {
+		return true
+	}
+	if owner == "" {
+		owner = r.bucketOwners[bucket]
+	}
+	if owner != "" && r.owners[group][owner] {
+		return true
+	}
+	if filter, ok := r.patterns[group]; ok && filter.Match(bucket) {
+		return true
+	}
+	return false
+}
+
+// groupsOf returns the groups a bucket belongs to when owned by the given
+// owner, or by its current owner if owner is empty. A bucket can belong to
+// several groups.
+func (r *groupResolver) groupsOf(bucket, owner string) []string {
+	var groups []string
+	for _, name := range r.names() {
+		if r.containsOwned(name, bucket, owner) {
+			groups = append(groups, name)
+		}
+	}
+	return groups
+}
+
+// members returns the buckets of the group among the given buckets
+func (r *groupResolver) members(group string, buckets []string) []string {
+	var members []string
+	for _, bucket := range buckets {
+		if r.contains(group, bucket) {
+			members = append(members, bucket)
+		}
+	}
+	return members
+}
+
+// knownBuckets returns the names of all buckets seen by collection runs
+func (r *groupResolver) knownBuckets() []string {
+	buckets := make([]string, 0, len(r.bucketOwners))
+	for name := range r.bucketOwners {
+		buckets = append(buckets, name)
+	}
+	sort.Strings(buckets)
+	return buckets
+}
+
+var groupCmd = &cobra.Command{
+	Use:   "group",
+	Short: "Manage bucket groups",
+	Long: `Commands to show and manage groups of buckets, e.g. projects or customers.
+
+Groups are defined in the config file by bucket name patterns, owners or explicit
+bucket lists, or with group add in the database. A bucket belongs to every group
+it matches. Groups are used by list --by group and history --group.`,
+}
+
+var groupListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List groups and their buckets",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		groups, err := loadGroups(database)
+		if err != nil {
+			return err
+		}
+
+		buckets := groups.knownBuckets()
+		var list []groupMembers
+		for _, name := range groups.names() {
+			list = append(list, groupMembers{Group: name, Buckets: groups.members(name, buckets)})
+		}
+
+		return render(list, func() {
+			if len(list) == 0 {
+				fmt.Println("No groups defined")
+				return
+			}
+
+			// Print the results
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+			fmt.Fprintln(w, "Group\tBuckets\tMembers")
+			fmt.Fprintln(w, "-----\t-------\t-------")
+
+			for _, g := range list {
+				fmt.Fprintf(w, "%s\t%d\t%s\n", g.Group, len(g.Buckets), strings.Join(g.Buckets, ", "))
+			}
+			w.Flush()
+		})
+	},
+}
+
+// groupMembers is a group with the buckets that currently belong to it
+type groupMembers struct {
+	Group   string   `json:"group"`
+	Buckets []string `json:"buckets"`
+}
+
+var groupAddCmd = &cobra.Command{
+	Use:   "add [group] [bucket...]",
+	Short: "Add buckets to a group",
+	Args:  cobra.MinimumNArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		if err := database.AddGroupMembers(args[0], args[1:]); err != nil {
+			return dbErrorf("%w", err)
+		}
+		fmt.Printf("Added %d buckets to group %s.\n", len(args)-1, args[0])
+		return nil
+	},
+}
+
+var groupRemoveCmd = &cobra.Command{
+	Use:   "remove [group] [bucket...]",
+	Short: "Remove buckets from a group",
+	Long: `Remove buckets from a group in the database. Without buckets, all buckets
+are removed from the group. Groups defined in the config file are not changed.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		removed, err := database.RemoveGroupMembers(args[0], args[1:])
+		if err != nil {
+			return dbErrorf("%w", err)
+		}
+		fmt.Printf("Removed %d buckets from group %s.\n", removed, args[0])
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(groupCmd)
+	groupCmd.AddCommand(groupListCmd)
+	groupCmd.AddCommand(groupAddCmd)
+	groupCmd.AddCommand(groupRemoveCmd)
+}
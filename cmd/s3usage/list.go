@@ -2,20 +2,87 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"math"
 	"os"
 	"sort"
+	"strings"
 	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/thannaske/s3usage/pkg/db"
+	"github.com/thannaske/s3usage/pkg/models"
 )
 
 var (
 	year  int
 	month int
+	// Coverage in percent below which a monthly average is marked as low confidence
+	minCoverage float64
+	// Whether to list buckets or groups
+	listBy string
+	// Group to show the history of
+	historyGroup string
+	// Time range, resolution and aggregation function of the history
+	historyFrom       string
+	historyTo         string
+	historyResolution string
+	historyFunction   string
+	// Draw the history as a chart instead of a table
+	historyChart bool
 )
 
+// historyResolutions maps the values of --resolution to resolutions
+var historyResolutions = map[string]string{
+	"raw":   models.ResolutionRaw,
+	"hour":  models.ResolutionHourly,
+	"day":   models.ResolutionDaily,
+	"week":  models.ResolutionWeekly,
+	"month": models.ResolutionMonthly,
+}
+
+// historyOptions builds the history options from the command line flags. By
+// default the history covers the current and the previous twelve months.
+func historyOptions() (models.HistoryOptions, error) {
+	now := time.Now().UTC()
+	opts := models.HistoryOptions{
+		Start:    time.Date(now.Year()-1, now.Month(), 1, 0, 0, 0, 0, time.UTC),
+		End:      time.Date(now.Year(), now.Month()+1, 0, 23, 59, 59, 0, time.UTC),
+		Function: historyFunction,
+	}
+
+	var err error
+	if historyFrom != "" {
+		if opts.Start, err = parseTime(historyFrom, now); err != nil {
+			return opts, fmt.Errorf("invalid --from: %w", err)
+		}
+	}
+	if historyTo != "" {
+		if opts.End, err = parseEndTime(historyTo, now); err != nil {
+			return opts, fmt.Errorf("invalid --to: %w", err)
+		}
+	}
+	if !opts.Start.Before(opts.End) {
+		return opts, fmt.Errorf("--from must be before --to")
+	}
+
+	if historyResolution != "" {
+		resolution, ok := historyResolutions[historyResolution]
+		if !ok {
+			return opts, fmt.Errorf("invalid resolution %q, use raw, hour, day, week or month", historyResolution)
+		}
+		opts.Resolution = resolution
+	}
+
+	switch historyFunction {
+	case models.AggregateAvg, models.AggregateMax, models.AggregateLast:
+	default:
+		return opts, fmt.Errorf("invalid aggregation function %q, use avg, max or last", historyFunction)
+	}
+	return opts, nil
+}
+
 // formatSize converts bytes to a human-readable format
 func formatSize(bytes float64) string {
 	const (
@@ -59,8 +126,19 @@ func formatSize(bytes float64) string {
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "List monthly bucket usage",
-	Long:  `Display monthly average usage statistics for all buckets.`,
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `Display monthly average usage statistics for all buckets.
+
+The Coverage column shows how much of the month is covered by samples, based on
+the configured collection interval. Averages with a coverage below --min-coverage
+are marked as low confidence; use the gaps command to see which periods are missing.
+If gaps were filled when calculating an average, the number of synthetic points and
+the fill method are shown next to the number of samples. The last column shows the
+trend of the average size over the last twelve months up to the listed month,
+scaled between the smallest and largest average of each bucket.
+
+With --by group the averages of the buckets of each group are summed up, see the
+group command. Buckets belonging to several groups are counted in each of them.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
 		// If no year/month specified, use previous month
 		now := time.Now()
 		if year == 0 {
@@ -78,28 +156,32 @@ var listCmd = &cobra.Command{
 
 		// Validate month
 		if month < 1 || month > 12 {
-			fmt.Println("Error: Month must be between 1 and 12.")
-			return
+			return configErrorf("month must be between 1 and 12")
+		}
+
+		if listBy != "bucket" && listBy != "group" {
+			return configErrorf("invalid --by %q, use bucket or group", listBy)
 		}
 
 		// Initialize the database
-		database, err := db.NewDB(config.DBPath)
+		database, err := openDatabase()
 		if err != nil {
-			fmt.Printf("Error connecting to database: %v\n", err)
-			return
+			return err
 		}
 		defer database.Close()
 
 		// Get monthly averages
 		averages, err := database.GetAllMonthlyAverages(year, month)
 		if err != nil {
-			fmt.Printf("Error retrieving monthly averages: %v\n", err)
-			return
+			return dbErrorf("failed to retrieve monthly averages: %w", err)
 		}
 
-		if len(averages) == 0 {
-			fmt.Printf("No data available for %d-%02d\n", year, month)
-			return
+		if listBy == "group" {
+			groups, err := loadGroups(database)
+			if err != nil {
+				return err
+			}
+			return printGroupAverages(groups, averages)
 		}
 
 		// Sort by size (largest first)
@@ -107,72 +189,440 @@ var listCmd = &cobra.Command{
 			return averages[i].AvgSizeBytes > averages[j].AvgSizeBytes
 		})
 
-		// Print the results
-		fmt.Printf("Monthly Average Usage for %d-%02d\n\n", year, month)
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
-		fmt.Fprintln(w, "Bucket\tSize\tObjects\tSamples")
-		fmt.Fprintln(w, "------\t----\t-------\t-------")
-
-		for _, avg := range averages {
-			fmt.Fprintf(w, "%s\t%s\t%d\t%d\n",
-				avg.BucketName,
-				formatSize(avg.AvgSizeBytes),
-				int(avg.AvgObjectCount),
-				avg.DataPoints,
-			)
-		}
-		w.Flush()
+		// The trend is only shown in the table
+		var trends map[string]string
+		if !machineOutput() {
+			if trends, err = monthlyTrends(database, year, month); err != nil {
+				return err
+			}
+		}
+
+		return render(averages, func() {
+			printAverages(averages, trends)
+		})
 	},
 }
 
+// trendMonths is the number of months shown in the trend column of the list
+const trendMonths = 12
+
+// monthlyTrends returns a sparkline of the average size of each bucket over
+// the months up to and including the given month
+func monthlyTrends(database *db.DB, year, month int) (map[string]string, error) {
+	first := time.Date(year, time.Month(month)-trendMonths+1, 1, 0, 0, 0, 0, time.UTC)
+	averages, err := database.GetMonthlyAveragesBetween(first.Year(), int(first.Month()), year, month)
+	if err != nil {
+		return nil, dbErrorf("failed to retrieve monthly averages: %w", err)
+	}
+
+	values := make(map[string][]float64)
+	for _, avg := range averages {
+		v, ok := values[avg.BucketName]
+		if !ok {
+			v = make([]float64, trendMonths)
+			for i := range v {
+				v[i] = math.NaN()
+			}
+			values[avg.BucketName] = v
+		}
+		v[avg.Year*12+avg.Month-(first.Year()*12+int(first.Month()))] = avg.AvgSizeBytes
+	}
+
+	trends := make(map[string]string, len(values))
+	for bucket, v := range values {
+		trends[bucket] = sparkline(v)
+	}
+	return trends, nil
+}
+
+// printAverages prints the monthly averages of the buckets as a table
+// together with the trend of each bucket
+func printAverages(averages []models.MonthlyBucketAverage, trends map[string]string) {
+	if len(averages) == 0 {
+		fmt.Printf("No data available for %d-%02d\n", year, month)
+		return
+	}
+
+	// Print the results
+	fmt.Printf("Monthly Average Usage for %d-%02d\n\n", year, month)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "Bucket\tSize\tObjects\tSamples\tCoverage\tLast 12 Months")
+	fmt.Fprintln(w, "------\t----\t-------\t-------\t--------\t--------------")
+
+	lowConfidence := 0
+	for _, avg := range averages {
+		coverage := "n/a"
+		if avg.CoveragePct != nil {
+			coverage = fmt.Sprintf("%.1f%%", *avg.CoveragePct)
+			if *avg.CoveragePct < minCoverage {
+				coverage += " (low)"
+				lowConfidence++
+			}
+		}
+		samples := fmt.Sprintf("%d", avg.DataPoints)
+		if avg.SyntheticPoints > 0 {
+			samples += fmt.Sprintf(" (+%d %s)", avg.SyntheticPoints, avg.FillMethod)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\n",
+			avg.BucketName,
+			formatSize(avg.AvgSizeBytes),
+			int(avg.AvgObjectCount),
+			samples,
+			coverage,
+			trends[avg.BucketName],
+		)
+	}
+	w.Flush()
+
+	if lowConfidence > 0 {
+		fmt.Printf("\n%d averages are based on less than %.0f%% coverage, see s3usage gaps --month %d-%02d\n",
+			lowConfidence, minCoverage, year, month)
+	}
+}
+
+// groupAverage is the sum of the monthly averages of the buckets of a group
+type groupAverage struct {
+	Group          string   `json:"group"`
+	Year           int      `json:"year"`
+	Month          int      `json:"month"`
+	AvgSizeBytes   float64  `json:"avg_size_bytes"`
+	AvgObjectCount float64  `json:"avg_object_count"`
+	Buckets        int      `json:"buckets"`
+	MinCoveragePct *float64 `json:"min_coverage_pct"`
+}
+
+// printGroupAverages sums the monthly averages per group and prints them.
+// Buckets belonging to several groups are counted in each of them.
+func printGroupAverages(groups *groupResolver, averages []models.MonthlyBucketAverage) error {
+	sums := make(map[string]*groupAverage)
+	for _, avg := range averages {
+		names := groups.groupsOf(avg.BucketName, avg.Owner)
+		if len(names) == 0 {
+			names = []string{ungrouped}
+		}
+		for _, name := range names {
+			sum, ok := sums[name]
+			if !ok {
+				sum = &groupAverage{Group: name, Year: year, Month: month}
+				sums[name] = sum
+			}
+			sum.AvgSizeBytes += avg.AvgSizeBytes
+			sum.AvgObjectCount += avg.AvgObjectCount
+			sum.Buckets++
+			if avg.CoveragePct != nil && (sum.MinCoveragePct == nil || *avg.CoveragePct < *sum.MinCoveragePct) {
+				sum.MinCoveragePct = avg.CoveragePct
+			}
+		}
+	}
+
+	// Sort by size (largest first)
+	list := make([]*groupAverage, 0, len(sums))
+	for _, sum := range sums {
+		list = append(list, sum)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].AvgSizeBytes > list[j].AvgSizeBytes
+	})
+
+	return render(list, func() {
+		printGroupAverageTable(list)
+	})
+}
+
+// printGroupAverageTable prints the summed monthly averages of the groups as a table
+func printGroupAverageTable(list []*groupAverage) {
+	if len(list) == 0 {
+		fmt.Printf("No data available for %d-%02d\n", year, month)
+		return
+	}
+
+	// Print the results
+	fmt.Printf("Monthly Average Usage by Group for %d-%02d\n\n", year, month)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "Group\tSize\tObjects\tBuckets\tLowest Coverage")
+	fmt.Fprintln(w, "-----\t----\t-------\t-------\t---------------")
+
+	for _, sum := range list {
+		coverage := "n/a"
+		if sum.MinCoveragePct != nil {
+			coverage = fmt.Sprintf("%.1f%%", *sum.MinCoveragePct)
+			if *sum.MinCoveragePct < minCoverage {
+				coverage += " (low)"
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n",
+			sum.Group,
+			formatSize(sum.AvgSizeBytes),
+			int64(sum.AvgObjectCount),
+			sum.Buckets,
+			coverage,
+		)
+	}
+	w.Flush()
+}
+
 var historyCmd = &cobra.Command{
 	Use:   "history [bucket-name]",
-	Short: "Show usage history for a bucket",
-	Long:  `Display historical usage data for a specific bucket.`,
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
-		bucketName := args[0]
+	Short: "Show usage history for a bucket or group",
+	Long: `Display historical usage data for a specific bucket, or with --group the
+summed usage of all buckets of a group.
+
+Raw samples are shown where they are still available. Older periods whose raw
+samples have been removed by the retention policy are shown as hourly or daily
+averages instead. Manually corrected samples are flagged, bucket lifecycle events
+and annotations are shown as markers between the samples; they are only part of
+the table output, use the events and annotation list commands to export them.
+
+The history of a group sums the samples of its buckets that were taken at the same
+time. The Buckets column shows how many buckets contributed to each point.
+
+By default the history covers the current and the previous twelve months. Use
+--from and --to with a date (YYYY-MM, YYYY-MM-DD or RFC 3339), "now" or a time
+relative to now such as -90d, -2w or -12h to select another period. A date
+without a time given to --to includes the whole day or month. --resolution
+combines the samples into one point per hour, day, week (starting on Monday) or
+month, or only shows raw samples. --agg selects whether the average, the maximum
+or the last value within each period is shown.
+
+With --chart the size and object count are drawn as bar charts fitted to the width
+of the terminal. If the output is not a terminal, the COLUMNS environment variable
+is used, or 80 columns if it is unset. Each column of a chart covers the same
+amount of time, so missing samples show up as empty columns.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if (len(args) == 1) == (historyGroup != "") {
+			return configErrorf("either a bucket name or --group is required")
+		}
+		opts, err := historyOptions()
+		if err != nil {
+			return configErrorf("%w", err)
+		}
 
 		// Initialize the database
-		database, err := db.NewDB(config.DBPath)
+		database, err := openDatabase()
 		if err != nil {
-			fmt.Printf("Error connecting to database: %v\n", err)
-			return
+			return err
 		}
 		defer database.Close()
 
-		// Calculate date range
-		now := time.Now()
-		startTime := time.Date(now.Year()-1, now.Month(), 1, 0, 0, 0, 0, time.UTC)
-		endTime := time.Date(now.Year(), now.Month()+1, 0, 23, 59, 59, 0, time.UTC)
+		if historyGroup != "" {
+			return printGroupHistory(database, historyGroup, opts)
+		}
+		bucketName := args[0]
 
 		// Get usage history
-		usages, err := database.GetBucketUsage(bucketName, startTime, endTime)
+		points, err := database.GetHistory(bucketName, opts)
 		if err != nil {
-			fmt.Printf("Error retrieving usage history: %v\n", err)
-			return
+			return dbErrorf("failed to retrieve usage history: %w", err)
 		}
 
-		if len(usages) == 0 {
-			fmt.Printf("No usage data available for bucket %s\n", bucketName)
-			return
+		// Get lifecycle events and annotations to show as markers between the samples
+		events, err := database.GetBucketEvents(bucketName, opts.Start, opts.End)
+		if err != nil {
+			return dbErrorf("failed to retrieve bucket events: %w", err)
+		}
+		annotations, err := database.GetAnnotations(bucketName, opts.Start, opts.End)
+		if err != nil {
+			return dbErrorf("failed to retrieve annotations: %w", err)
 		}
+		markers := historyMarkers(events, annotations)
 
-		// Print the results
-		fmt.Printf("Usage History for Bucket: %s\n\n", bucketName)
-		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
-		fmt.Fprintln(w, "Date\tSize\tObjects")
-		fmt.Fprintln(w, "----\t----\t-------")
+		return render(points, func() {
+			printHistory(bucketName, opts, points, markers)
+		})
+	},
+}
 
-		for _, usage := range usages {
-			fmt.Fprintf(w, "%s\t%s\t%d\n",
-				usage.Timestamp.Format("2006-01-02 15:04:05"),
-				formatSize(float64(usage.SizeBytes)),
-				usage.ObjectCount,
-			)
+// printHistory prints the usage history of a bucket as a table
+func printHistory(bucketName string, opts models.HistoryOptions, points []models.UsagePoint, markers []historyMarker) {
+	if len(points) == 0 && len(markers) == 0 {
+		fmt.Printf("No usage data available for bucket %s\n", bucketName)
+		return
+	}
+
+	// Print the results
+	fmt.Printf("Usage History for Bucket: %s%s\n\n", bucketName, historyTitle(opts))
+	if historyChart {
+		sizes := make([]chartPoint, len(points))
+		counts := make([]chartPoint, len(points))
+		for i, point := range points {
+			sizes[i] = chartPoint{point.Timestamp, point.SizeBytes}
+			counts[i] = chartPoint{point.Timestamp, point.ObjectCount}
 		}
-		w.Flush()
-	},
+		printHistoryChart(sizes, counts)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "Date\tSize\tObjects\tResolution")
+	fmt.Fprintln(w, "----\t----\t-------\t----------")
+
+	for _, point := range points {
+		// Print markers up to this point
+		for len(markers) > 0 && !markers[0].timestamp.After(point.Timestamp) {
+			markers[0].print(w, 1)
+			markers = markers[1:]
+		}
+		resolution := point.Resolution
+		if point.Corrected {
+			resolution += " (corrected)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n",
+			point.Timestamp.Format("2006-01-02 15:04:05"),
+			formatSize(point.SizeBytes),
+			int64(point.ObjectCount),
+			resolution,
+		)
+	}
+	for _, marker := range markers {
+		marker.print(w, 1)
+	}
+	w.Flush()
+}
+
+// printGroupHistory prints the summed usage history of the buckets of a group
+func printGroupHistory(database *db.DB, group string, opts models.HistoryOptions) error {
+	groups, err := loadGroups(database)
+	if err != nil {
+		return err
+	}
+	members := groups.members(group, groups.knownBuckets())
+	if len(members) == 0 {
+		return configErrorf("group %s has no buckets", group)
+	}
+	isMember := make(map[string]bool, len(members))
+	for _, m := range members {
+		isMember[m] = true
+	}
+
+	points, err := database.GetGroupHistory(group, members, opts)
+	if err != nil {
+		return dbErrorf("failed to retrieve usage history: %w", err)
+	}
+
+	// Show the events and annotations of all member buckets
+	allEvents, err := database.GetBucketEvents("", opts.Start, opts.End)
+	if err != nil {
+		return dbErrorf("failed to retrieve bucket events: %w", err)
+	}
+	var events []models.BucketEvent
+	for _, e := range allEvents {
+		if isMember[e.BucketName] {
+			events = append(events, e)
+		}
+	}
+	allAnnotations, err := database.GetAnnotations("", opts.Start, opts.End)
+	if err != nil {
+		return dbErrorf("failed to retrieve annotations: %w", err)
+	}
+	var annotations []models.Annotation
+	for _, a := range allAnnotations {
+		if a.BucketName == "" || isMember[a.BucketName] {
+			annotations = append(annotations, a)
+		}
+	}
+	markers := historyMarkers(events, annotations)
+
+	return render(points, func() {
+		printGroupHistoryTable(group, len(members), opts, points, markers)
+	})
+}
+
+// printGroupHistoryTable prints the usage history of a group as a table
+func printGroupHistoryTable(group string, buckets int, opts models.HistoryOptions, points []models.GroupUsagePoint, markers []historyMarker) {
+	if len(points) == 0 && len(markers) == 0 {
+		fmt.Printf("No usage data available for group %s\n", group)
+		return
+	}
+
+	// Print the results
+	fmt.Printf("Usage History for Group: %s (%d buckets)%s\n\n", group, buckets, historyTitle(opts))
+	if historyChart {
+		sizes := make([]chartPoint, len(points))
+		counts := make([]chartPoint, len(points))
+		for i, point := range points {
+			sizes[i] = chartPoint{point.Timestamp, point.SizeBytes}
+			counts[i] = chartPoint{point.Timestamp, point.ObjectCount}
+		}
+		printHistoryChart(sizes, counts)
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "Date\tSize\tObjects\tBuckets\tResolution")
+	fmt.Fprintln(w, "----\t----\t-------\t-------\t----------")
+
+	for _, point := range points {
+		// Print markers up to this point
+		for len(markers) > 0 && !markers[0].timestamp.After(point.Timestamp) {
+			markers[0].print(w, 2)
+			markers = markers[1:]
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n",
+			point.Timestamp.Format("2006-01-02 15:04:05"),
+			formatSize(point.SizeBytes),
+			int64(point.ObjectCount),
+			point.Buckets,
+			point.Resolution,
+		)
+	}
+	for _, marker := range markers {
+		marker.print(w, 2)
+	}
+	w.Flush()
+}
+
+// printHistoryChart draws the size and object count of a history as charts
+func printHistoryChart(sizes, counts []chartPoint) {
+	if len(sizes) == 0 {
+		fmt.Println("No usage data in this period")
+		return
+	}
+	width := terminalWidth()
+	drawChart(os.Stdout, "Size", sizes, width, formatSize)
+	fmt.Println()
+	drawChart(os.Stdout, "Objects", counts, width, formatCount)
+}
+
+// historyTitle describes the period and aggregation function of a history for its title
+func historyTitle(opts models.HistoryOptions) string {
+	title := fmt.Sprintf(", %s to %s", opts.Start.Local().Format("2006-01-02 15:04"), opts.End.Local().Format("2006-01-02 15:04"))
+	if opts.Function != models.AggregateAvg {
+		title += fmt.Sprintf(", %s per period", opts.Function)
+	}
+	return title
+}
+
+// historyMarker is a lifecycle event or annotation shown between the samples
+// of the history
+type historyMarker struct {
+	timestamp time.Time
+	text      string
+	kind      string
+}
+
+// historyMarkers merges lifecycle events and annotations into markers ordered by time
+func historyMarkers(events []models.BucketEvent, annotations []models.Annotation) []historyMarker {
+	var markers []historyMarker
+	for _, e := range events {
+		markers = append(markers, historyMarker{e.Timestamp, fmt.Sprintf("bucket %s %s", e.BucketName, e.Type), "event"})
+	}
+	for _, a := range annotations {
+		markers = append(markers, historyMarker{a.Timestamp, fmt.Sprintf("%s (%s)", a.Text, a.Author), "annotation"})
+	}
+	sort.SliceStable(markers, func(i, j int) bool {
+		return markers[i].timestamp.Before(markers[j].timestamp)
+	})
+	return markers
+}
+
+// print prints the marker as a row of the history table, leaving the given
+// number of columns between the text and the kind of the marker empty
+func (m historyMarker) print(w io.Writer, empty int) {
+	fmt.Fprintf(w, "%s\t** %s **\t%s%s\n",
+		m.timestamp.Format("2006-01-02 15:04:05"),
+		m.text,
+		strings.Repeat("\t", empty),
+		m.kind,
+	)
 }
 
 func init() {
@@ -182,4 +632,12 @@ func init() {
 	// Add flags to the list command
 	listCmd.Flags().IntVar(&year, "year", 0, "Year to query (default: current year)")
 	listCmd.Flags().IntVar(&month, "month", 0, "Month to query (1-12, default: current month)")
+	historyCmd.Flags().StringVar(&historyGroup, "group", "", "Show the summed history of all buckets of this group")
+	historyCmd.Flags().StringVar(&historyFrom, "from", "", "Start of the history, a date or a relative time like -90d (default: 12 months ago)")
+	historyCmd.Flags().StringVar(&historyTo, "to", "", "End of the history, a date, now or a relative time like -1d (default: end of this month)")
+	historyCmd.Flags().StringVar(&historyResolution, "resolution", "", "Resolution of the history: raw, hour, day, week or month (default: best available)")
+	historyCmd.Flags().StringVar(&historyFunction, "agg", models.AggregateAvg, "Value shown for each period: avg, max or last")
+	historyCmd.Flags().BoolVar(&historyChart, "chart", false, "Draw the size and object count as charts instead of a table")
+	listCmd.Flags().StringVar(&listBy, "by", "bucket", "List usage per bucket or per group")
+	listCmd.Flags().Float64Var(&minCoverage, "min-coverage", 90, "Mark averages with a lower sample coverage in percent as low confidence")
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Output formats of the reporting commands
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+	formatYAML  = "yaml"
+)
+
+var (
+	// Output format selected with --output
+	outputFormat string
+)
+
+// render prints the records of a report in the selected output format.
+// Records must be a slice of structs; the JSON field names of the structs
+// form the schema of all machine readable formats. Sizes are always given in
+// bytes and timestamps in RFC 3339. For table output the table function is
+// called instead, which prints the human readable form of the report.
+func render(records interface{}, table func()) error {
+	switch outputFormat {
+	case formatTable, "":
+		table()
+		return nil
+	case formatJSON:
+		return renderJSON(records)
+	case formatYAML:
+		return renderYAML(records)
+	case formatCSV:
+		return renderCSV(records)
+	default:
+		return checkOutputFormat()
+	}
+}
+
+// checkOutputFormat validates the output format before a command does any work
+func checkOutputFormat() error {
+	switch outputFormat {
+	case formatTable, formatJSON, formatCSV, formatYAML, "":
+		return nil
+	}
+	return configErrorf("invalid output format %q, use %s, %s, %s or %s",
+		outputFormat, formatTable, formatJSON, formatCSV, formatYAML)
+}
+
+// machineOutput reports whether a machine readable format is selected, in
+// which case commands must not print anything besides the rendered records
+func machineOutput() bool {
+	return outputFormat != formatTable && outputFormat != ""
+}
+
+// jsonRecords marshals the records as JSON, with an empty list for no records
+func jsonRecords(records interface{}) ([]byte, error) {
+	v := reflect.ValueOf(records)
+	if v.Kind() == reflect.Slice && v.IsNil() {
+		return []byte("[]"), nil
+	}
+	return json.MarshalIndent(records, "", "  ")
+}
+
+// renderJSON prints the records as a JSON array
+func renderJSON(records interface{}) error {
+	data, err := jsonRecords(records)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, string(data))
+	return err
+}
+
+// renderYAML prints the records as a YAML sequence. The records are converted
+// through JSON so that YAML uses the same field names and order.
+func renderYAML(records interface{}) error {
+	data, err := jsonRecords(records)
+	if err != nil {
+		return err
+	}
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	clearStyle(&node)
+
+	enc := yaml.NewEncoder(os.Stdout)
+	enc.SetIndent(2)
+	if err := enc.Encode(&node); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// clearStyle removes the JSON flow and quoting style from a parsed document so
+// it is written in block style
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+}
+
+// csvField is a column of the CSV output
+type csvField struct {
+	name  string
+	index int
+}
+
+// csvFields returns the columns of a record type from its JSON field names
+func csvFields(t reflect.Type) []csvField {
+	var fields []csvField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, csvField{name: name, index: i})
+	}
+	return fields
+}
+
+// csvValue formats a field for the CSV output. Missing values are empty,
+// nested values are written as JSON.
+func csvValue(v reflect.Value) (string, error) {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+
+	switch value := v.Interface().(type) {
+	case time.Time:
+		if value.IsZero() {
+			return "", nil
+		}
+		return value.UTC().Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return strconv.FormatFloat(value.Seconds(), 'f', -1, 64), nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'f', -1, 64), nil
+	default:
+		data, err := json.Marshal(v.Interface())
+		return string(data), err
+	}
+}
+
+// renderCSV prints the records as CSV with a header row
+func renderCSV(records interface{}) error {
+	v := reflect.ValueOf(records)
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("records must be a slice, got %s", v.Kind())
+	}
+	t := v.Type().Elem()
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	fields := csvFields(t)
+
+	w := csv.NewWriter(os.Stdout)
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.name
+	}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		record := reflect.Indirect(v.Index(i))
+		row := make([]string, len(fields))
+		for j, f := range fields {
+			value, err := csvValue(record.Field(f.index))
+			if err != nil {
+				return err
+			}
+			row[j] = value
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
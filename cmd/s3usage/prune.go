@@ -3,14 +3,26 @@ package main
 import (
 	"fmt"
 	"os"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/archive"
 	"github.com/thannaske/s3usage/pkg/db"
+	"github.com/thannaske/s3usage/pkg/models"
 )
 
 var (
 	// Flag to confirm pruning without prompting
 	confirm bool
+	// Flag to only show what would be pruned
+	dryRun bool
+	// Only prune months that ended before this date
+	pruneBefore string
+	// Only prune data points of these buckets
+	pruneBuckets []string
+	// File to archive the pruned data points to
+	archivePath string
 )
 
 var pruneCmd = &cobra.Command{
@@ -21,16 +33,46 @@ been aggregated into monthly averages. This helps keep the database size managea
 over time while preserving the monthly average statistics.
 
 Only data from completed months with calculated monthly averages are removed.
-Data from the current month and any months without averages are preserved.`,
-	Run: func(cmd *cobra.Command, args []string) {
+Data from the current month and any months without averages are preserved.
+The hourly and daily rollups are brought up to date before any data is removed,
+so the daily history of pruned months remains available.
+
+Use --dry-run to see how many data points would be removed per month and bucket,
+--before and --bucket to limit what is removed, and --archive to save the data
+points to a compressed JSON Lines (.jsonl.gz) or CSV (.csv.gz) file before they
+are deleted. Archived data points can be imported again with the restore command.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		opts := db.PruneOptions{Buckets: pruneBuckets}
+		if pruneBefore != "" {
+			before, err := parseDate(pruneBefore)
+			if err != nil {
+				return configErrorf("%w", err)
+			}
+			opts.Before = before
+		}
+		if archivePath != "" {
+			if _, _, err := archive.FormatFromPath(archivePath); err != nil {
+				return configErrorf("%w", err)
+			}
+		}
+
 		// Initialize the database
-		database, err := db.NewDB(config.DBPath)
+		database, err := openDatabase()
 		if err != nil {
-			fmt.Printf("Error connecting to database: %v\n", err)
-			return
+			return err
 		}
 		defer database.Close()
 
+		if dryRun {
+			counts, err := database.PrunePreview(opts)
+			if err != nil {
+				return dbErrorf("failed to preview prune: %w", err)
+			}
+			return render(counts, func() {
+				printPruneCounts(counts)
+			})
+		}
+
 		// If not confirmed, prompt the user
 		if !confirm {
 			fmt.Print("This will permanently delete individual data points from months that have " +
@@ -42,16 +84,40 @@ Data from the current month and any months without averages are preserved.`,
 			fmt.Scanln(&response)
 			if response != "y" && response != "Y" {
 				fmt.Println("Pruning cancelled.")
-				return
+				return nil
+			}
+		}
+
+		// Make sure the rollups cover the data points that are about to be removed
+		fmt.Println("Updating rollups...")
+		if _, err := database.Compact(models.RetentionPolicy{}, models.AggregationOptions{}, time.Now(), false); err != nil {
+			return dbErrorf("failed to update rollups: %w", err)
+		}
+
+		// Write the data points to the archive before they are deleted
+		if archivePath != "" {
+			opts.Archive = func(usages []models.BucketUsage) error {
+				w, err := archive.Create(archivePath)
+				if err != nil {
+					return err
+				}
+				if err := w.Write(usages); err != nil {
+					w.Close()
+					return err
+				}
+				if err := w.Close(); err != nil {
+					return err
+				}
+				fmt.Printf("Archived %d data points to %s.\n", w.Count(), archivePath)
+				return nil
 			}
 		}
 
 		// Perform the pruning operation
 		fmt.Println("Pruning old data points...")
-		rowsDeleted, err := database.PruneOldData()
+		rowsDeleted, err := database.PruneOldData(opts)
 		if err != nil {
-			fmt.Printf("Error pruning old data: %v\n", err)
-			os.Exit(1)
+			return dbErrorf("failed to prune old data: %w", err)
 		}
 
 		if rowsDeleted == 0 {
@@ -59,12 +125,46 @@ Data from the current month and any months without averages are preserved.`,
 		} else {
 			fmt.Printf("Successfully pruned %d data points from completed months.\n", rowsDeleted)
 		}
+		return nil
 	},
 }
 
+// printPruneCounts prints the number of data points that would be pruned
+func printPruneCounts(counts []models.PruneCount) {
+	if len(counts) == 0 {
+		fmt.Println("No data to prune. All data points are still needed or no monthly averages have been calculated yet.")
+		return
+	}
+
+	fmt.Print("Data points that would be pruned:\n\n")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+	fmt.Fprintln(w, "Month\tBucket\tData Points")
+	fmt.Fprintln(w, "-----\t------\t-----------")
+
+	var total, monthTotal int64
+	for i, c := range counts {
+		fmt.Fprintf(w, "%d-%02d\t%s\t%d\n", c.Year, c.Month, c.BucketName, c.DataPoints)
+		monthTotal += c.DataPoints
+		total += c.DataPoints
+
+		// Print a subtotal after the last bucket of each month
+		if i == len(counts)-1 || counts[i+1].Year != c.Year || counts[i+1].Month != c.Month {
+			fmt.Fprintf(w, "%d-%02d\t(all buckets)\t%d\n", c.Year, c.Month, monthTotal)
+			monthTotal = 0
+		}
+	}
+	w.Flush()
+
+	fmt.Printf("\nTotal: %d data points\n", total)
+}
+
 func init() {
 	rootCmd.AddCommand(pruneCmd)
 
 	// Add flags to the prune command
 	pruneCmd.Flags().BoolVar(&confirm, "confirm", false, "Confirm pruning without prompting")
+	pruneCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show what would be pruned without deleting anything")
+	pruneCmd.Flags().StringVar(&pruneBefore, "before", "", "Only prune months that ended before this date (YYYY-MM or YYYY-MM-DD)")
+	pruneCmd.Flags().StringSliceVar(&pruneBuckets, "bucket", nil, "Only prune data points of this bucket (can be repeated)")
+	pruneCmd.Flags().StringVar(&archivePath, "archive", "", "Archive pruned data points to this file (.jsonl.gz or .csv.gz)")
 }
@@ -0,0 +1,336 @@
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+var (
+	// Directory to write the report to
+	reportOut string
+	// Number of months shown in the report
+	reportMonths int
+	// Period of history shown in the charts
+	reportHistory string
+)
+
+// reportTemplate holds the pages of the HTML report
+//
+//go:embed templates/report.html.tmpl
+var reportTemplate string
+
+// unsafeFileChars matches the characters not used in file names of bucket pages
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// reportIndex is the data of the overview page of the report
+type reportIndex struct {
+	GeneratedAt      time.Time
+	Period           string
+	Buckets          int
+	TotalSizeBytes   float64
+	TotalObjectCount float64
+	ChangePct        *float64
+	HistoryChart     template.HTML
+	MonthlyChart     template.HTML
+	Rows             []reportRow
+}
+
+// reportRow is a bucket in the monthly table of the overview page
+type reportRow struct {
+	BucketName     string
+	Page           string
+	Owner          string
+	AvgSizeBytes   float64
+	AvgObjectCount float64
+	CoveragePct    *float64
+	ChangePct      *float64
+}
+
+// reportBucket is the data of the page of a bucket
+type reportBucket struct {
+	GeneratedAt  time.Time
+	Name         string
+	Owner        string
+	Placement    string
+	SizeBytes    float64
+	ObjectCount  float64
+	HistoryChart template.HTML
+	MonthlyChart template.HTML
+	Months       []models.MonthlyBucketAverage
+}
+
+// bucketPage returns the path of a bucket's page relative to the index page
+func bucketPage(bucket string) string {
+	return "buckets/" + unsafeFileChars.ReplaceAllString(bucket, "_") + ".html"
+}
+
+// reportFuncs returns the functions available to the report templates
+func reportFuncs() template.FuncMap {
+	return template.FuncMap{
+		"size":   formatSize,
+		"count":  formatCount,
+		"change": formatPercentChange,
+		"percent": func(pct *float64) string {
+			if pct == nil {
+				return "n/a"
+			}
+			return fmt.Sprintf("%.1f%%", *pct)
+		},
+		"sortValue": func(pct *float64) string {
+			if pct == nil {
+				return "-1e300"
+			}
+			return fmt.Sprintf("%g", *pct)
+		},
+		"datetime": func(t time.Time) string {
+			return t.Local().Format("2006-01-02 15:04:05")
+		},
+	}
+}
+
+// historyValues converts usage points to the values of a line chart
+func historyValues(timestamps []time.Time, sizes []float64) []svgValue {
+	values := make([]svgValue, len(timestamps))
+	for i := range timestamps {
+		values[i] = svgValue{
+			Time:  timestamps[i],
+			Value: sizes[i],
+			Label: timestamps[i].Local().Format("2006-01-02") + ": " + formatSize(sizes[i]),
+		}
+	}
+	return values
+}
+
+// monthlyValues converts monthly totals to the values of a bar chart
+func monthlyValues(months []time.Time, totals map[time.Time]float64) []svgValue {
+	values := make([]svgValue, len(months))
+	for i, m := range months {
+		values[i] = svgValue{Time: m, Value: totals[m], Label: m.Format("2006-01") + ": " + formatSize(totals[m])}
+	}
+	return values
+}
+
+// writePage executes a template of the report into a file
+func writePage(tmpl *template.Template, name, path string, data interface{}) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.ExecuteTemplate(f, name, data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to render %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Generate reports",
+}
+
+var reportHTMLCmd = &cobra.Command{
+	Use:   "html",
+	Short: "Generate a static HTML report",
+	Long: `Generate a static website from the database for everyone who does not use the
+command line.
+
+The overview page (index.html) shows the totals of the latest month with monthly
+averages, charts of the total size over --history (default one year) and of the
+monthly averages over the last --months months (default 12), and a table of all
+buckets of the latest month that can be sorted by clicking its headers and
+filtered with a search box. Every bucket has its own page under buckets/ with
+charts of its size history and monthly averages and a table of its averages.
+
+The pages are self-contained: styles, scripts and SVG charts are embedded, so
+the report works without network access, e.g. in air-gapped environments, and
+can be served by any web server or opened from disk.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if reportOut == "" {
+			return configErrorf("--out is required")
+		}
+		if reportMonths < 1 {
+			return configErrorf("--months must be at least 1")
+		}
+		history, err := parseDuration(reportHistory)
+		if err != nil || history <= 0 {
+			return configErrorf("invalid --history %q, use e.g. 90d or 1y", reportHistory)
+		}
+
+		tmpl, err := template.New("report").Funcs(reportFuncs()).Parse(reportTemplate)
+		if err != nil {
+			return fmt.Errorf("failed to parse report template: %w", err)
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		now := time.Now().UTC()
+		last := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		first := last.AddDate(0, -reportMonths+1, 0)
+		averages, err := database.GetMonthlyAveragesBetween(first.Year(), int(first.Month()), last.Year(), int(last.Month()))
+		if err != nil {
+			return dbErrorf("failed to retrieve monthly averages: %w", err)
+		}
+		infos, err := database.GetBucketInfos()
+		if err != nil {
+			return dbErrorf("failed to retrieve bucket metadata: %w", err)
+		}
+		if len(averages) == 0 {
+			fmt.Printf("No data available in the last %d months\n", reportMonths)
+			return nil
+		}
+		bucketInfos := make(map[string]models.BucketInfo, len(infos))
+		for _, info := range infos {
+			bucketInfos[info.Name] = info
+		}
+
+		// Group the averages by bucket and sum them up per month. The latest
+		// month with data is the one shown in the overview.
+		var months []time.Time
+		for m := first; !m.After(last); m = m.AddDate(0, 1, 0) {
+			months = append(months, m)
+		}
+		totals := make(map[time.Time]float64)
+		byBucket := make(map[string][]models.MonthlyBucketAverage)
+		var buckets []string
+		var latest time.Time
+		for _, avg := range averages {
+			m := time.Date(avg.Year, time.Month(avg.Month), 1, 0, 0, 0, 0, time.UTC)
+			totals[m] += avg.AvgSizeBytes
+			if _, ok := byBucket[avg.BucketName]; !ok {
+				buckets = append(buckets, avg.BucketName)
+			}
+			byBucket[avg.BucketName] = append(byBucket[avg.BucketName], avg)
+			if m.After(latest) {
+				latest = m
+			}
+		}
+
+		opts := models.HistoryOptions{
+			Start:      now.Add(-history),
+			End:        now,
+			Resolution: models.ResolutionDaily,
+			Function:   models.AggregateLast,
+		}
+		// Daily values further apart than this are drawn as a gap
+		const maxGap = 2 * 24 * time.Hour
+
+		if err := os.MkdirAll(filepath.Join(reportOut, "buckets"), 0o755); err != nil {
+			return fmt.Errorf("failed to create report directory: %w", err)
+		}
+
+		index := reportIndex{
+			GeneratedAt: now,
+			Period:      latest.Format("2006-01"),
+			Rows:        []reportRow{},
+		}
+		for _, bucket := range buckets {
+			bucketAverages := byBucket[bucket]
+			info := bucketInfos[bucket]
+
+			// Add the bucket to the table of the latest month
+			n := len(bucketAverages)
+			if avg := bucketAverages[n-1]; avg.Year == latest.Year() && avg.Month == int(latest.Month()) {
+				row := reportRow{
+					BucketName:     bucket,
+					Page:           bucketPage(bucket),
+					Owner:          info.Owner,
+					AvgSizeBytes:   avg.AvgSizeBytes,
+					AvgObjectCount: avg.AvgObjectCount,
+					CoveragePct:    avg.CoveragePct,
+				}
+				previous := latest.AddDate(0, -1, 0)
+				if n > 1 {
+					prev := bucketAverages[n-2]
+					if prev.Year == previous.Year() && prev.Month == int(previous.Month()) && prev.AvgSizeBytes != 0 {
+						pct := (avg.AvgSizeBytes - prev.AvgSizeBytes) / prev.AvgSizeBytes * 100
+						row.ChangePct = &pct
+					}
+				}
+				index.Rows = append(index.Rows, row)
+				index.TotalSizeBytes += avg.AvgSizeBytes
+				index.TotalObjectCount += avg.AvgObjectCount
+			}
+
+			points, err := database.GetHistory(bucket, opts)
+			if err != nil {
+				return dbErrorf("failed to retrieve history of bucket %s: %w", bucket, err)
+			}
+			page := reportBucket{
+				GeneratedAt: now,
+				Name:        bucket,
+				Owner:       info.Owner,
+				Placement:   info.Placement,
+				Months:      bucketAverages,
+			}
+			timestamps := make([]time.Time, len(points))
+			sizes := make([]float64, len(points))
+			for i, p := range points {
+				timestamps[i], sizes[i] = p.Timestamp, p.SizeBytes
+			}
+			if len(points) > 0 {
+				page.SizeBytes = points[len(points)-1].SizeBytes
+				page.ObjectCount = points[len(points)-1].ObjectCount
+			}
+			page.HistoryChart = svgLineChart("Size history of "+bucket, historyValues(timestamps, sizes), maxGap, formatSize)
+			bucketTotals := make(map[time.Time]float64)
+			for _, avg := range bucketAverages {
+				bucketTotals[time.Date(avg.Year, time.Month(avg.Month), 1, 0, 0, 0, 0, time.UTC)] = avg.AvgSizeBytes
+			}
+			page.MonthlyChart = svgBarChart("Monthly average size of "+bucket, monthlyValues(months, bucketTotals), formatSize)
+
+			if err := writePage(tmpl, "bucket", filepath.Join(reportOut, bucketPage(bucket)), page); err != nil {
+				return err
+			}
+		}
+		index.Buckets = len(index.Rows)
+
+		previous := latest.AddDate(0, -1, 0)
+		if totals[previous] != 0 {
+			pct := (totals[latest] - totals[previous]) / totals[previous] * 100
+			index.ChangePct = &pct
+		}
+
+		points, err := database.GetGroupHistory(totalName, buckets, opts)
+		if err != nil {
+			return dbErrorf("failed to retrieve total history: %w", err)
+		}
+		timestamps := make([]time.Time, len(points))
+		sizes := make([]float64, len(points))
+		for i, p := range points {
+			timestamps[i], sizes[i] = p.Timestamp, p.SizeBytes
+		}
+		index.HistoryChart = svgLineChart("Total size of all buckets", historyValues(timestamps, sizes), maxGap, formatSize)
+		index.MonthlyChart = svgBarChart("Monthly average size of all buckets", monthlyValues(months, totals), formatSize)
+
+		if err := writePage(tmpl, "index", filepath.Join(reportOut, "index.html"), index); err != nil {
+			return err
+		}
+
+		fmt.Printf("Report with %d bucket pages written to %s\n", len(buckets), filepath.Join(reportOut, "index.html"))
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	reportCmd.AddCommand(reportHTMLCmd)
+
+	// Add flags to the report html command
+	reportHTMLCmd.Flags().StringVar(&reportOut, "out", "", "Directory to write the report to")
+	reportHTMLCmd.Flags().IntVar(&reportMonths, "months", 12, "Number of months of monthly averages shown in the report")
+	reportHTMLCmd.Flags().StringVar(&reportHistory, "history", "1y", "Period of history shown in the size charts")
+}
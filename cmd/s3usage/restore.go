@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/archive"
+)
+
+var (
+	// Flag to recalculate the monthly averages of restored months
+	recalculate bool
+	// Flag to only validate the archive without importing it
+	validateOnly bool
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [archive]",
+	Short: "Restore archived bucket usage data",
+	Long: `Re-import data points from an archive written by prune --archive.
+
+The archive is validated completely before anything is imported. Data points
+that already exist in the database for the same bucket and timestamp are skipped.
+With --recalculate the rollups and then the monthly averages of all months
+contained in the archive are recalculated from the restored data points, using
+the mode given with --aggregation. The rollups are refreshed first, since the
+averages fall back to them where raw data points are missing.
+
+Note that restored data points older than the configured raw retention period
+are removed again by the next collect or compact run.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := args[0]
+		agg, err := aggregationOptions()
+		if err != nil {
+			return configErrorf("%w", err)
+		}
+
+		// Read and validate the whole archive first
+		r, err := archive.Open(path)
+		if err != nil {
+			return configErrorf("%w", err)
+		}
+		usages, err := r.ReadAll()
+		r.Close()
+		if err != nil {
+			return configErrorf("invalid archive %s: %w", path, err)
+		}
+		fmt.Printf("Read %d valid data points from %s.\n", len(usages), path)
+
+		if len(usages) == 0 {
+			return nil
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		inserted, duplicates, err := database.RestoreBucketUsage(usages, validateOnly)
+		if err != nil {
+			return dbErrorf("failed to restore data points: %w", err)
+		}
+
+		if validateOnly {
+			fmt.Printf("Archive is valid: %d data points would be restored, %d already exist.\n", inserted, duplicates)
+			return nil
+		}
+		fmt.Printf("Restored %d data points, skipped %d duplicates.\n", inserted, duplicates)
+
+		if !recalculate || inserted == 0 {
+			return nil
+		}
+
+		// Refresh the rollups first, since monthly averages fall back to them
+		// where raw samples are missing
+		months := make(map[[2]int]bool)
+		earliest := usages[0].Timestamp
+		for _, u := range usages {
+			months[[2]int{u.Timestamp.Year(), int(u.Timestamp.Month())}] = true
+			if u.Timestamp.Before(earliest) {
+				earliest = u.Timestamp
+			}
+		}
+		keys := make([][2]int, 0, len(months))
+		for m := range months {
+			keys = append(keys, m)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i][0] < keys[j][0] || (keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1])
+		})
+
+		if _, _, err := database.RefreshRollups(earliest); err != nil {
+			return dbErrorf("failed to update rollups: %w", err)
+		}
+		fmt.Println("Rollups updated successfully.")
+
+		// Recalculate the aggregates of all months contained in the archive
+		for _, m := range keys {
+			if err := database.CalculateMonthlyAverages(m[0], m[1], agg); err != nil {
+				return dbErrorf("failed to calculate monthly averages for %d-%02d: %w", m[0], m[1], err)
+			}
+			fmt.Printf("Recalculated monthly averages for %d-%02d.\n", m[0], m[1])
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+
+	// Add flags to the restore command
+	restoreCmd.Flags().BoolVar(&recalculate, "recalculate", false, "Recalculate monthly averages and rollups of the restored months")
+	addAggregationFlags(restoreCmd)
+	restoreCmd.Flags().BoolVar(&validateOnly, "validate-only", false, "Only validate the archive and report what would be restored")
+}
@@ -6,13 +6,16 @@ import (
 	"path/filepath"
 
 	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/db"
 	"github.com/thannaske/s3usage/pkg/models"
+	"gopkg.in/yaml.v3"
 )
 
 var (
-	cfgFile   string
-	config    models.Config
-	defaultDB = filepath.Join(os.Getenv("HOME"), ".s3usage.db")
+	cfgFile    string
+	config     models.Config
+	defaultDB  = filepath.Join(os.Getenv("HOME"), ".s3usage.db")
+	defaultCfg = filepath.Join(os.Getenv("HOME"), ".s3usage.yaml")
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -22,19 +25,31 @@ var rootCmd = &cobra.Command{
 	Long: `A CLI tool to monitor and track usage statistics for S3 buckets in Ceph.
 It collects and stores usage data in a SQLite database and provides
 commands to query historical usage information.`,
+	// Errors are printed by Execute, which also sets the exit code
+	SilenceErrors: true,
+	SilenceUsage:  true,
+	// Reject an invalid output format before a command does any work
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		return checkOutputFormat()
+	},
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(exitCode(err))
 	}
 }
 
 func init() {
 	cobra.OnInitialize(initConfig)
 
+	// Invalid flags are configuration errors
+	rootCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return configErrorf("%w", err)
+	})
+
 	// Global flags
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.s3usage.yaml)")
 	rootCmd.PersistentFlags().StringVar(&config.S3Endpoint, "endpoint", "", "S3 endpoint URL")
@@ -42,14 +57,24 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&config.S3SecretKey, "secret-key", "", "S3 secret key")
 	rootCmd.PersistentFlags().StringVar(&config.S3Region, "region", "default", "S3 region")
 	rootCmd.PersistentFlags().StringVar(&config.DBPath, "db", defaultDB, "SQLite database path")
+	rootCmd.PersistentFlags().StringVarP(&outputFormat, "output", "o", formatTable, "Output format of reports: table, json, csv or yaml")
 }
 
 // initConfig reads in config file if set.
 func initConfig() {
-	// If config file path is provided, use it
-	if cfgFile != "" {
-		// TODO: Read config from file
-		// For now, we'll just use command line flags
+	// If config file path is provided, use it, otherwise fall back to the
+	// default location if a file exists there
+	path := cfgFile
+	if path == "" {
+		if _, err := os.Stat(defaultCfg); err == nil {
+			path = defaultCfg
+		}
+	}
+	if path != "" {
+		if err := loadConfigFile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to read config file: %v\n", err)
+			os.Exit(exitConfig)
+		}
 	}
 
 	// Environment variables can override config
@@ -69,3 +94,74 @@ func initConfig() {
 		config.DBPath = os.Getenv("S3_DB_PATH")
 	}
 }
+
+// loadConfigFile reads the YAML config file at path. Settings given on the
+// command line take precedence over the values from the file.
+func loadConfigFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var fileConfig models.Config
+	if err := yaml.Unmarshal(data, &fileConfig); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	// Global flags are only overridden if they were not set explicitly
+	flags := rootCmd.PersistentFlags()
+	setString := func(dst *string, flag, value string) {
+		if value != "" && !flags.Changed(flag) {
+			*dst = value
+		}
+	}
+	setString(&config.S3Endpoint, "endpoint", fileConfig.S3Endpoint)
+	setString(&config.S3AccessKey, "access-key", fileConfig.S3AccessKey)
+	setString(&config.S3SecretKey, "secret-key", fileConfig.S3SecretKey)
+	setString(&config.S3Region, "region", fileConfig.S3Region)
+	setString(&config.DBPath, "db", fileConfig.DBPath)
+
+	// Command specific settings default to empty, so anything still empty
+	// was not given on the command line
+	setDefault := func(dst *string, value string) {
+		if *dst == "" {
+			*dst = value
+		}
+	}
+	setDefault(&config.Retention.Raw, fileConfig.Retention.Raw)
+	setDefault(&config.Retention.Hourly, fileConfig.Retention.Hourly)
+	setDefault(&config.Retention.Daily, fileConfig.Retention.Daily)
+	setDefault(&config.LockTimeout, fileConfig.LockTimeout)
+	setDefault(&config.OnPartialFailure, fileConfig.OnPartialFailure)
+	setDefault(&config.Aggregation, fileConfig.Aggregation)
+	setDefault(&config.CollectionInterval, fileConfig.CollectionInterval)
+	setDefault(&config.FillMethod, fileConfig.FillMethod)
+	setDefault(&config.FillThreshold, fileConfig.FillThreshold)
+	if len(config.Include) == 0 {
+		config.Include = fileConfig.Include
+	}
+	if len(config.Exclude) == 0 {
+		config.Exclude = fileConfig.Exclude
+	}
+	config.Groups = fileConfig.Groups
+	config.Anomalies = fileConfig.Anomalies
+	config.Pricing = fileConfig.Pricing
+	config.StatementTemplates = fileConfig.StatementTemplates
+
+	return nil
+}
+
+// openDatabase connects to the configured database and makes sure all tables exist
+func openDatabase() (*db.DB, error) {
+	database, err := db.NewDB(config.DBPath)
+	if err != nil {
+		return nil, dbErrorf("failed to connect to database: %w", err)
+	}
+
+	if err := database.InitDB(); err != nil {
+		database.Close()
+		return nil, dbErrorf("failed to initialize database: %w", err)
+	}
+
+	return database, nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+var (
+	// Number of runs to show
+	runsLimit int
+)
+
+// runDuration returns the duration of a run, or the time it has been running so far
+func runDuration(run models.CollectionRun) time.Duration {
+	end := run.FinishedAt
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return end.Sub(run.StartedAt).Round(time.Second)
+}
+
+var runsCmd = &cobra.Command{
+	Use:   "runs",
+	Short: "List recent collection runs",
+	Long: `Display the journal of recent collect runs with their status, duration and
+the number of buckets that were collected or failed. A run is partial if some
+buckets failed and failed if no bucket could be collected at all.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		runs, err := database.GetCollectionRuns(runsLimit)
+		if err != nil {
+			return dbErrorf("failed to retrieve collection runs: %w", err)
+		}
+
+		return render(runs, func() {
+			if len(runs) == 0 {
+				fmt.Println("No collection runs recorded yet")
+				return
+			}
+
+			// Print the results
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+			fmt.Fprintln(w, "ID\tStarted\tDuration\tStatus\tBuckets\tCollected\tFailed")
+			fmt.Fprintln(w, "--\t-------\t--------\t------\t-------\t---------\t------")
+
+			for _, run := range runs {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%d\n",
+					run.ID,
+					run.StartedAt.Local().Format("2006-01-02 15:04:05"),
+					runDuration(run),
+					run.Status,
+					run.BucketsTotal,
+					run.BucketsSucceeded,
+					run.BucketsFailed,
+				)
+			}
+			w.Flush()
+		})
+	},
+}
+
+var runsShowCmd = &cobra.Command{
+	Use:   "show [run-id]",
+	Short: "Show details of a collection run",
+	Long:  `Display the details of a single collection run including all bucket errors.`,
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return configErrorf("invalid run ID %q", args[0])
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		run, err := database.GetCollectionRun(id)
+		if err != nil {
+			return dbErrorf("failed to retrieve collection run: %w", err)
+		}
+
+		return render([]models.CollectionRun{*run}, func() {
+			// Print the results
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+			fmt.Fprintf(w, "Run:\t%d\n", run.ID)
+			fmt.Fprintf(w, "Endpoint:\t%s\n", run.Endpoint)
+			fmt.Fprintf(w, "Status:\t%s\n", run.Status)
+			fmt.Fprintf(w, "Started:\t%s\n", run.StartedAt.Local().Format("2006-01-02 15:04:05"))
+			if !run.FinishedAt.IsZero() {
+				fmt.Fprintf(w, "Finished:\t%s\n", run.FinishedAt.Local().Format("2006-01-02 15:04:05"))
+			}
+			fmt.Fprintf(w, "Duration:\t%s\n", runDuration(*run))
+			fmt.Fprintf(w, "Buckets:\t%d\n", run.BucketsTotal)
+			fmt.Fprintf(w, "Collected:\t%d\n", run.BucketsSucceeded)
+			fmt.Fprintf(w, "Failed:\t%d\n", run.BucketsFailed)
+			fmt.Fprintf(w, "Samples stored:\t%d\n", run.Samples)
+			if run.Error != "" {
+				fmt.Fprintf(w, "Error:\t%s\n", run.Error)
+			}
+			w.Flush()
+
+			if len(run.Errors) > 0 {
+				fmt.Print("\nBucket errors:\n\n")
+				w = tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+				fmt.Fprintln(w, "Bucket\tError")
+				fmt.Fprintln(w, "------\t-----")
+				for _, e := range run.Errors {
+					fmt.Fprintf(w, "%s\t%s\n", e.BucketName, e.Message)
+				}
+				w.Flush()
+			}
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runsCmd)
+	runsCmd.AddCommand(runsShowCmd)
+
+	// Add flags to the runs command
+	runsCmd.Flags().IntVar(&runsLimit, "limit", 20, "Number of runs to show")
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/archive"
+	"github.com/thannaske/s3usage/pkg/db"
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+var (
+	// Reason and author recorded with a correction or annotation
+	reason string
+	author string
+	// Time of a sample or annotation
+	atTime string
+	// New values of a sample
+	sampleSize    int64
+	sampleObjects int64
+	// Time range of the samples to list
+	sampleFrom string
+	sampleTo   string
+	// Only show corrections of this bucket
+	correctionsBucket string
+)
+
+// defaultAuthor returns the name of the current user
+func defaultAuthor() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// correctionAuthor validates the reason of a correction and returns its author
+func correctionAuthor() (string, error) {
+	if reason == "" {
+		return "", fmt.Errorf("a reason is required, use --reason")
+	}
+	if author == "" {
+		return defaultAuthor(), nil
+	}
+	return author, nil
+}
+
+// formatOptionalInt formats a value that may be missing
+func formatOptionalInt(v *int64) string {
+	if v == nil {
+		return "-"
+	}
+	return strconv.FormatInt(*v, 10)
+}
+
+// recalculateMonth recalculates the monthly averages and rollups affected by a
+// corrected sample of the bucket
+func recalculateMonth(database *db.DB, bucketName string, t time.Time) error {
+	agg, err := aggregationOptions()
+	if err != nil {
+		return configErrorf("%w", err)
+	}
+	if err := database.RecalculateSample(bucketName, t, agg); err != nil {
+		return dbErrorf("%w", err)
+	}
+	fmt.Printf("Recalculated monthly averages for %d-%02d and rollups since %s.\n",
+		t.Year(), t.Month(), t.Truncate(time.Hour).Local().Format("2006-01-02 15:04"))
+	return nil
+}
+
+// printCorrection prints the outcome of a correction
+func printCorrection(c *models.Correction) {
+	fmt.Printf("Correction %d: %s sample %d of bucket %s at %s (size %s -> %s, objects %s -> %s)\n",
+		c.ID, c.Action, c.SampleID, c.BucketName, c.Timestamp.Local().Format("2006-01-02 15:04:05"),
+		formatOptionalInt(c.OldSizeBytes), formatOptionalInt(c.NewSizeBytes),
+		formatOptionalInt(c.OldObjectCount), formatOptionalInt(c.NewObjectCount))
+}
+
+var sampleCmd = &cobra.Command{
+	Use:   "sample",
+	Short: "List and correct individual samples",
+	Long: `Commands to list, add, edit and delete individual samples, e.g. to fix a
+sample for which RGW reported wrong statistics.
+
+Every change requires a reason and is recorded with its author (--author,
+default: the current user) in an audit log shown by sample log. Added and edited
+samples are flagged as corrected in the history. With --recalculate the monthly
+averages and rollups of the affected month are updated right away.`,
+}
+
+var sampleListCmd = &cobra.Command{
+	Use:   "list [bucket-name]",
+	Short: "List the samples of a bucket with their IDs",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		bucketName := args[0]
+
+		end := time.Now().UTC()
+		start := end.AddDate(0, 0, -31)
+		var err error
+		if sampleFrom != "" {
+			if start, err = parseDate(sampleFrom); err != nil {
+				return configErrorf("invalid --from date: %w", err)
+			}
+		}
+		if sampleTo != "" {
+			if end, err = parseEndDate(sampleTo); err != nil {
+				return configErrorf("invalid --to date: %w", err)
+			}
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		usages, err := database.GetBucketUsage(bucketName, start, end)
+		if err != nil {
+			return dbErrorf("failed to retrieve samples: %w", err)
+		}
+
+		return render(usages, func() {
+			if len(usages) == 0 {
+				fmt.Printf("No samples available for bucket %s\n", bucketName)
+				return
+			}
+
+			// Print the results
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+			fmt.Fprintln(w, "ID\tDate\tSize (bytes)\tObjects\tCorrected")
+			fmt.Fprintln(w, "--\t----\t------------\t-------\t---------")
+
+			for _, u := range usages {
+				corrected := ""
+				if u.Corrected {
+					corrected = "yes"
+				}
+				fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%s\n",
+					u.ID,
+					u.Timestamp.Local().Format("2006-01-02 15:04:05"),
+					u.SizeBytes,
+					u.ObjectCount,
+					corrected,
+				)
+			}
+			w.Flush()
+		})
+	},
+}
+
+var sampleAddCmd = &cobra.Command{
+	Use:   "add [bucket-name]",
+	Short: "Add a sample manually",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		by, err := correctionAuthor()
+		if err != nil {
+			return configErrorf("%w", err)
+		}
+		if atTime == "" {
+			return configErrorf("the time of the sample is required, use --time")
+		}
+		// A missing size or object count would add the 0 byte sample RGW
+		// reports when it fails, which is what corrections are meant to fix
+		if !cmd.Flags().Changed("size") || !cmd.Flags().Changed("objects") {
+			return configErrorf("the size and object count of the sample are required, use --size and --objects")
+		}
+		ts, err := parseDate(atTime)
+		if err != nil {
+			return configErrorf("invalid --time: %w", err)
+		}
+
+		usage := models.BucketUsage{
+			BucketName:  args[0],
+			SizeBytes:   sampleSize,
+			ObjectCount: sampleObjects,
+			Timestamp:   ts,
+		}
+		if err := archive.Validate(usage); err != nil {
+			return configErrorf("invalid sample: %w", err)
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		correction, err := database.AddSample(usage, reason, by)
+		if err != nil {
+			return dbErrorf("%w", err)
+		}
+		printCorrection(correction)
+
+		if recalculate {
+			return recalculateMonth(database, usage.BucketName, ts)
+		}
+		return nil
+	},
+}
+
+var sampleEditCmd = &cobra.Command{
+	Use:   "edit [sample-id]",
+	Short: "Change the size or object count of a sample",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return configErrorf("invalid sample ID %q", args[0])
+		}
+		by, err := correctionAuthor()
+		if err != nil {
+			return configErrorf("%w", err)
+		}
+		if !cmd.Flags().Changed("size") && !cmd.Flags().Changed("objects") {
+			return configErrorf("nothing to change, use --size and/or --objects")
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		usage, err := database.GetSample(id)
+		if err != nil {
+			return dbErrorf("%w", err)
+		}
+		if cmd.Flags().Changed("size") {
+			usage.SizeBytes = sampleSize
+		}
+		if cmd.Flags().Changed("objects") {
+			usage.ObjectCount = sampleObjects
+		}
+		if err := archive.Validate(*usage); err != nil {
+			return configErrorf("invalid sample: %w", err)
+		}
+
+		correction, err := database.EditSample(id, usage.SizeBytes, usage.ObjectCount, reason, by)
+		if err != nil {
+			return dbErrorf("%w", err)
+		}
+		printCorrection(correction)
+
+		if recalculate {
+			return recalculateMonth(database, usage.BucketName, usage.Timestamp)
+		}
+		return nil
+	},
+}
+
+var sampleDeleteCmd = &cobra.Command{
+	Use:   "delete [sample-id]",
+	Short: "Delete a sample",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		id, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			return configErrorf("invalid sample ID %q", args[0])
+		}
+		by, err := correctionAuthor()
+		if err != nil {
+			return configErrorf("%w", err)
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		correction, err := database.DeleteSample(id, reason, by)
+		if err != nil {
+			return dbErrorf("%w", err)
+		}
+		printCorrection(correction)
+
+		if recalculate {
+			return recalculateMonth(database, correction.BucketName, correction.Timestamp)
+		}
+		return nil
+	},
+}
+
+var sampleLogCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Show the audit log of sample corrections",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		corrections, err := database.GetCorrections(correctionsBucket)
+		if err != nil {
+			return dbErrorf("failed to retrieve corrections: %w", err)
+		}
+
+		return render(corrections, func() {
+			if len(corrections) == 0 {
+				fmt.Println("No corrections recorded")
+				return
+			}
+
+			// Print the results
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+			fmt.Fprintln(w, "ID\tChanged\tAuthor\tAction\tBucket\tSample\tSample Date\tSize\tObjects\tReason")
+			fmt.Fprintln(w, "--\t-------\t------\t------\t------\t------\t-----------\t----\t-------\t------")
+
+			for _, c := range corrections {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%d\t%s\t%s -> %s\t%s -> %s\t%s\n",
+					c.ID,
+					c.CreatedAt.Local().Format("2006-01-02 15:04:05"),
+					c.Author,
+					c.Action,
+					c.BucketName,
+					c.SampleID,
+					c.Timestamp.Local().Format("2006-01-02 15:04:05"),
+					formatOptionalInt(c.OldSizeBytes), formatOptionalInt(c.NewSizeBytes),
+					formatOptionalInt(c.OldObjectCount), formatOptionalInt(c.NewObjectCount),
+					c.Reason,
+				)
+			}
+			w.Flush()
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(sampleCmd)
+	sampleCmd.AddCommand(sampleListCmd)
+	sampleCmd.AddCommand(sampleAddCmd)
+	sampleCmd.AddCommand(sampleEditCmd)
+	sampleCmd.AddCommand(sampleDeleteCmd)
+	sampleCmd.AddCommand(sampleLogCmd)
+
+	// Add flags to the sample commands
+	sampleListCmd.Flags().StringVar(&sampleFrom, "from", "", "Only list samples on or after this date (default: 31 days ago)")
+	sampleListCmd.Flags().StringVar(&sampleTo, "to", "", "Only list samples on or before this date (default: now)")
+	sampleAddCmd.Flags().StringVar(&atTime, "time", "", "Time of the sample (RFC 3339 or YYYY-MM-DD)")
+	for _, c := range []*cobra.Command{sampleAddCmd, sampleEditCmd} {
+		c.Flags().Int64Var(&sampleSize, "size", 0, "Size of the bucket in bytes (required for add)")
+		c.Flags().Int64Var(&sampleObjects, "objects", 0, "Number of objects in the bucket (required for add)")
+	}
+	for _, c := range []*cobra.Command{sampleAddCmd, sampleEditCmd, sampleDeleteCmd} {
+		c.Flags().StringVar(&reason, "reason", "", "Why the sample is corrected (required)")
+		c.Flags().StringVar(&author, "author", "", "Who made the correction (default: current user)")
+		c.Flags().BoolVar(&recalculate, "recalculate", false, "Recalculate the monthly averages and rollups of the affected month")
+		addAggregationFlags(c)
+	}
+	sampleLogCmd.Flags().StringVar(&correctionsBucket, "bucket", "", "Only show corrections of this bucket")
+}
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/models"
+	"github.com/thannaske/s3usage/pkg/pricing"
+)
+
+var (
+	// Month to generate the statement for
+	statementMonth string
+	// Owner the statement is generated for
+	statementOwner string
+	// Format of the statement
+	statementFormat string
+	// Template overriding the built-in template of the format
+	statementTemplate string
+	// File to write the statement to
+	statementOut string
+	// Generate the statement without assigning a number
+	statementPreview bool
+)
+
+// Formats statements are rendered in
+const (
+	statementMarkdown = "markdown"
+	statementHTML     = "html"
+)
+
+// statementTemplates holds the built-in statement templates
+//
+//go:embed templates/statement.md.tmpl templates/statement.html.tmpl
+var statementTemplates embed.FS
+
+// builtinStatementTemplates maps the statement formats to their built-in templates
+var builtinStatementTemplates = map[string]string{
+	statementMarkdown: "templates/statement.md.tmpl",
+	statementHTML:     "templates/statement.html.tmpl",
+}
+
+// statement is the data statement templates are executed with
+type statement struct {
+	// Number is zero for previews
+	Number    int64     `json:"number"`
+	Owner     string    `json:"owner"`
+	Year      int       `json:"year"`
+	Month     int       `json:"month"`
+	Period    string    `json:"period"`
+	CreatedAt time.Time `json:"created_at"`
+	// Priced is set if prices are configured, the costs are zero otherwise
+	Priced           bool            `json:"priced"`
+	Currency         string          `json:"currency"`
+	Lines            []statementLine `json:"lines"`
+	TotalSizeBytes   float64         `json:"total_size_bytes"`
+	TotalObjectCount float64         `json:"total_object_count"`
+	Total            float64         `json:"total"`
+}
+
+// statementLine is the usage and cost of a bucket in a statement
+type statementLine struct {
+	BucketName     string   `json:"bucket_name"`
+	Placement      string   `json:"placement"`
+	AvgSizeBytes   float64  `json:"avg_size_bytes"`
+	AvgObjectCount float64  `json:"avg_object_count"`
+	DataPoints     int      `json:"data_points"`
+	CoveragePct    *float64 `json:"coverage_pct"`
+	Rates          string   `json:"rates"`
+	StorageCost    float64  `json:"storage_cost"`
+	ObjectCost     float64  `json:"object_cost"`
+	MinimumCharge  float64  `json:"minimum_charge"`
+	Total          float64  `json:"total"`
+}
+
+// pricingConfigured reports whether the config file has a pricing section
+func pricingConfigured() bool {
+	cfg := config.Pricing
+	return cfg.PerGBMonth != nil || len(cfg.Tiers) > 0 || cfg.PerMillionObjects != nil ||
+		cfg.MinimumCharge != nil || len(cfg.Placements) > 0 || len(cfg.Groups) > 0
+}
+
+// statementFuncs returns the functions available to statement templates
+func statementFuncs(p *pricing.Pricing) map[string]interface{} {
+	return map[string]interface{}{
+		"size":  formatSize,
+		"count": formatCount,
+		"amount": func(amount float64) string {
+			if p == nil {
+				return ""
+			}
+			return formatAmount(p, amount)
+		},
+		"percent": func(pct *float64) string {
+			if pct == nil {
+				return "n/a"
+			}
+			return fmt.Sprintf("%.1f%%", *pct)
+		},
+		"date": func(t time.Time) string {
+			return t.Local().Format("2006-01-02")
+		},
+	}
+}
+
+// statementRenderer executes a statement template
+type statementRenderer interface {
+	Execute(w io.Writer, data interface{}) error
+}
+
+// loadStatementTemplate parses the template of the format, either the given
+// file, the file configured for the format or the built-in template.
+// HTML templates escape their data.
+func loadStatementTemplate(format, path string, funcs map[string]interface{}) (statementRenderer, error) {
+	if path == "" {
+		path = config.StatementTemplates[format]
+	}
+	var text []byte
+	var err error
+	if path != "" {
+		text, err = os.ReadFile(path)
+	} else {
+		text, err = statementTemplates.ReadFile(builtinStatementTemplates[format])
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if format == statementHTML {
+		return htmltemplate.New("statement").Funcs(funcs).Parse(string(text))
+	}
+	return template.New("statement").Funcs(funcs).Parse(string(text))
+}
+
+// statementSnapshot returns the content of a statement that is stored with its
+// number: the lines and totals, without the number and creation time
+func statementSnapshot(st statement) (string, error) {
+	st.Number = 0
+	st.CreatedAt = time.Time{}
+	data, err := json.Marshal(st)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode statement: %w", err)
+	}
+	return string(data), nil
+}
+
+var statementCmd = &cobra.Command{
+	Use:   "statement",
+	Short: "Generate a usage statement for an owner",
+	Long: `Generate the usage statement of a month for the buckets of an owner, as reported
+by the cluster during collection. The statement lists each bucket's average size
+and object count, the share of the month covered by samples and, if prices are
+configured, the cost as calculated by the cost command, together with totals.
+
+Statements are rendered in Markdown (default) or HTML from built-in templates.
+A different template can be given with --template, or per format in the config
+file:
+
+  statement_templates:
+    html: /etc/s3usage/statement.html.tmpl
+    markdown: /etc/s3usage/statement.md.tmpl
+
+Markdown templates use text/template, HTML templates html/template. Besides the
+statement data, templates can use the functions size, count, amount, percent
+and date to format values like the built-in templates do.
+
+The buckets of the owner and their placements are those recorded with the
+samples of the month, so a bucket transferred to another owner stays on the
+statements of its former owner for the months before. Averages calculated
+before owners were recorded fall back to the current owner and placement, and
+the affected buckets are listed in a warning.
+
+Every statement gets a sequential number stored in the database together with
+its lines and totals. Generating the statement of the same owner and month
+again keeps its number, but fails if the content changed since it was issued,
+e.g. because samples were corrected or prices changed, so an issued statement
+never silently changes. Use --preview to see the changed statement; it is
+generated without a number.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if statementOwner == "" {
+			return configErrorf("--owner is required")
+		}
+		if _, ok := builtinStatementTemplates[statementFormat]; !ok {
+			return configErrorf("invalid --format %q, use markdown or html", statementFormat)
+		}
+
+		now := time.Now().UTC()
+		month := time.Date(now.Year(), now.Month()-1, 1, 0, 0, 0, 0, time.UTC)
+		var err error
+		if statementMonth != "" {
+			if month, err = parseDate(statementMonth); err != nil {
+				return configErrorf("invalid --month: %w", err)
+			}
+		}
+
+		var p *pricing.Pricing
+		if pricingConfigured() {
+			if p, err = pricingConfig(); err != nil {
+				return configErrorf("invalid pricing: %w", err)
+			}
+		}
+
+		tmpl, err := loadStatementTemplate(statementFormat, statementTemplate, statementFuncs(p))
+		if err != nil {
+			return configErrorf("failed to load statement template: %w", err)
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		infos, err := database.GetBucketInfos()
+		if err != nil {
+			return dbErrorf("failed to retrieve bucket metadata: %w", err)
+		}
+		bucketInfos := make(map[string]models.BucketInfo, len(infos))
+		known := false
+		for _, info := range infos {
+			bucketInfos[info.Name] = info
+			known = known || info.Owner == statementOwner
+		}
+
+		averages, err := database.GetAllMonthlyAverages(month.Year(), int(month.Month()))
+		if err != nil {
+			return dbErrorf("failed to retrieve monthly averages: %w", err)
+		}
+
+		var groups *groupResolver
+		if p != nil && len(p.Groups) > 0 {
+			if groups, err = loadGroups(database); err != nil {
+				return err
+			}
+		}
+
+		st := statement{
+			Owner:     statementOwner,
+			Year:      month.Year(),
+			Month:     int(month.Month()),
+			Period:    month.Format("2006-01"),
+			CreatedAt: now,
+			Priced:    p != nil,
+			Lines:     []statementLine{},
+		}
+		// Averages of older versions did not record the owner and placement
+		var owned []pricing.Line
+		var unrecorded []string
+		for _, avg := range averages {
+			if averageOwner(avg, bucketInfos) != statementOwner {
+				known = known || avg.Owner == statementOwner
+				continue
+			}
+			placement, recorded := averagePlacement(avg, bucketInfos)
+			if avg.Owner == "" || !recorded {
+				unrecorded = append(unrecorded, avg.BucketName)
+			}
+			line := pricing.Line{Average: avg, Owner: statementOwner, Placement: placement}
+			if groups != nil {
+				line.Groups = groups.groupsOf(avg.BucketName, statementOwner)
+			}
+			owned = append(owned, line)
+		}
+
+		// The buckets of the owner share the tiers like in the cost report
+		var items []models.CostItem
+		if p != nil {
+			items = p.Costs(owned)
+		}
+		for i, o := range owned {
+			avg := o.Average
+			line := statementLine{
+				BucketName:     avg.BucketName,
+				Placement:      o.Placement,
+				AvgSizeBytes:   avg.AvgSizeBytes,
+				AvgObjectCount: avg.AvgObjectCount,
+				DataPoints:     avg.DataPoints,
+				CoveragePct:    avg.CoveragePct,
+			}
+			if p != nil {
+				item := items[i]
+				line.Rates = item.Rates
+				line.StorageCost = item.StorageCost
+				line.ObjectCost = item.ObjectCost
+				line.MinimumCharge = item.MinimumCharge
+				line.Total = item.Total
+				st.Currency = p.Currency
+			}
+			st.Lines = append(st.Lines, line)
+			st.TotalSizeBytes += line.AvgSizeBytes
+			st.TotalObjectCount += line.AvgObjectCount
+			st.Total += line.Total
+		}
+		if len(st.Lines) == 0 {
+			if !known {
+				return configErrorf("no buckets of owner %s are known", statementOwner)
+			}
+			return configErrorf("no data available for owner %s in %s", statementOwner, st.Period)
+		}
+		if len(unrecorded) > 0 {
+			fmt.Fprintf(os.Stderr, "Warning: no owner or placement recorded in %s for %s; using the current ones\n",
+				st.Period, strings.Join(unrecorded, ", "))
+		}
+
+		if !statementPreview {
+			snapshot, err := statementSnapshot(st)
+			if err != nil {
+				return err
+			}
+			record, err := database.AssignStatementNumber(st.Owner, st.Year, st.Month, now, snapshot)
+			if err != nil {
+				return dbErrorf("failed to assign statement number: %w", err)
+			}
+			if record.Snapshot != snapshot {
+				return fmt.Errorf("statement %d of owner %s for %s was issued on %s and its content has changed since; use --preview to see the changed statement",
+					record.Number, st.Owner, st.Period, record.CreatedAt.Local().Format("2006-01-02"))
+			}
+			st.Number = record.Number
+			st.CreatedAt = record.CreatedAt
+		}
+
+		if machineOutput() {
+			return render([]statement{st}, nil)
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, st); err != nil {
+			return fmt.Errorf("failed to render statement: %w", err)
+		}
+		if statementOut == "" {
+			_, err = os.Stdout.Write(buf.Bytes())
+			return err
+		}
+		if err := os.WriteFile(statementOut, buf.Bytes(), 0o644); err != nil {
+			return fmt.Errorf("failed to write statement: %w", err)
+		}
+		if statementPreview {
+			fmt.Fprintf(os.Stderr, "Statement preview written to %s\n", statementOut)
+		} else {
+			fmt.Fprintf(os.Stderr, "Statement %d written to %s\n", st.Number, statementOut)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statementCmd)
+
+	// Add flags to the statement command
+	statementCmd.Flags().StringVar(&statementMonth, "month", "", "Month of the statement (YYYY-MM, default: previous month)")
+	statementCmd.Flags().StringVar(&statementOwner, "owner", "", "Owner of the buckets the statement is generated for")
+	statementCmd.Flags().StringVar(&statementFormat, "format", statementMarkdown, "Format of the statement: markdown or html")
+	statementCmd.Flags().StringVar(&statementTemplate, "template", "", "Template to render the statement with instead of the built-in one")
+	statementCmd.Flags().StringVar(&statementOut, "out", "", "File to write the statement to (default: stdout)")
+	statementCmd.Flags().BoolVar(&statementPreview, "preview", false, "Generate the statement without assigning a number")
+}
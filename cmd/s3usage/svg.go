@@ -0,0 +1,137 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"html/template"
+	"math"
+	"strings"
+	"time"
+)
+
+// Size and margins of the SVG charts of the HTML report
+const (
+	svgWidth        = 800
+	svgHeight       = 240
+	svgMarginLeft   = 80
+	svgMarginRight  = 16
+	svgMarginTop    = 12
+	svgMarginBottom = 28
+)
+
+// svgValue is a value of an SVG chart with the label shown when hovering it
+type svgValue struct {
+	Time  time.Time
+	Value float64
+	Label string
+}
+
+// svgAxis draws the horizontal grid lines of a chart with their labels and
+// returns the function mapping values to y coordinates
+func svgAxis(sb *strings.Builder, hi float64, format func(float64) string) func(float64) float64 {
+	if hi <= 0 {
+		hi = 1
+	}
+	plot := float64(svgHeight - svgMarginTop - svgMarginBottom)
+	y := func(v float64) float64 {
+		return svgMarginTop + plot - v/hi*plot
+	}
+	for i := 0; i <= 4; i++ {
+		v := hi * float64(i) / 4
+		fmt.Fprintf(sb, `<line class="grid" x1="%d" y1="%.1f" x2="%d" y2="%.1f"/>`,
+			svgMarginLeft, y(v), svgWidth-svgMarginRight, y(v))
+		fmt.Fprintf(sb, `<text class="axis" x="%d" y="%.1f" text-anchor="end">%s</text>`,
+			svgMarginLeft-6, y(v)+4, html.EscapeString(format(v)))
+	}
+	return y
+}
+
+// svgOpen starts an SVG chart with an accessible title
+func svgOpen(sb *strings.Builder, title string) {
+	fmt.Fprintf(sb, `<svg class="chart" viewBox="0 0 %d %d" role="img"><title>%s</title>`,
+		svgWidth, svgHeight, html.EscapeString(title))
+}
+
+// svgLineChart draws the values as a line over time, scaled from zero to their
+// maximum. The line is interrupted where consecutive values are further apart
+// than maxGap, and every value shows its label when hovered.
+func svgLineChart(title string, values []svgValue, maxGap time.Duration, format func(float64) string) template.HTML {
+	var sb strings.Builder
+	svgOpen(&sb, title)
+	if len(values) == 0 {
+		fmt.Fprintf(&sb, `<text class="axis" x="%d" y="%d" text-anchor="middle">No data</text></svg>`, svgWidth/2, svgHeight/2)
+		return template.HTML(sb.String())
+	}
+
+	var hi float64
+	for _, v := range values {
+		hi = math.Max(hi, v.Value)
+	}
+	y := svgAxis(&sb, hi, format)
+
+	first, last := values[0].Time, values[len(values)-1].Time
+	span := last.Sub(first)
+	plot := float64(svgWidth - svgMarginLeft - svgMarginRight)
+	x := func(t time.Time) float64 {
+		if span == 0 {
+			return svgMarginLeft + plot/2
+		}
+		return svgMarginLeft + float64(t.Sub(first))/float64(span)*plot
+	}
+
+	var path strings.Builder
+	for i, v := range values {
+		cmd := "L"
+		if i == 0 || v.Time.Sub(values[i-1].Time) > maxGap {
+			cmd = "M"
+		}
+		fmt.Fprintf(&path, "%s%.1f %.1f ", cmd, x(v.Time), y(v.Value))
+	}
+	fmt.Fprintf(&sb, `<path class="line" d="%s"/>`, strings.TrimSpace(path.String()))
+	for _, v := range values {
+		fmt.Fprintf(&sb, `<circle class="point" cx="%.1f" cy="%.1f" r="3"><title>%s</title></circle>`,
+			x(v.Time), y(v.Value), html.EscapeString(v.Label))
+	}
+
+	fmt.Fprintf(&sb, `<text class="axis" x="%d" y="%d">%s</text>`,
+		svgMarginLeft, svgHeight-8, first.Local().Format("2006-01-02"))
+	fmt.Fprintf(&sb, `<text class="axis" x="%d" y="%d" text-anchor="end">%s</text>`,
+		svgWidth-svgMarginRight, svgHeight-8, last.Local().Format("2006-01-02"))
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
+
+// svgBarChart draws one bar per value, scaled from zero to their maximum, with
+// the month of each value below its bar
+func svgBarChart(title string, values []svgValue, format func(float64) string) template.HTML {
+	var sb strings.Builder
+	svgOpen(&sb, title)
+	if len(values) == 0 {
+		fmt.Fprintf(&sb, `<text class="axis" x="%d" y="%d" text-anchor="middle">No data</text></svg>`, svgWidth/2, svgHeight/2)
+		return template.HTML(sb.String())
+	}
+
+	var hi float64
+	for _, v := range values {
+		hi = math.Max(hi, v.Value)
+	}
+	y := svgAxis(&sb, hi, format)
+
+	plot := float64(svgWidth - svgMarginLeft - svgMarginRight)
+	slot := plot / float64(len(values))
+	width := math.Max(slot*0.7, 1)
+	// Only every n-th month is labeled so the labels do not overlap
+	every := int(math.Ceil(float64(len(values)) * 60 / plot))
+	for i, v := range values {
+		left := svgMarginLeft + slot*float64(i) + (slot-width)/2
+		top := y(v.Value)
+		fmt.Fprintf(&sb, `<rect class="bar" x="%.1f" y="%.1f" width="%.1f" height="%.1f"><title>%s</title></rect>`,
+			left, top, width, y(0)-top, html.EscapeString(v.Label))
+		if i%every == 0 {
+			fmt.Fprintf(&sb, `<text class="axis" x="%.1f" y="%d" text-anchor="middle">%s</text>`,
+				left+width/2, svgHeight-8, v.Time.Format("2006-01"))
+		}
+	}
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+var (
+	// Ranking of the buckets
+	topBy string
+	// Period to rank the buckets over
+	topWindow string
+	// Number of buckets to show
+	topLimit int
+)
+
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Rank buckets by growth, size or object count",
+	Long: `Rank the buckets by how fast they grew within a window of time, or by their
+size or object count at its end.
+
+For each bucket the first and the last sample within the window are compared.
+Periods whose raw samples have been removed by the retention policy are covered
+by the hourly or daily rollups, using the last value of the rollup's period.
+Growth is ranked by the growth per day, which is the change divided by the time
+between the two values, so buckets created during the window are not ranked
+below older ones. Buckets that have been deleted are not ranked.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch topBy {
+		case models.RankGrowth, models.RankSize, models.RankObjects:
+		default:
+			return configErrorf("invalid --by %q, use growth, size or objects", topBy)
+		}
+		window, err := parseDuration(topWindow)
+		if err != nil || window <= 0 {
+			return configErrorf("invalid --window %q, use e.g. 7d, 30d or 90d", topWindow)
+		}
+		if topLimit < 0 {
+			return configErrorf("--limit must not be negative")
+		}
+
+		// Initialize the database
+		database, err := openDatabase()
+		if err != nil {
+			return err
+		}
+		defer database.Close()
+
+		end := time.Now().UTC()
+		start := end.Add(-window)
+		changes, err := database.TopBuckets(start, end, topBy, topLimit)
+		if err != nil {
+			return dbErrorf("failed to rank buckets: %w", err)
+		}
+
+		return render(changes, func() {
+			if len(changes) == 0 {
+				fmt.Printf("No usage data available in the last %s\n", topWindow)
+				return
+			}
+
+			// Print the results
+			fmt.Printf("Top Buckets by %s in the Last %s\n\n", topBy, topWindow)
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', tabwriter.TabIndent)
+			fmt.Fprintln(w, "#\tBucket\tStart\tEnd\tChange\tPer Day\tObjects\tObjects Change\tObjects Per Day")
+			fmt.Fprintln(w, "-\t------\t-----\t---\t------\t-------\t-------\t--------------\t---------------")
+
+			for i, c := range changes {
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%d\t%+d\t%+.1f\n",
+					i+1,
+					c.BucketName,
+					formatSize(float64(c.StartSizeBytes)),
+					formatSize(float64(c.EndSizeBytes)),
+					formatSizeChange(float64(c.SizeChangeBytes)),
+					formatSizeChange(c.BytesPerDay),
+					c.EndObjectCount,
+					c.ObjectCountChange,
+					c.ObjectsPerDay,
+				)
+			}
+			w.Flush()
+		})
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(topCmd)
+
+	// Add flags to the top command
+	topCmd.Flags().StringVar(&topBy, "by", models.RankGrowth, "Rank buckets by growth, size or objects")
+	topCmd.Flags().StringVar(&topWindow, "window", "30d", "Window of time to rank the buckets over, e.g. 7d, 30d or 90d")
+	topCmd.Flags().IntVar(&topLimit, "limit", 20, "Number of buckets to show, 0 for all")
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseDuration parses a duration that may use day based units in addition to
+// the units understood by time.ParseDuration, e.g. "90d", "2w" or "1y".
+// An empty string, "0" or "forever" yield a zero duration. Negative durations
+// are rejected.
+func parseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" || s == "forever" {
+		return 0, nil
+	}
+
+	units := map[byte]time.Duration{
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+	if unit, ok := units[s[len(s)-1]]; ok {
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		return time.Duration(n) * unit, nil
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+// parseDate parses a date given as YYYY-MM, YYYY-MM-DD or RFC 3339 timestamp.
+// Dates without a time refer to the start of the month or day in UTC.
+func parseDate(s string) (time.Time, error) {
+	for _, layout := range []string{time.RFC3339, "2006-01-02", "2006-01"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q: use YYYY-MM, YYYY-MM-DD or RFC 3339", s)
+}
+
+// parseMonth parses a date as understood by parseDate and returns the start of
+// its month in UTC
+func parseMonth(s string) (time.Time, error) {
+	t, err := parseDate(s)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+// parseEndDate parses a date as understood by parseDate for use as the
+// inclusive end of a period. Dates without a time refer to the end of the month
+// or day, so that e.g. 2025-03-10 includes the whole day.
+func parseEndDate(s string) (time.Time, error) {
+	periods := []struct {
+		layout       string
+		months, days int
+	}{
+		{"2006-01-02", 0, 1},
+		{"2006-01", 1, 0},
+	}
+	for _, p := range periods {
+		if t, err := time.Parse(p.layout, s); err == nil {
+			return t.AddDate(0, p.months, p.days).Add(-time.Nanosecond), nil
+		}
+	}
+	return parseDate(s)
+}
+
+// parseTime parses an absolute date as understood by parseDate, "now", or a
+// time relative to now given as a negative duration, e.g. "-90d" or "-12h"
+func parseTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "now" {
+		return now, nil
+	}
+	if strings.HasPrefix(s, "-") {
+		d, err := parseDuration(s[1:])
+		if err != nil || d == 0 {
+			return time.Time{}, fmt.Errorf("invalid relative time %q: use e.g. -90d, -2w or -12h", s)
+		}
+		return now.Add(-d), nil
+	}
+	return parseDate(s)
+}
+
+// parseEndTime parses the end of a period like parseTime, except that absolute
+// dates are parsed by parseEndDate and include the whole day or month
+func parseEndTime(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "now" || strings.HasPrefix(s, "-") {
+		return parseTime(s, now)
+	}
+	return parseEndDate(s)
+}
+
+// parseSize parses a size given in bytes or with a unit, e.g. "500GB", "1.5TB"
+// or "2TiB". Units are powers of 1024 like in the output of formatSize.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	units := []struct {
+		suffix string
+		factor float64
+	}{
+		{"PIB", 1 << 50}, {"TIB", 1 << 40}, {"GIB", 1 << 30}, {"MIB", 1 << 20}, {"KIB", 1 << 10},
+		{"PB", 1 << 50}, {"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"B", 1},
+	}
+
+	number, factor := s, 1.0
+	for _, u := range units {
+		if strings.HasSuffix(strings.ToUpper(s), u.suffix) {
+			number, factor = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.factor
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(number, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q: use e.g. 500GB or 2TB", s)
+	}
+	return int64(n * factor), nil
+}
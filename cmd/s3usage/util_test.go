@@ -0,0 +1,88 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"forever", 0, false},
+		{"0", 0, false},
+		{"90d", 90 * 24 * time.Hour, false},
+		{"2w", 14 * 24 * time.Hour, false},
+		{"1y", 365 * 24 * time.Hour, false},
+		{"12h", 12 * time.Hour, false},
+		{"-5d", 0, true},
+		{"-1h", 0, true},
+		{"xd", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDuration(%q) = %v, %v; want %v, error %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseMonth(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2025-03", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2025-03-31", time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"2025-03-31T23:30:00-02:00", time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseMonth(tt.in)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseMonth(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseEndDate(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2025-03-10", time.Date(2025, 3, 10, 23, 59, 59, 999999999, time.UTC)},
+		{"2025-02", time.Date(2025, 2, 28, 23, 59, 59, 999999999, time.UTC)},
+		{"2025-03-10T12:00:00Z", time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseEndDate(tt.in)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseEndDate(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := parseEndDate("10.03.2025"); err == nil {
+		t.Errorf("parseEndDate accepted an invalid date")
+	}
+}
+
+func TestParseEndTime(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want time.Time
+	}{
+		{"now", now},
+		{"-1d", now.AddDate(0, 0, -1)},
+		{"2025-01", time.Date(2025, 1, 31, 23, 59, 59, 999999999, time.UTC)},
+		{"2025-03-01", time.Date(2025, 3, 1, 23, 59, 59, 999999999, time.UTC)},
+	}
+	for _, tt := range tests {
+		got, err := parseEndTime(tt.in, now)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseEndTime(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
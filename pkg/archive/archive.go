@@ -0,0 +1,287 @@
+package archive
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// Supported archive formats
+const (
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+)
+
+// csvHeader is the header row of CSV archives
+var csvHeader = []string{"id", "bucket_name", "size_bytes", "object_count", "timestamp", "owner", "placement"}
+
+// csvHeaderV1 is the header row of CSV archives written by older versions,
+// without the owner and placement of the samples
+var csvHeaderV1 = csvHeader[:5]
+
+// FormatFromPath determines the archive format and compression from the file
+// name, e.g. "usage.jsonl.gz" or "usage.csv"
+func FormatFromPath(path string) (format string, compressed bool, err error) {
+	name := strings.ToLower(path)
+	if strings.HasSuffix(name, ".gz") {
+		compressed = true
+		name = strings.TrimSuffix(name, ".gz")
+	}
+
+	switch {
+	case strings.HasSuffix(name, ".jsonl"), strings.HasSuffix(name, ".ndjson"):
+		return FormatJSONL, compressed, nil
+	case strings.HasSuffix(name, ".csv"):
+		return FormatCSV, compressed, nil
+	default:
+		return "", false, fmt.Errorf("unsupported archive file %s: use .jsonl.gz or .csv.gz", path)
+	}
+}
+
+// Writer writes bucket usage samples to an archive file
+type Writer struct {
+	file   *os.File
+	gz     *gzip.Writer
+	format string
+	json   *json.Encoder
+	csv    *csv.Writer
+	count  int64
+}
+
+// Create creates a new archive file. The format is derived from the file name.
+// Existing files are never overwritten.
+func Create(path string) (*Writer, error) {
+	format, compressed, err := FormatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	w := &Writer{file: file, format: format}
+	var out io.Writer = file
+	if compressed {
+		w.gz = gzip.NewWriter(file)
+		out = w.gz
+	}
+
+	switch format {
+	case FormatJSONL:
+		w.json = json.NewEncoder(out)
+	case FormatCSV:
+		w.csv = csv.NewWriter(out)
+		if err := w.csv.Write(csvHeader); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to write archive header: %w", err)
+		}
+	}
+
+	return w, nil
+}
+
+// Write appends the given samples to the archive
+func (w *Writer) Write(usages []models.BucketUsage) error {
+	for _, u := range usages {
+		var err error
+		switch w.format {
+		case FormatJSONL:
+			err = w.json.Encode(u)
+		case FormatCSV:
+			err = w.csv.Write([]string{
+				strconv.FormatInt(u.ID, 10),
+				u.BucketName,
+				strconv.FormatInt(u.SizeBytes, 10),
+				strconv.FormatInt(u.ObjectCount, 10),
+				u.Timestamp.UTC().Format(time.RFC3339Nano),
+				u.Owner,
+				u.Placement,
+			})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+		w.count++
+	}
+	return nil
+}
+
+// Count returns the number of samples written so far
+func (w *Writer) Count() int64 {
+	return w.count
+}
+
+// Close flushes all buffered data and closes the archive file
+func (w *Writer) Close() error {
+	if w.csv != nil {
+		w.csv.Flush()
+		if err := w.csv.Error(); err != nil {
+			w.file.Close()
+			return fmt.Errorf("failed to write archive: %w", err)
+		}
+	}
+	if w.gz != nil {
+		if err := w.gz.Close(); err != nil {
+			w.file.Close()
+			return fmt.Errorf("failed to compress archive: %w", err)
+		}
+	}
+	if err := w.file.Sync(); err != nil {
+		w.file.Close()
+		return fmt.Errorf("failed to sync archive: %w", err)
+	}
+	return w.file.Close()
+}
+
+// Reader reads bucket usage samples from an archive file
+type Reader struct {
+	file   *os.File
+	gz     *gzip.Reader
+	format string
+	json   *json.Decoder
+	csv    *csv.Reader
+	record int
+}
+
+// Open opens an archive file for reading. The format is derived from the file name.
+func Open(path string) (*Reader, error) {
+	format, compressed, err := FormatFromPath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive: %w", err)
+	}
+
+	r := &Reader{file: file, format: format}
+	var in io.Reader = file
+	if compressed {
+		r.gz, err = gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to decompress archive: %w", err)
+		}
+		in = r.gz
+	}
+
+	switch format {
+	case FormatJSONL:
+		r.json = json.NewDecoder(in)
+		r.json.DisallowUnknownFields()
+	case FormatCSV:
+		// FieldsPerRecord is left at zero, so every record must have as many
+		// fields as the header
+		r.csv = csv.NewReader(in)
+		header, err := r.csv.Read()
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("failed to read archive header: %w", err)
+		}
+		switch strings.Join(header, ",") {
+		case strings.Join(csvHeader, ","), strings.Join(csvHeaderV1, ","):
+		default:
+			r.Close()
+			return nil, fmt.Errorf("unexpected archive header %q", strings.Join(header, ","))
+		}
+	}
+
+	return r, nil
+}
+
+// Read returns the next sample from the archive, or io.EOF at the end of the archive
+func (r *Reader) Read() (models.BucketUsage, error) {
+	var u models.BucketUsage
+	r.record++
+
+	switch r.format {
+	case FormatJSONL:
+		if err := r.json.Decode(&u); err != nil {
+			if err == io.EOF {
+				return u, err
+			}
+			return u, fmt.Errorf("record %d: %w", r.record, err)
+		}
+	case FormatCSV:
+		fields, err := r.csv.Read()
+		if err != nil {
+			if err == io.EOF {
+				return u, err
+			}
+			return u, fmt.Errorf("record %d: %w", r.record, err)
+		}
+		if u.ID, err = strconv.ParseInt(fields[0], 10, 64); err != nil {
+			return u, fmt.Errorf("record %d: invalid id %q", r.record, fields[0])
+		}
+		u.BucketName = fields[1]
+		if u.SizeBytes, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+			return u, fmt.Errorf("record %d: invalid size %q", r.record, fields[2])
+		}
+		if u.ObjectCount, err = strconv.ParseInt(fields[3], 10, 64); err != nil {
+			return u, fmt.Errorf("record %d: invalid object count %q", r.record, fields[3])
+		}
+		if u.Timestamp, err = time.Parse(time.RFC3339Nano, fields[4]); err != nil {
+			return u, fmt.Errorf("record %d: invalid timestamp %q", r.record, fields[4])
+		}
+		if len(fields) == len(csvHeader) {
+			u.Owner, u.Placement = fields[5], fields[6]
+		}
+	}
+
+	if err := Validate(u); err != nil {
+		return u, fmt.Errorf("record %d: %w", r.record, err)
+	}
+	u.Timestamp = u.Timestamp.UTC()
+	return u, nil
+}
+
+// ReadAll reads all remaining samples from the archive
+func (r *Reader) ReadAll() ([]models.BucketUsage, error) {
+	var usages []models.BucketUsage
+	for {
+		u, err := r.Read()
+		if err == io.EOF {
+			return usages, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, u)
+	}
+}
+
+// Close closes the archive file
+func (r *Reader) Close() error {
+	if r.gz != nil {
+		r.gz.Close()
+	}
+	return r.file.Close()
+}
+
+// Validate checks that a sample read from an archive is plausible
+func Validate(u models.BucketUsage) error {
+	switch {
+	case u.BucketName == "":
+		return fmt.Errorf("missing bucket name")
+	case u.SizeBytes < 0:
+		return fmt.Errorf("negative size %d for bucket %s", u.SizeBytes, u.BucketName)
+	case u.ObjectCount < 0:
+		return fmt.Errorf("negative object count %d for bucket %s", u.ObjectCount, u.BucketName)
+	case u.Timestamp.IsZero():
+		return fmt.Errorf("missing timestamp for bucket %s", u.BucketName)
+	case u.Timestamp.After(time.Now().Add(time.Hour)):
+		return fmt.Errorf("timestamp %s for bucket %s is in the future", u.Timestamp.Format(time.RFC3339), u.BucketName)
+	}
+	return nil
+}
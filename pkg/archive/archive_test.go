@@ -0,0 +1,226 @@
+package archive
+
+import (
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// testSamples returns samples with the fields every archive format keeps
+func testSamples() []models.BucketUsage {
+	at := time.Date(2024, 3, 1, 12, 30, 0, 500, time.UTC)
+	return []models.BucketUsage{
+		{ID: 1, BucketName: "logs", SizeBytes: 1 << 30, ObjectCount: 1200, Timestamp: at, Owner: "alice", Placement: "cold"},
+		{ID: 2, BucketName: "media, \"raw\"", SizeBytes: 0, ObjectCount: 0, Timestamp: at.Add(time.Hour)},
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, name := range []string{"usage.jsonl", "usage.jsonl.gz", "usage.csv", "usage.csv.gz"} {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), name)
+			w, err := Create(path)
+			if err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+			want := testSamples()
+			if err := w.Write(want); err != nil {
+				t.Fatalf("Write: %v", err)
+			}
+			if w.Count() != int64(len(want)) {
+				t.Errorf("Count = %d, want %d", w.Count(), len(want))
+			}
+			if err := w.Close(); err != nil {
+				t.Fatalf("Close: %v", err)
+			}
+
+			// Archives are never overwritten
+			if _, err := Create(path); err == nil {
+				t.Errorf("Create overwrote an existing archive")
+			}
+
+			r, err := Open(path)
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer r.Close()
+			got, err := r.ReadAll()
+			if err != nil {
+				t.Fatalf("ReadAll: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("read %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestJSONLKeepsAllFields(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.jsonl.gz")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	want := testSamples()[:1]
+	want[0].RunID = 7
+	want[0].Corrected = true
+	if err := w.Write(want); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer r.Close()
+	got, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %+v, want %+v", got, want)
+	}
+}
+
+// writeFile writes an archive by hand, compressed if the name ends in .gz
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if strings.HasSuffix(name, ".gz") {
+		gz := gzip.NewWriter(f)
+		if _, err := gz.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err := gz.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestOpenRejectsInvalidArchives(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"unknown header", "usage.csv", "id,bucket,size\n1,logs,10\n"},
+		{"reordered header", "usage.csv", "bucket_name,id,size_bytes,object_count,timestamp\n"},
+		{"empty csv", "usage.csv", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if r, err := Open(writeFile(t, tt.file, tt.content)); err == nil {
+				r.Close()
+				t.Errorf("Open accepted %q", tt.content)
+			}
+		})
+	}
+
+	// An uncompressed file named like a compressed archive
+	path := filepath.Join(t.TempDir(), "usage.csv.gz")
+	if err := os.WriteFile(path, []byte(strings.Join(csvHeader, ",")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if r, err := Open(path); err == nil {
+		r.Close()
+		t.Errorf("Open accepted an uncompressed .gz file")
+	}
+
+	if _, err := Open(filepath.Join(t.TempDir(), "usage.txt")); err == nil {
+		t.Errorf("Open accepted an unsupported file name")
+	}
+}
+
+func TestReadOldCSVHeader(t *testing.T) {
+	path := writeFile(t, "usage.csv", "id,bucket_name,size_bytes,object_count,timestamp\n1,logs,10,1,2024-03-01T00:00:00Z\n")
+	r, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer r.Close()
+	got, err := r.ReadAll()
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	want := []models.BucketUsage{{ID: 1, BucketName: "logs", SizeBytes: 10, ObjectCount: 1, Timestamp: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %+v, want %+v", got, want)
+	}
+}
+
+func TestReadRejectsInvalidRecords(t *testing.T) {
+	header := "id,bucket_name,size_bytes,object_count,timestamp\n"
+	tests := []struct {
+		name    string
+		file    string
+		content string
+	}{
+		{"csv invalid size", "usage.csv", header + "1,logs,ten,1,2024-03-01T00:00:00Z\n"},
+		{"csv invalid timestamp", "usage.csv", header + "1,logs,10,1,yesterday\n"},
+		{"csv missing field", "usage.csv", header + "1,logs,10,2024-03-01T00:00:00Z\n"},
+		{"csv negative size", "usage.csv", header + "1,logs,-10,1,2024-03-01T00:00:00Z\n"},
+		{"csv fields of the other header", "usage.csv", header + "1,logs,10,1,2024-03-01T00:00:00Z,alice,cold\n"},
+		{"jsonl unknown field", "usage.jsonl", `{"bucket_name":"logs","size":10,"timestamp":"2024-03-01T00:00:00Z"}` + "\n"},
+		{"jsonl missing bucket", "usage.jsonl.gz", `{"size_bytes":10,"timestamp":"2024-03-01T00:00:00Z"}` + "\n"},
+		{"jsonl malformed", "usage.jsonl", `{"bucket_name":` + "\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := Open(writeFile(t, tt.file, tt.content))
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			defer r.Close()
+			if _, err := r.ReadAll(); err == nil {
+				t.Errorf("ReadAll accepted %q", tt.content)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		usage   models.BucketUsage
+		wantErr string
+	}{
+		{"valid", models.BucketUsage{BucketName: "logs", SizeBytes: 10, ObjectCount: 1, Timestamp: at}, ""},
+		{"empty bucket", models.BucketUsage{BucketName: "logs", Timestamp: at}, ""},
+		{"missing bucket name", models.BucketUsage{SizeBytes: 10, Timestamp: at}, "missing bucket name"},
+		{"negative size", models.BucketUsage{BucketName: "logs", SizeBytes: -1, Timestamp: at}, "negative size"},
+		{"negative objects", models.BucketUsage{BucketName: "logs", ObjectCount: -1, Timestamp: at}, "negative object count"},
+		{"missing timestamp", models.BucketUsage{BucketName: "logs"}, "missing timestamp"},
+		{"future timestamp", models.BucketUsage{BucketName: "logs", Timestamp: time.Now().Add(2 * time.Hour)}, "in the future"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.usage)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -10,6 +10,9 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"os"
+	"path"
+	"regexp"
 	"strings"
 	"time"
 
@@ -83,6 +86,34 @@ type BucketStats struct {
 	Zonegroup   string `json:"zonegroup"`
 	PlacementID string `json:"placement_rule"`
 	Created     string `json:"creation_time"`
+	Quota       Quota  `json:"bucket_quota"`
+}
+
+// Quota contains the quota settings of a bucket. Negative limits are unlimited.
+type Quota struct {
+	Enabled    bool  `json:"enabled"`
+	MaxSize    int64 `json:"max_size"`
+	MaxSizeKB  int64 `json:"max_size_kb"`
+	MaxObjects int64 `json:"max_objects"`
+}
+
+// Limits returns the size in bytes and the number of objects the quota allows,
+// zero for limits that are not set or if the quota is disabled
+func (q Quota) Limits() (sizeBytes, objects int64) {
+	if !q.Enabled {
+		return 0, 0
+	}
+	switch {
+	case q.MaxSize > 0:
+		sizeBytes = q.MaxSize
+	case q.MaxSizeKB > 0:
+		// Older releases only report the limit in KB
+		sizeBytes = q.MaxSizeKB * 1024
+	}
+	if q.MaxObjects > 0 {
+		objects = q.MaxObjects
+	}
+	return sizeBytes, objects
 }
 
 // Usage contains usage statistics for a bucket
@@ -157,10 +188,6 @@ func (c *S3Client) executeSignedRequest(ctx context.Context, method, path string
 		return nil, fmt.Errorf("failed to sign request: %w", err)
 	}
 
-	// Debug output for troubleshooting
-	fmt.Printf("Executing request: %s %s\n", method, req.URL.String())
-	fmt.Printf("Authorization: %s\n", req.Header.Get("Authorization"))
-
 	// Execute the request
 	resp, err := c.adminClient.Do(req)
 	if err != nil {
@@ -199,8 +226,26 @@ func (c *S3Client) GetBuckets(ctx context.Context) ([]string, error) {
 	return bucketList, nil
 }
 
-// GetBucketUsage retrieves the usage statistics for a bucket using the Ceph RGW Admin API
-func (c *S3Client) GetBucketUsage(ctx context.Context, bucketName string) (*models.BucketUsage, error) {
+// creationTimeLayouts are the formats RGW versions use for creation_time
+var creationTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// CreationTime parses the creation time of the bucket. The zero time is
+// returned if RGW did not report it or it cannot be parsed.
+func (s *BucketStats) CreationTime() time.Time {
+	for _, layout := range creationTimeLayouts {
+		if t, err := time.Parse(layout, s.Created); err == nil {
+			return t.UTC()
+		}
+	}
+	return time.Time{}
+}
+
+// GetBucketStats retrieves the statistics and metadata of a bucket using the Ceph RGW Admin API
+func (c *S3Client) GetBucketStats(ctx context.Context, bucketName string) (*BucketStats, error) {
 	// Prepare query parameters
 	queryParams := url.Values{}
 	queryParams.Set("bucket", bucketName)
@@ -218,37 +263,191 @@ func (c *S3Client) GetBucketUsage(ctx context.Context, bucketName string) (*mode
 		return nil, fmt.Errorf("failed to decode response: %w", err)
 	}
 
-	// Create bucket usage object
-	usage := &models.BucketUsage{
+	return &bucketStats, nil
+}
+
+// GetBucketUsage retrieves the usage statistics for a bucket using the Ceph RGW Admin API
+func (c *S3Client) GetBucketUsage(ctx context.Context, bucketName string) (*models.BucketUsage, error) {
+	bucketStats, err := c.GetBucketStats(ctx, bucketName)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := bucketStats.usage(bucketName, time.Now())
+	return &usage, nil
+}
+
+// usage converts the statistics into a usage sample taken at the given time
+func (s *BucketStats) usage(bucketName string, timestamp time.Time) models.BucketUsage {
+	return models.BucketUsage{
 		BucketName:  bucketName,
-		SizeBytes:   bucketStats.Usage.RgwMain.SizeKB * 1024, // Convert KB to bytes
-		ObjectCount: bucketStats.Usage.RgwMain.NumObjects,
-		Timestamp:   time.Now().UTC(),
+		SizeBytes:   s.Usage.RgwMain.SizeKB * 1024, // Convert KB to bytes
+		ObjectCount: s.Usage.RgwMain.NumObjects,
+		Timestamp:   timestamp.UTC(),
+	}
+}
+
+// bucketPattern matches bucket names against a glob or, with a "re:" prefix,
+// a regular expression
+type bucketPattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// newBucketPattern compiles a glob or "re:" prefixed regular expression
+func newBucketPattern(pattern string) (bucketPattern, error) {
+	if expr, ok := strings.CutPrefix(pattern, "re:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return bucketPattern{}, fmt.Errorf("invalid regular expression %q: %w", expr, err)
+		}
+		return bucketPattern{re: re}, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return bucketPattern{}, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	return bucketPattern{glob: pattern}, nil
+}
+
+// match reports whether the bucket name matches the pattern
+func (p bucketPattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	ok, _ := path.Match(p.glob, name)
+	return ok
+}
+
+// BucketFilter selects the buckets whose usage is collected
+type BucketFilter struct {
+	include []bucketPattern
+	exclude []bucketPattern
+	only    map[string]bool
+	names   []string
+}
+
+// NewBucketFilter creates a filter from include and exclude patterns. Patterns
+// are globs like "logs-*", or regular expressions if prefixed with "re:".
+// A bucket is collected if it matches any include pattern, or there are none,
+// and matches no exclude pattern. If explicit bucket names are given, exactly
+// those buckets are collected and the patterns are ignored.
+func NewBucketFilter(include, exclude, only []string) (*BucketFilter, error) {
+	f := &BucketFilter{}
+	for _, p := range include {
+		pattern, err := newBucketPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, pattern)
+	}
+	for _, p := range exclude {
+		pattern, err := newBucketPattern(p)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, pattern)
+	}
+	if len(only) > 0 {
+		f.only = make(map[string]bool, len(only))
+		for _, name := range only {
+			if !f.only[name] {
+				f.only[name] = true
+				f.names = append(f.names, name)
+			}
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether the usage of the bucket is collected. A nil filter
+// matches all buckets.
+func (f *BucketFilter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
+	if f.only != nil {
+		return f.only[name]
 	}
 
-	return usage, nil
+	included := len(f.include) == 0
+	for _, p := range f.include {
+		if p.match(name) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, p := range f.exclude {
+		if p.match(name) {
+			return false
+		}
+	}
+	return true
 }
 
-// GetAllBucketsUsage retrieves usage statistics for all buckets
-func (c *S3Client) GetAllBucketsUsage(ctx context.Context) ([]models.BucketUsage, error) {
+// GetAllBucketsUsage retrieves usage statistics for all buckets matching the
+// filter. All samples get the given timestamp so that one collection run forms
+// a consistent snapshot. Buckets whose statistics cannot be retrieved are
+// reported as failures in the result while the remaining buckets are still
+// collected. The result always contains the complete bucket list, with the
+// buckets not matching the filter listed as excluded.
+func (c *S3Client) GetAllBucketsUsage(ctx context.Context, timestamp time.Time, filter *BucketFilter) (*models.CollectionResult, error) {
 	// Get list of buckets
 	buckets, err := c.GetBuckets(ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	// Explicitly requested buckets that do not exist are failures
+	result := &models.CollectionResult{Buckets: buckets}
+	if filter != nil && filter.only != nil {
+		listed := make(map[string]bool, len(buckets))
+		for _, name := range buckets {
+			listed[name] = true
+		}
+		for _, name := range filter.names {
+			if !listed[name] {
+				result.Failures = append(result.Failures, models.BucketError{
+					BucketName: name,
+					Message:    "bucket does not exist",
+				})
+			}
+		}
+	}
+
 	// Get usage stats for each bucket
-	var usages []models.BucketUsage
 	for _, bucketName := range buckets {
+		if !filter.Match(bucketName) {
+			result.Excluded = append(result.Excluded, bucketName)
+			continue
+		}
 		fmt.Printf("Collecting statistics for bucket: %s\n", bucketName)
-		usage, err := c.GetBucketUsage(ctx, bucketName)
+		stats, err := c.GetBucketStats(ctx, bucketName)
 		if err != nil {
 			// Log error but continue with other buckets
-			fmt.Printf("Error getting usage for bucket %s: %v\n", bucketName, err)
+			fmt.Fprintf(os.Stderr, "Error getting usage for bucket %s: %v\n", bucketName, err)
+			result.Failures = append(result.Failures, models.BucketError{
+				BucketName: bucketName,
+				Message:    err.Error(),
+			})
 			continue
 		}
-		usages = append(usages, *usage)
+		usage := stats.usage(bucketName, timestamp)
+		usage.Owner = stats.OwnerName
+		usage.Placement = stats.PlacementID
+		result.Usages = append(result.Usages, usage)
+		quotaSize, quotaObjects := stats.Quota.Limits()
+		result.Info = append(result.Info, models.BucketInfo{
+			Name:           bucketName,
+			CreatedAt:      stats.CreationTime(),
+			Owner:          stats.OwnerName,
+			QuotaSizeBytes: quotaSize,
+			QuotaObjects:   quotaObjects,
+			Placement:      stats.PlacementID,
+		})
 	}
 
-	return usages, nil
+	return result, nil
 }
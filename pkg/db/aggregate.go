@@ -0,0 +1,466 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// lifetime is a period during which a bucket existed. A zero start or end
+// means the bucket existed before or after everything we know about it.
+type lifetime struct {
+	start time.Time
+	end   time.Time
+}
+
+// bucketLifetimes returns the periods during which a bucket existed, based on
+// the creation time reported by the cluster and the detected deletion and
+// reappearance events. A bucket the lifecycle history knows nothing about is
+// assumed to have existed all the time.
+func (db *DB) bucketLifetimes(bucketName string) ([]lifetime, error) {
+	var createdAt sql.NullTime
+	err := db.QueryRow(`
+		SELECT created_at FROM buckets
+		WHERE name = ? AND created_at IS NOT NULL
+		ORDER BY created_at LIMIT 1
+	`, bucketName).Scan(&createdAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to query bucket %s: %w", bucketName, err)
+	}
+
+	rows, err := db.Query(`
+		SELECT event_type, timestamp
+		FROM bucket_events
+		WHERE bucket_name = ? AND event_type IN (?, ?)
+		ORDER BY timestamp, id
+	`, bucketName, models.EventDeleted, models.EventReappeared)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events of bucket %s: %w", bucketName, err)
+	}
+	defer rows.Close()
+
+	lifetimes := []lifetime{{}}
+	for rows.Next() {
+		var eventType string
+		var ts time.Time
+		if err := rows.Scan(&eventType, &ts); err != nil {
+			return nil, fmt.Errorf("failed to scan event of bucket %s: %w", bucketName, err)
+		}
+		last := &lifetimes[len(lifetimes)-1]
+		switch {
+		case eventType == models.EventDeleted && last.end.IsZero():
+			last.end = ts
+		case eventType == models.EventReappeared && !last.end.IsZero():
+			lifetimes = append(lifetimes, lifetime{start: ts})
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating events of bucket %s: %w", bucketName, err)
+	}
+
+	// The cluster only reports when the current incarnation of the bucket was
+	// created. It moves the start of the lifetime it falls into back to the
+	// actual creation, which happened before the collection noticed it.
+	if createdAt.Valid {
+		created := createdAt.Time
+		for i := range lifetimes {
+			lt := &lifetimes[i]
+			if (i == 0 || created.After(lifetimes[i-1].end)) && (lt.end.IsZero() || created.Before(lt.end)) {
+				lt.start = created
+				break
+			}
+		}
+	}
+
+	return lifetimes, nil
+}
+
+// existedFraction returns the fraction of the period from start to end during
+// which the bucket existed
+func existedFraction(lifetimes []lifetime, start, end time.Time) float64 {
+	if !end.After(start) {
+		return 1
+	}
+
+	var existed time.Duration
+	for _, lt := range lifetimes {
+		from, to := start, end
+		if !lt.start.IsZero() && lt.start.After(from) {
+			from = lt.start
+		}
+		if !lt.end.IsZero() && lt.end.Before(to) {
+			to = lt.end
+		}
+		if to.After(from) {
+			existed += to.Sub(from)
+		}
+	}
+	return float64(existed) / float64(end.Sub(start))
+}
+
+// DefaultInterval is the expected time between two samples if none is configured
+const DefaultInterval = 24 * time.Hour
+
+// monthPeriod returns the start of the month and the end of the examined
+// period, which is the start of the next month or now for the current month
+func monthPeriod(year, month int) (time.Time, time.Time) {
+	start := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	end := start.AddDate(0, 1, 0)
+	if now := time.Now().UTC(); now.Before(end) {
+		end = now
+	}
+	return start, end
+}
+
+// groupByBucket splits samples ordered by bucket name into one slice per bucket
+func groupByBucket(usages []models.BucketUsage) [][]models.BucketUsage {
+	var groups [][]models.BucketUsage
+	for i, u := range usages {
+		if i == 0 || usages[i-1].BucketName != u.BucketName {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], u)
+	}
+	return groups
+}
+
+// monthUsage returns the samples of all buckets from start to end, ordered by
+// bucket name and timestamp. Where retention removed the raw samples of a
+// bucket, its hourly rollups and, before those, its daily rollups stand in for
+// them. A rollup counts as its number of samples at its average, spread evenly
+// over its period, so averages and coverage match those of the raw samples.
+func monthUsage(q queryer, start, end time.Time) ([]models.BucketUsage, error) {
+	usages, err := queryUsage(q, "timestamp >= ? AND timestamp < ?", start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retention removes the oldest data first, so each resolution is used
+	// up to the earliest data of the finer ones
+	covered := make(map[string]time.Time)
+	for _, u := range usages {
+		if _, ok := covered[u.BucketName]; !ok {
+			covered[u.BucketName] = u.Timestamp
+		}
+	}
+	periods := []struct {
+		resolution string
+		length     time.Duration
+	}{
+		{models.ResolutionHourly, time.Hour},
+		{models.ResolutionDaily, 24 * time.Hour},
+	}
+	for _, period := range periods {
+		rollups, err := queryRollups(q, period.resolution, "period_start >= ? AND period_start < ?", start, end)
+		if err != nil {
+			return nil, err
+		}
+		earliest := make(map[string]time.Time)
+		for _, r := range rollups {
+			if until, ok := covered[r.BucketName]; ok && r.Timestamp.Add(period.length).After(until) {
+				continue
+			}
+			if e, ok := earliest[r.BucketName]; !ok || r.Timestamp.Before(e) {
+				earliest[r.BucketName] = r.Timestamp
+			}
+			step := period.length / time.Duration(r.DataPoints)
+			for i := 0; i < r.DataPoints; i++ {
+				usages = append(usages, models.BucketUsage{
+					BucketName:  r.BucketName,
+					SizeBytes:   int64(r.AvgSizeBytes),
+					ObjectCount: int64(r.AvgObjectCount),
+					Timestamp:   r.Timestamp.Add(time.Duration(i) * step),
+				})
+			}
+		}
+		for bucket, e := range earliest {
+			covered[bucket] = e
+		}
+	}
+
+	sort.SliceStable(usages, func(i, j int) bool {
+		if usages[i].BucketName != usages[j].BucketName {
+			return usages[i].BucketName < usages[j].BucketName
+		}
+		return usages[i].Timestamp.Before(usages[j].Timestamp)
+	})
+	return usages, nil
+}
+
+// monthCoverage describes how well the samples of a bucket cover a month
+type monthCoverage struct {
+	// expected is the time of the month during which the bucket existed
+	expected time.Duration
+	// missing is the part of expected not covered by samples
+	missing time.Duration
+	// longestGap is the longest time without a sample
+	longestGap time.Duration
+	gaps       []models.Gap
+}
+
+// percent returns the covered part of the month as a percentage
+func (c monthCoverage) percent() float64 {
+	if c.expected <= 0 {
+		return 100
+	}
+	return 100 * (1 - float64(c.missing)/float64(c.expected))
+}
+
+// sampleCoverage finds the gaps in the samples of a bucket within the parts of
+// the period from start to end during which the bucket existed. Each sample
+// covers one interval, so a time without samples counts as a gap once it is
+// longer than one and a half intervals.
+func sampleCoverage(samples []models.BucketUsage, lifetimes []lifetime, start, end time.Time, interval time.Duration) monthCoverage {
+	var c monthCoverage
+	tolerance := interval * 3 / 2
+
+	for _, lt := range lifetimes {
+		from, to := start, end
+		if !lt.start.IsZero() && lt.start.After(from) {
+			from = lt.start
+		}
+		if !lt.end.IsZero() && lt.end.Before(to) {
+			to = lt.end
+		}
+		if !to.After(from) {
+			continue
+		}
+		c.expected += to.Sub(from)
+
+		// The boundaries of the lifetime act as samples
+		prev := from
+		check := func(next time.Time) {
+			gap := next.Sub(prev)
+			if gap > c.longestGap {
+				c.longestGap = gap
+			}
+			if gap > tolerance {
+				c.missing += gap - interval
+				c.gaps = append(c.gaps, models.Gap{BucketName: samples[0].BucketName, Start: prev, End: next})
+			}
+		}
+		for _, s := range samples {
+			if s.Timestamp.Before(from) || !s.Timestamp.Before(to) {
+				continue
+			}
+			check(s.Timestamp)
+			prev = s.Timestamp
+		}
+		check(to)
+	}
+
+	if c.missing > c.expected {
+		c.missing = c.expected
+	}
+	return c
+}
+
+// withDefaults validates the aggregation options and fills in the defaults
+func withDefaults(opts models.AggregationOptions) (models.AggregationOptions, error) {
+	if opts.Mode == "" {
+		opts.Mode = models.AggregationSamples
+	}
+	if opts.Mode != models.AggregationSamples && opts.Mode != models.AggregationProrated {
+		return opts, fmt.Errorf("unknown aggregation mode %q", opts.Mode)
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = DefaultInterval
+	}
+	switch opts.Fill {
+	case "":
+		opts.Fill = models.FillNone
+	case models.FillNone, models.FillLinear, models.FillCarry:
+	default:
+		return opts, fmt.Errorf("unknown fill method %q", opts.Fill)
+	}
+	return opts, nil
+}
+
+// fillGaps returns synthetic points, one per interval, for every gap longer
+// than the fill threshold. Linear filling interpolates between the samples
+// around the gap, carry-forward repeats the sample before it. Gaps at the start
+// or end of the period have a sample on one side only and repeat that sample.
+func fillGaps(samples []models.BucketUsage, gaps []models.Gap, opts models.AggregationOptions) []models.BucketUsage {
+	if opts.Fill == models.FillNone {
+		return nil
+	}
+
+	var synthetic []models.BucketUsage
+	for _, gap := range gaps {
+		if gap.End.Sub(gap.Start) <= opts.FillThreshold {
+			continue
+		}
+
+		// The samples are ordered by timestamp
+		i := sort.Search(len(samples), func(i int) bool { return !samples[i].Timestamp.Before(gap.End) })
+		var before, after *models.BucketUsage
+		if i < len(samples) && samples[i].Timestamp.Equal(gap.End) {
+			after = &samples[i]
+		}
+		if i > 0 && !samples[i-1].Timestamp.After(gap.Start) {
+			before = &samples[i-1]
+		}
+		if before == nil {
+			before = after
+		}
+		if after == nil || opts.Fill == models.FillCarry {
+			after = before
+		}
+		if before == nil {
+			continue
+		}
+
+		span := float64(gap.End.Sub(gap.Start))
+		for t := gap.Start.Add(opts.Interval); gap.End.Sub(t) >= opts.Interval/2; t = t.Add(opts.Interval) {
+			f := float64(t.Sub(gap.Start)) / span
+			synthetic = append(synthetic, models.BucketUsage{
+				BucketName:  gap.BucketName,
+				SizeBytes:   before.SizeBytes + int64(f*float64(after.SizeBytes-before.SizeBytes)),
+				ObjectCount: before.ObjectCount + int64(f*float64(after.ObjectCount-before.ObjectCount)),
+				Timestamp:   t,
+			})
+		}
+	}
+	return synthetic
+}
+
+// CalculateMonthlyAverages calculates the monthly averages for all buckets.
+// In prorated mode the average of the samples is scaled by the fraction of the
+// month the bucket existed. For the current month only the part of the month
+// that has passed is taken into account. Along with each average the sample
+// coverage and the longest gap are stored, based on the expected interval.
+// Months whose raw samples were removed by retention are calculated from the
+// rollups that are left, see monthUsage. Buckets without any data in the month
+// keep their stored average. The owner and placement of the bucket's last
+// sample that recorded them are stored with the average; rollups do not record
+// them, so a recalculation from rollups keeps the stored ones.
+func (db *DB) CalculateMonthlyAverages(year, month int, opts models.AggregationOptions) error {
+	opts, err := withDefaults(opts)
+	if err != nil {
+		return err
+	}
+
+	startDate, periodEnd := monthPeriod(year, month)
+	usages, err := monthUsage(db, startDate, startDate.AddDate(0, 1, 0))
+	if err != nil {
+		return err
+	}
+
+	var averages []models.MonthlyBucketAverage
+	for _, samples := range groupByBucket(usages) {
+		avg := models.MonthlyBucketAverage{
+			BucketName:  samples[0].BucketName,
+			Year:        year,
+			Month:       month,
+			DataPoints:  len(samples),
+			Aggregation: opts.Mode,
+		}
+		for _, s := range samples {
+			if s.Owner != "" {
+				avg.Owner = s.Owner
+			}
+			if s.Placement != "" {
+				avg.Placement = s.Placement
+			}
+		}
+
+		lifetimes, err := db.bucketLifetimes(avg.BucketName)
+		if err != nil {
+			return err
+		}
+		coverage := sampleCoverage(samples, lifetimes, startDate, periodEnd, opts.Interval)
+		pct := coverage.percent()
+		longestGap := int64(coverage.longestGap.Seconds())
+		avg.CoveragePct = &pct
+		avg.LongestGapSeconds = &longestGap
+
+		// Synthetic points for the filled gaps count like samples
+		synthetic := fillGaps(samples, coverage.gaps, opts)
+		avg.FillMethod = opts.Fill
+		avg.SyntheticPoints = len(synthetic)
+		for _, s := range append(synthetic, samples...) {
+			avg.AvgSizeBytes += float64(s.SizeBytes)
+			avg.AvgObjectCount += float64(s.ObjectCount)
+		}
+		avg.AvgSizeBytes /= float64(avg.DataPoints + avg.SyntheticPoints)
+		avg.AvgObjectCount /= float64(avg.DataPoints + avg.SyntheticPoints)
+
+		if opts.Mode == models.AggregationProrated {
+			fraction := existedFraction(lifetimes, startDate, periodEnd)
+			avg.AvgSizeBytes *= fraction
+			avg.AvgObjectCount *= fraction
+		}
+
+		averages = append(averages, avg)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, avg := range averages {
+		// Insert or update the monthly average
+		_, err = tx.Exec(`
+			INSERT INTO monthly_averages
+			(bucket_name, year, month, avg_size_bytes, avg_object_count, data_points,
+				aggregation, coverage_pct, longest_gap_seconds, fill_method, synthetic_points,
+				owner, placement)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+			ON CONFLICT(bucket_name, year, month)
+			DO UPDATE SET
+				avg_size_bytes = excluded.avg_size_bytes,
+				avg_object_count = excluded.avg_object_count,
+				data_points = excluded.data_points,
+				aggregation = excluded.aggregation,
+				coverage_pct = excluded.coverage_pct,
+				longest_gap_seconds = excluded.longest_gap_seconds,
+				fill_method = excluded.fill_method,
+				synthetic_points = excluded.synthetic_points,
+				owner = CASE WHEN excluded.owner != '' THEN excluded.owner ELSE owner END,
+				placement = CASE WHEN excluded.placement != '' THEN excluded.placement ELSE placement END
+		`, avg.BucketName, avg.Year, avg.Month, avg.AvgSizeBytes, avg.AvgObjectCount, avg.DataPoints,
+			avg.Aggregation, *avg.CoveragePct, *avg.LongestGapSeconds, avg.FillMethod, avg.SyntheticPoints,
+			avg.Owner, avg.Placement)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// FindGaps returns the periods of a month in which samples are missing, based
+// on the expected interval. Like CalculateMonthlyAverages it falls back to the
+// rollups where retention removed the raw samples, so both agree on coverage.
+// Only buckets with at least one sample in the month are examined. An empty
+// bucket name examines all buckets.
+func (db *DB) FindGaps(year, month int, bucketName string, opts models.AggregationOptions) ([]models.Gap, error) {
+	opts, err := withDefaults(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	startDate, periodEnd := monthPeriod(year, month)
+	usages, err := monthUsage(db, startDate, startDate.AddDate(0, 1, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	var gaps []models.Gap
+	for _, samples := range groupByBucket(usages) {
+		if bucketName != "" && samples[0].BucketName != bucketName {
+			continue
+		}
+		lifetimes, err := db.bucketLifetimes(samples[0].BucketName)
+		if err != nil {
+			return nil, err
+		}
+		gaps = append(gaps, sampleCoverage(samples, lifetimes, startDate, periodEnd, opts.Interval).gaps...)
+	}
+	return gaps, nil
+}
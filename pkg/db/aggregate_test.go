@@ -0,0 +1,67 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+func TestCalculateMonthlyAveragesFromRollups(t *testing.T) {
+	database := newTestDB(t)
+	start := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// One sample every 6 hours, growing by 100 bytes per sample
+	var samples []models.BucketUsage
+	for i := 0; i < 31*4; i++ {
+		samples = append(samples, sample("a", start.Add(time.Duration(i)*6*time.Hour), int64(100*i)))
+	}
+	storeSamples(t, database, samples...)
+	if _, _, err := database.RefreshRollups(time.Time{}); err != nil {
+		t.Fatalf("RefreshRollups: %v", err)
+	}
+	opts := models.AggregationOptions{Interval: 6 * time.Hour}
+	average := func() models.MonthlyBucketAverage {
+		t.Helper()
+		if err := database.CalculateMonthlyAverages(2025, 3, opts); err != nil {
+			t.Fatalf("CalculateMonthlyAverages: %v", err)
+		}
+		avg, err := database.GetMonthlyAverage("a", 2025, 3)
+		if err != nil {
+			t.Fatalf("GetMonthlyAverage: %v", err)
+		}
+		return *avg
+	}
+	want := average()
+	gaps := func() int {
+		t.Helper()
+		found, err := database.FindGaps(2025, 3, "a", opts)
+		if err != nil {
+			t.Fatalf("FindGaps: %v", err)
+		}
+		return len(found)
+	}
+	wantGaps := gaps()
+
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"raw removed in the first half", `DELETE FROM bucket_usage WHERE timestamp < '2025-03-16'`},
+		{"hourly removed in the first week", `DELETE FROM usage_rollups WHERE resolution = 'hourly' AND period_start < '2025-03-08'`},
+		{"raw and hourly removed", `DELETE FROM bucket_usage; DELETE FROM usage_rollups WHERE resolution = 'hourly'`},
+	}
+	for _, tt := range tests {
+		if _, err := database.Exec(tt.query); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got := average()
+		if got.AvgSizeBytes != want.AvgSizeBytes || got.DataPoints != want.DataPoints || *got.CoveragePct != *want.CoveragePct {
+			t.Errorf("%s: average %v from %d points with %.1f%% coverage, want %v from %d points with %.1f%%", tt.name,
+				got.AvgSizeBytes, got.DataPoints, *got.CoveragePct, want.AvgSizeBytes, want.DataPoints, *want.CoveragePct)
+		}
+		if n := gaps(); n != wantGaps {
+			t.Errorf("%s: %d gaps, want %d", tt.name, n, wantGaps)
+		}
+	}
+}
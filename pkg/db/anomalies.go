@@ -0,0 +1,178 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// percentChange returns the change from one value to another in percent
+func percentChange(from, to int64) float64 {
+	return float64(to-from) / float64(from) * 100
+}
+
+// checkAnomalies checks a sample against the rules, comparing it with the
+// history of its bucket before the sample, oldest first
+func checkAnomalies(usage models.BucketUsage, history []models.UsagePoint, rules models.AnomalyRules) []models.Anomaly {
+	if len(history) == 0 {
+		return nil
+	}
+	prev := history[len(history)-1]
+	if max(prev.LastSizeBytes, usage.SizeBytes) < rules.MinSizeBytes {
+		return nil
+	}
+
+	base := models.Anomaly{
+		BucketName:          usage.BucketName,
+		RunID:               usage.RunID,
+		Timestamp:           usage.Timestamp,
+		PreviousSizeBytes:   prev.LastSizeBytes,
+		SizeBytes:           usage.SizeBytes,
+		PreviousObjectCount: prev.LastObjectCount,
+		ObjectCount:         usage.ObjectCount,
+	}
+	var anomalies []models.Anomaly
+	add := func(rule string, value, threshold float64, message string) {
+		a := base
+		a.Rule = rule
+		a.Value = value
+		a.Threshold = threshold
+		a.Message = message
+		anomalies = append(anomalies, a)
+	}
+
+	// A bucket that was empty has no relative change
+	if prev.LastSizeBytes > 0 {
+		sizePct := percentChange(prev.LastSizeBytes, usage.SizeBytes)
+		if rules.MaxChangePct > 0 && math.Abs(sizePct) >= rules.MaxChangePct {
+			add(models.AnomalyChange, sizePct, rules.MaxChangePct,
+				fmt.Sprintf("size changed by %+.1f%% since %s", sizePct, prev.Timestamp.Format(time.RFC3339)))
+		}
+
+		if rules.DivergencePct > 0 && prev.LastObjectCount > 0 {
+			objectPct := percentChange(prev.LastObjectCount, usage.ObjectCount)
+			if d := math.Abs(sizePct - objectPct); d >= rules.DivergencePct {
+				add(models.AnomalyDivergence, d, rules.DivergencePct,
+					fmt.Sprintf("size changed by %+.1f%% but object count by %+.1f%%", sizePct, objectPct))
+			}
+		}
+	}
+
+	// A constant history has no spread to measure the distance with, large
+	// changes from it are found by the change rule
+	if rules.ZScore > 0 && len(history) >= max(rules.MinSamples, 2) {
+		var sum float64
+		for _, p := range history {
+			sum += float64(p.LastSizeBytes)
+		}
+		mean := sum / float64(len(history))
+		var ss float64
+		for _, p := range history {
+			d := float64(p.LastSizeBytes) - mean
+			ss += d * d
+		}
+		std := math.Sqrt(ss / float64(len(history)-1))
+		if std > 0 {
+			z := (float64(usage.SizeBytes) - mean) / std
+			if math.Abs(z) >= rules.ZScore {
+				add(models.AnomalyZScore, z, rules.ZScore,
+					fmt.Sprintf("size is %.1f standard deviations from the mean of the last %d values", z, len(history)))
+			}
+		}
+	}
+
+	return anomalies
+}
+
+// DetectAnomalies checks the samples of a collection run against the recent
+// history of their buckets and stores the anomalies found. The samples may
+// already be stored, only the history before each sample is compared.
+func (db *DB) DetectAnomalies(usages []models.BucketUsage, rules models.AnomalyRules) ([]models.Anomaly, error) {
+	var anomalies []models.Anomaly
+	for _, usage := range usages {
+		at := usage.Timestamp.UTC()
+		history, err := db.GetHistory(usage.BucketName, models.HistoryOptions{
+			Start: at.Add(-rules.Window),
+			End:   at.Add(-time.Nanosecond),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve history of bucket %s: %w", usage.BucketName, err)
+		}
+		anomalies = append(anomalies, checkAnomalies(usage, history, rules)...)
+	}
+	if len(anomalies) == 0 {
+		return nil, nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for i, a := range anomalies {
+		var runID interface{}
+		if a.RunID != 0 {
+			runID = a.RunID
+		}
+		res, err := tx.Exec(`
+			INSERT INTO anomalies (bucket_name, run_id, timestamp, rule, value, threshold,
+				previous_size_bytes, size_bytes, previous_object_count, object_count, message)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, a.BucketName, runID, a.Timestamp.UTC(), a.Rule, a.Value, a.Threshold,
+			a.PreviousSizeBytes, a.SizeBytes, a.PreviousObjectCount, a.ObjectCount, a.Message)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record anomaly of bucket %s: %w", a.BucketName, err)
+		}
+		anomalies[i].ID, _ = res.LastInsertId()
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return anomalies, nil
+}
+
+// GetAnomalies returns the anomalies detected between startTime and endTime,
+// newest first. An empty bucket name or rule returns the anomalies of all
+// buckets or rules.
+func (db *DB) GetAnomalies(bucketName, rule string, startTime, endTime time.Time) ([]models.Anomaly, error) {
+	query := `
+		SELECT id, bucket_name, run_id, timestamp, rule, value, threshold,
+			previous_size_bytes, size_bytes, previous_object_count, object_count, message
+		FROM anomalies
+		WHERE timestamp BETWEEN ? AND ?`
+	args := []interface{}{startTime.UTC(), endTime.UTC()}
+	if bucketName != "" {
+		query += ` AND bucket_name = ?`
+		args = append(args, bucketName)
+	}
+	if rule != "" {
+		query += ` AND rule = ?`
+		args = append(args, rule)
+	}
+	query += ` ORDER BY timestamp DESC, id DESC`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var anomalies []models.Anomaly
+	for rows.Next() {
+		var a models.Anomaly
+		var runID sql.NullInt64
+		if err := rows.Scan(&a.ID, &a.BucketName, &runID, &a.Timestamp, &a.Rule, &a.Value, &a.Threshold,
+			&a.PreviousSizeBytes, &a.SizeBytes, &a.PreviousObjectCount, &a.ObjectCount, &a.Message); err != nil {
+			return nil, err
+		}
+		a.RunID = runID.Int64
+		anomalies = append(anomalies, a)
+	}
+
+	return anomalies, rows.Err()
+}
@@ -0,0 +1,64 @@
+package db
+
+import (
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// change returns the difference between two optional values, counting missing
+// values as zero, and the change in percent if the earlier value is not zero
+func change(from, to *float64) (float64, *float64) {
+	var a, b float64
+	if from != nil {
+		a = *from
+	}
+	if to != nil {
+		b = *to
+	}
+	if a == 0 {
+		return b - a, nil
+	}
+	pct := (b - a) / a * 100
+	return b - a, &pct
+}
+
+// CompareMonths joins the monthly averages of two months. Every bucket with an
+// average in either month is returned, ordered by bucket name.
+func (db *DB) CompareMonths(fromYear, fromMonth, toYear, toMonth int) ([]models.MonthComparison, error) {
+	rows, err := db.Query(`
+		SELECT n.bucket_name, a.avg_size_bytes, b.avg_size_bytes, a.avg_object_count, b.avg_object_count
+		FROM (
+			SELECT DISTINCT bucket_name FROM monthly_averages
+			WHERE (year = ? AND month = ?) OR (year = ? AND month = ?)
+		) n
+		LEFT JOIN monthly_averages a ON a.bucket_name = n.bucket_name AND a.year = ? AND a.month = ?
+		LEFT JOIN monthly_averages b ON b.bucket_name = n.bucket_name AND b.year = ? AND b.month = ?
+		ORDER BY n.bucket_name
+	`, fromYear, fromMonth, toYear, toMonth, fromYear, fromMonth, toYear, toMonth)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comparisons []models.MonthComparison
+	for rows.Next() {
+		var c models.MonthComparison
+		if err := rows.Scan(&c.BucketName, &c.FromSizeBytes, &c.ToSizeBytes, &c.FromObjectCount, &c.ToObjectCount); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case c.FromSizeBytes == nil:
+			c.Status = models.ComparisonNew
+		case c.ToSizeBytes == nil:
+			c.Status = models.ComparisonVanished
+		default:
+			c.Status = models.ComparisonExisting
+		}
+		c.SizeChangeBytes, c.SizeChangePct = change(c.FromSizeBytes, c.ToSizeBytes)
+		c.ObjectCountChange, c.ObjectCountChangePct = change(c.FromObjectCount, c.ToObjectCount)
+
+		comparisons = append(comparisons, c)
+	}
+
+	return comparisons, rows.Err()
+}
@@ -0,0 +1,308 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// GetSample returns a single sample by its ID
+func (db *DB) GetSample(id int64) (*models.BucketUsage, error) {
+	usages, err := queryUsage(db, "id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(usages) == 0 {
+		return nil, fmt.Errorf("sample %d not found", id)
+	}
+	return &usages[0], nil
+}
+
+// recordCorrection inserts a correction into the audit log
+func recordCorrection(tx *sql.Tx, c *models.Correction) error {
+	c.CreatedAt = time.Now().UTC()
+	res, err := tx.Exec(`
+		INSERT INTO corrections
+		(sample_id, bucket_name, action, timestamp, old_size_bytes, old_object_count,
+			new_size_bytes, new_object_count, reason, author, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, c.SampleID, c.BucketName, c.Action, c.Timestamp.UTC(), c.OldSizeBytes, c.OldObjectCount,
+		c.NewSizeBytes, c.NewObjectCount, c.Reason, c.Author, c.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record correction: %w", err)
+	}
+	c.ID, err = res.LastInsertId()
+	return err
+}
+
+// AddSample manually adds a sample, e.g. for a collection that failed, and
+// records the correction with its reason and author
+func (db *DB) AddSample(usage models.BucketUsage, reason, author string) (*models.Correction, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO bucket_usage (bucket_name, size_bytes, object_count, timestamp, corrected)
+		VALUES (?, ?, ?, ?, 1)
+	`, usage.BucketName, usage.SizeBytes, usage.ObjectCount, usage.Timestamp.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to add sample: %w", err)
+	}
+
+	c := &models.Correction{
+		BucketName:     usage.BucketName,
+		Action:         models.CorrectionAdd,
+		Timestamp:      usage.Timestamp,
+		NewSizeBytes:   &usage.SizeBytes,
+		NewObjectCount: &usage.ObjectCount,
+		Reason:         reason,
+		Author:         author,
+	}
+	if c.SampleID, err = res.LastInsertId(); err != nil {
+		return nil, err
+	}
+	if err := recordCorrection(tx, c); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return c, nil
+}
+
+// EditSample changes the size and object count of a sample, flags it as
+// corrected and records the old and new values with the reason and author
+func (db *DB) EditSample(id, sizeBytes, objectCount int64, reason, author string) (*models.Correction, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	usages, err := queryUsage(tx, "id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(usages) == 0 {
+		return nil, fmt.Errorf("sample %d not found", id)
+	}
+	old := usages[0]
+
+	_, err = tx.Exec(`
+		UPDATE bucket_usage SET size_bytes = ?, object_count = ?, corrected = 1 WHERE id = ?
+	`, sizeBytes, objectCount, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to edit sample: %w", err)
+	}
+
+	c := &models.Correction{
+		SampleID:       id,
+		BucketName:     old.BucketName,
+		Action:         models.CorrectionEdit,
+		Timestamp:      old.Timestamp,
+		OldSizeBytes:   &old.SizeBytes,
+		OldObjectCount: &old.ObjectCount,
+		NewSizeBytes:   &sizeBytes,
+		NewObjectCount: &objectCount,
+		Reason:         reason,
+		Author:         author,
+	}
+	if err := recordCorrection(tx, c); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return c, nil
+}
+
+// DeleteSample removes a sample and records its values with the reason and
+// author, so the deletion can be traced and undone
+func (db *DB) DeleteSample(id int64, reason, author string) (*models.Correction, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	usages, err := queryUsage(tx, "id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if len(usages) == 0 {
+		return nil, fmt.Errorf("sample %d not found", id)
+	}
+	old := usages[0]
+
+	if _, err := tx.Exec(`DELETE FROM bucket_usage WHERE id = ?`, id); err != nil {
+		return nil, fmt.Errorf("failed to delete sample: %w", err)
+	}
+	// Anomalies found in the sample go with it
+	_, err = tx.Exec(`
+		DELETE FROM anomalies
+		WHERE bucket_name = ? AND timestamp = ?
+	`, old.BucketName, old.Timestamp.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete anomalies of sample: %w", err)
+	}
+
+	c := &models.Correction{
+		SampleID:       id,
+		BucketName:     old.BucketName,
+		Action:         models.CorrectionDelete,
+		Timestamp:      old.Timestamp,
+		OldSizeBytes:   &old.SizeBytes,
+		OldObjectCount: &old.ObjectCount,
+		Reason:         reason,
+		Author:         author,
+	}
+	if err := recordCorrection(tx, c); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return c, nil
+}
+
+// GetCorrections returns all corrections, oldest first. An empty bucket name
+// returns the corrections of all buckets.
+func (db *DB) GetCorrections(bucketName string) ([]models.Correction, error) {
+	query := `
+		SELECT id, sample_id, bucket_name, action, timestamp, old_size_bytes, old_object_count,
+			new_size_bytes, new_object_count, reason, author, created_at
+		FROM corrections`
+	var args []interface{}
+	if bucketName != "" {
+		query += ` WHERE bucket_name = ?`
+		args = append(args, bucketName)
+	}
+	query += ` ORDER BY created_at, id`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var corrections []models.Correction
+	for rows.Next() {
+		var c models.Correction
+		if err := rows.Scan(&c.ID, &c.SampleID, &c.BucketName, &c.Action, &c.Timestamp,
+			&c.OldSizeBytes, &c.OldObjectCount, &c.NewSizeBytes, &c.NewObjectCount,
+			&c.Reason, &c.Author, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		corrections = append(corrections, c)
+	}
+
+	return corrections, rows.Err()
+}
+
+// AddAnnotation stores an annotation and returns its ID
+func (db *DB) AddAnnotation(a models.Annotation) (int64, error) {
+	res, err := db.Exec(`
+		INSERT INTO annotations (bucket_name, timestamp, text, author, created_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, a.BucketName, a.Timestamp.UTC(), a.Text, a.Author, time.Now().UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to add annotation: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+// DeleteAnnotation removes an annotation
+func (db *DB) DeleteAnnotation(id int64) error {
+	res, err := db.Exec(`DELETE FROM annotations WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete annotation: %w", err)
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("annotation %d not found", id)
+	}
+	return nil
+}
+
+// GetAnnotations returns the annotations between startTime and endTime, oldest
+// first. For a bucket, the annotations that apply to all buckets are included.
+// An empty bucket name returns the annotations of all buckets.
+func (db *DB) GetAnnotations(bucketName string, startTime, endTime time.Time) ([]models.Annotation, error) {
+	query := `
+		SELECT id, bucket_name, timestamp, text, author, created_at
+		FROM annotations
+		WHERE timestamp BETWEEN ? AND ?`
+	args := []interface{}{startTime.UTC(), endTime.UTC()}
+	if bucketName != "" {
+		query += ` AND bucket_name IN (?, '')`
+		args = append(args, bucketName)
+	}
+	query += ` ORDER BY timestamp, id`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var annotations []models.Annotation
+	for rows.Next() {
+		var a models.Annotation
+		if err := rows.Scan(&a.ID, &a.BucketName, &a.Timestamp, &a.Text, &a.Author, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		annotations = append(annotations, a)
+	}
+
+	return annotations, rows.Err()
+}
+
+// RecalculateSample updates the rollups and monthly averages after the sample of
+// a bucket at the given time was added, edited or deleted. The bucket's hourly
+// and daily rollups and monthly average of that time are deleted before they
+// are recalculated, so they disappear if the sample was the only one of its
+// period instead of keeping their old values.
+func (db *DB) RecalculateSample(bucketName string, at time.Time, opts models.AggregationOptions) error {
+	hourStart, dayStart := truncateHour(at), truncateDay(at)
+	monthStart := time.Date(dayStart.Year(), dayStart.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		DELETE FROM usage_rollups
+		WHERE bucket_name = ? AND ((resolution = ? AND period_start = ?) OR (resolution = ? AND period_start = ?))
+	`, bucketName, models.ResolutionHourly, hourStart, models.ResolutionDaily, dayStart)
+	if err != nil {
+		return fmt.Errorf("failed to delete rollups: %w", err)
+	}
+	_, err = tx.Exec(`
+		DELETE FROM monthly_averages
+		WHERE bucket_name = ? AND year = ? AND month = ?
+	`, bucketName, monthStart.Year(), int(monthStart.Month()))
+	if err != nil {
+		return fmt.Errorf("failed to delete monthly average: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	// Monthly averages fall back to the rollups, so those are updated first
+	if _, _, err := db.RefreshRollups(hourStart); err != nil {
+		return fmt.Errorf("failed to update rollups: %w", err)
+	}
+	if err := db.CalculateMonthlyAverages(monthStart.Year(), int(monthStart.Month()), opts); err != nil {
+		return fmt.Errorf("failed to calculate monthly averages for %s: %w", monthStart.Format("2006-01"), err)
+	}
+	return nil
+}
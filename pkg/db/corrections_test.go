@@ -0,0 +1,70 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+func TestRecalculateSampleDeletesOnlySample(t *testing.T) {
+	database := newTestDB(t)
+	at := time.Date(2025, 3, 10, 12, 30, 0, 0, time.UTC)
+	storeSamples(t, database, sample("a", at, 100), sample("b", at, 200))
+
+	opts := models.AggregationOptions{}
+	if _, _, err := database.RefreshRollups(time.Time{}); err != nil {
+		t.Fatalf("RefreshRollups: %v", err)
+	}
+	if err := database.CalculateMonthlyAverages(2025, 3, opts); err != nil {
+		t.Fatalf("CalculateMonthlyAverages: %v", err)
+	}
+
+	var id int64
+	if err := database.QueryRow(`SELECT id FROM bucket_usage WHERE bucket_name = 'a'`).Scan(&id); err != nil {
+		t.Fatalf("query sample: %v", err)
+	}
+	if _, err := database.DeleteSample(id, "wrong statistics", "test"); err != nil {
+		t.Fatalf("DeleteSample: %v", err)
+	}
+	if err := database.RecalculateSample("a", at, opts); err != nil {
+		t.Fatalf("RecalculateSample: %v", err)
+	}
+
+	if n := count(t, database, `SELECT COUNT(*) FROM usage_rollups WHERE bucket_name = 'a'`); n != 0 {
+		t.Errorf("%d rollups of a left, want 0", n)
+	}
+	if n := count(t, database, `SELECT COUNT(*) FROM monthly_averages WHERE bucket_name = 'a'`); n != 0 {
+		t.Errorf("%d monthly averages of a left, want 0", n)
+	}
+	// Other buckets of the same periods are kept
+	if n := count(t, database, `SELECT COUNT(*) FROM usage_rollups WHERE bucket_name = 'b'`); n != 2 {
+		t.Errorf("%d rollups of b, want 2", n)
+	}
+	if n := count(t, database, `SELECT COUNT(*) FROM monthly_averages WHERE bucket_name = 'b'`); n != 1 {
+		t.Errorf("%d monthly averages of b, want 1", n)
+	}
+}
+
+func TestDeleteSampleRemovesAnomalies(t *testing.T) {
+	database := newTestDB(t)
+	at := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	storeSamples(t, database, sample("a", at.Add(-24*time.Hour), 100), sample("a", at, 1000))
+
+	rules := models.AnomalyRules{MaxChangePct: 50, Window: 30 * 24 * time.Hour}
+	found, err := database.DetectAnomalies([]models.BucketUsage{sample("a", at, 1000)}, rules)
+	if err != nil || len(found) != 1 {
+		t.Fatalf("DetectAnomalies = %v, %v; want one anomaly", found, err)
+	}
+
+	var id int64
+	if err := database.QueryRow(`SELECT id FROM bucket_usage WHERE size_bytes = 1000`).Scan(&id); err != nil {
+		t.Fatalf("query sample: %v", err)
+	}
+	if _, err := database.DeleteSample(id, "wrong statistics", "test"); err != nil {
+		t.Fatalf("DeleteSample: %v", err)
+	}
+	if n := count(t, database, `SELECT COUNT(*) FROM anomalies`); n != 0 {
+		t.Errorf("%d anomalies left, want 0", n)
+	}
+}
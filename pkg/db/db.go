@@ -3,6 +3,7 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -14,9 +15,22 @@ type DB struct {
 	*sql.DB
 }
 
-// NewDB creates a new database connection
+// BusyTimeout is how long a connection waits for a lock held by another
+// process before giving up with "database is locked"
+const BusyTimeout = 30 * time.Second
+
+// NewDB creates a new database connection. The database is switched to WAL
+// journaling so readers do not block the collector and vice versa, and every
+// connection waits up to BusyTimeout for locks held by other processes.
 func NewDB(dbPath string) (*DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	sep := "?"
+	if strings.Contains(dbPath, "?") {
+		sep = "&"
+	}
+	dsn := fmt.Sprintf("%s%s_journal_mode=WAL&_busy_timeout=%d&_txlock=immediate",
+		dbPath, sep, BusyTimeout.Milliseconds())
+
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		return nil, err
 	}
@@ -61,121 +75,555 @@ func (db *DB) InitDB() error {
 		return err
 	}
 
+	// Create usage_rollups table holding the hourly and daily downsampled data
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS usage_rollups (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			bucket_name TEXT NOT NULL,
+			resolution TEXT NOT NULL,
+			period_start DATETIME NOT NULL,
+			avg_size_bytes REAL NOT NULL,
+			max_size_bytes INTEGER NOT NULL,
+			last_size_bytes INTEGER NOT NULL,
+			avg_object_count REAL NOT NULL,
+			max_object_count INTEGER NOT NULL,
+			last_object_count INTEGER NOT NULL,
+			data_points INTEGER NOT NULL,
+			UNIQUE(bucket_name, resolution, period_start)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create locks table used to make sure only one collection runs at a time
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS locks (
+			name TEXT PRIMARY KEY,
+			owner TEXT NOT NULL,
+			acquired_at DATETIME NOT NULL,
+			expires_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create collection_runs table journaling every collect run
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS collection_runs (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			endpoint TEXT NOT NULL,
+			started_at DATETIME NOT NULL,
+			finished_at DATETIME,
+			status TEXT NOT NULL,
+			buckets_total INTEGER NOT NULL DEFAULT 0,
+			buckets_succeeded INTEGER NOT NULL DEFAULT 0,
+			buckets_failed INTEGER NOT NULL DEFAULT 0,
+			error TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create collection_run_errors table holding the per-bucket failures of a run
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS collection_run_errors (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			run_id INTEGER NOT NULL REFERENCES collection_runs(id),
+			bucket_name TEXT NOT NULL,
+			message TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create buckets table tracking which buckets of each cluster currently exist
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS buckets (
+			endpoint TEXT NOT NULL DEFAULT '',
+			name TEXT NOT NULL,
+			first_seen DATETIME NOT NULL,
+			last_seen DATETIME NOT NULL,
+			deleted_at DATETIME,
+			PRIMARY KEY (endpoint, name)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create bucket_events table holding the lifecycle history of all buckets
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bucket_events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			bucket_name TEXT NOT NULL,
+			event_type TEXT NOT NULL,
+			timestamp DATETIME NOT NULL,
+			run_id INTEGER REFERENCES collection_runs(id),
+			details TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create corrections table auditing manual changes of samples
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS corrections (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			sample_id INTEGER NOT NULL,
+			bucket_name TEXT NOT NULL,
+			action TEXT NOT NULL,
+			timestamp DATETIME NOT NULL,
+			old_size_bytes INTEGER,
+			old_object_count INTEGER,
+			new_size_bytes INTEGER,
+			new_object_count INTEGER,
+			reason TEXT NOT NULL,
+			author TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create annotations table holding notes shown in the history
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS annotations (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			bucket_name TEXT NOT NULL DEFAULT '',
+			timestamp DATETIME NOT NULL,
+			text TEXT NOT NULL,
+			author TEXT NOT NULL,
+			created_at DATETIME NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create bucket_groups table mapping buckets to groups
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS bucket_groups (
+			group_name TEXT NOT NULL,
+			bucket_name TEXT NOT NULL,
+			PRIMARY KEY (group_name, bucket_name)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create anomalies table holding samples that broke an anomaly rule
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS anomalies (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			bucket_name TEXT NOT NULL,
+			run_id INTEGER REFERENCES collection_runs(id),
+			timestamp DATETIME NOT NULL,
+			rule TEXT NOT NULL,
+			value REAL NOT NULL,
+			threshold REAL NOT NULL,
+			previous_size_bytes INTEGER NOT NULL,
+			size_bytes INTEGER NOT NULL,
+			previous_object_count INTEGER NOT NULL,
+			object_count INTEGER NOT NULL,
+			message TEXT NOT NULL
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create statements table assigning sequential numbers to statements
+	_, err = db.Exec(`
+		CREATE TABLE IF NOT EXISTS statements (
+			number INTEGER PRIMARY KEY,
+			owner TEXT NOT NULL,
+			year INTEGER NOT NULL,
+			month INTEGER NOT NULL,
+			created_at DATETIME NOT NULL,
+			UNIQUE(owner, year, month)
+		)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Remember the creation time reported by the cluster and how monthly
+	// averages were calculated
+	if err := db.addColumn("buckets", "created_at", "DATETIME"); err != nil {
+		return err
+	}
+	if err := db.addColumn("buckets", "owner", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := db.addColumn("monthly_averages", "aggregation", "TEXT NOT NULL DEFAULT 'samples'"); err != nil {
+		return err
+	}
+	if err := db.addColumn("monthly_averages", "coverage_pct", "REAL"); err != nil {
+		return err
+	}
+	if err := db.addColumn("monthly_averages", "longest_gap_seconds", "INTEGER"); err != nil {
+		return err
+	}
+	if err := db.addColumn("monthly_averages", "fill_method", "TEXT NOT NULL DEFAULT 'none'"); err != nil {
+		return err
+	}
+	if err := db.addColumn("monthly_averages", "synthetic_points", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// Link every sample to the run that collected it
+	if err := db.addColumn("bucket_usage", "run_id", "INTEGER REFERENCES collection_runs(id)"); err != nil {
+		return err
+	}
+
+	// Flag samples that were added or edited manually
+	if err := db.addColumn("bucket_usage", "corrected", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// Remember the quota of each bucket for forecasts, zero means no quota
+	if err := db.addColumn("buckets", "quota_size_bytes", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := db.addColumn("buckets", "quota_objects", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+
+	// Remember the placement target of each bucket for placement specific prices
+	if err := db.addColumn("buckets", "placement", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
+	// Track buckets and their events per cluster, so several clusters can be
+	// collected into the same database
+	if err := db.keyBucketsByEndpoint(); err != nil {
+		return err
+	}
+	if err := db.addColumn("bucket_events", "endpoint", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		UPDATE bucket_events
+		SET endpoint = (SELECT endpoint FROM collection_runs WHERE id = bucket_events.run_id)
+		WHERE endpoint = '' AND run_id IS NOT NULL
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Remember the owner and placement of each sample and month, so costs and
+	// statements of past months do not depend on the current bucket metadata
+	for _, table := range []string{"bucket_usage", "monthly_averages"} {
+		if err := db.addColumn(table, "owner", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		if err := db.addColumn(table, "placement", "TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+
+	// Keep the content of every numbered statement, so a statement generated
+	// again can be checked against the one that was issued
+	if err := db.addColumn("statements", "snapshot", "TEXT NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+
 	// Create an index on bucket_name and timestamp for faster queries
 	_, err = db.Exec(`
-		CREATE INDEX IF NOT EXISTS idx_bucket_usage_name_time 
+		CREATE INDEX IF NOT EXISTS idx_bucket_usage_name_time
 		ON bucket_usage(bucket_name, timestamp)
 	`)
+	if err != nil {
+		return err
+	}
+
+	// Create an index on the rollup period for retention and incremental refreshes
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_usage_rollups_resolution_period
+		ON usage_rollups(resolution, period_start)
+	`)
+	if err != nil {
+		return err
+	}
+
+	// Create an index on the run of each sample
+	_, err = db.Exec(`
+		CREATE INDEX IF NOT EXISTS idx_bucket_usage_run
+		ON bucket_usage(run_id)
+	`)
 	return err
 }
 
-// StoreBucketUsage stores the bucket usage data in the database
-func (db *DB) StoreBucketUsage(usage models.BucketUsage) error {
-	_, err := db.Exec(`
-		INSERT INTO bucket_usage (bucket_name, size_bytes, object_count, timestamp)
-		VALUES (?, ?, ?, ?)
-	`, usage.BucketName, usage.SizeBytes, usage.ObjectCount, usage.Timestamp)
+// addColumn adds a column to an existing table unless it already exists.
+// Databases created by older versions are upgraded this way.
+func (db *DB) addColumn(table, column, definition string) error {
+	exists, err := db.hasColumn(table, column)
+	if err != nil || exists {
+		return err
+	}
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
 	return err
 }
 
-// GetBucketUsage retrieves the usage data for a specific bucket
-func (db *DB) GetBucketUsage(bucketName string, startTime, endTime time.Time) ([]models.BucketUsage, error) {
-	rows, err := db.Query(`
-		SELECT id, bucket_name, size_bytes, object_count, timestamp
-		FROM bucket_usage
-		WHERE bucket_name = ? AND timestamp BETWEEN ? AND ?
-		ORDER BY timestamp
-	`, bucketName, startTime, endTime)
+// hasColumn reports whether the table has the given column
+func (db *DB) hasColumn(table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 	defer rows.Close()
 
-	var usages []models.BucketUsage
 	for rows.Next() {
-		var u models.BucketUsage
-		if err := rows.Scan(&u.ID, &u.BucketName, &u.SizeBytes, &u.ObjectCount, &u.Timestamp); err != nil {
-			return nil, err
+		var (
+			cid        int
+			name       string
+			colType    string
+			notNull    bool
+			defaultVal sql.NullString
+			pk         int
+		)
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultVal, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
 		}
-		usages = append(usages, u)
 	}
+	return false, rows.Err()
+}
 
-	if err = rows.Err(); err != nil {
-		return nil, err
+// keyBucketsByEndpoint rebuilds the buckets table of databases created by older
+// versions, which was keyed by the bucket name only. SQLite cannot change the
+// primary key of a table, so the rows are copied to a new table. Each bucket
+// is assigned to the endpoint of the run that collected its latest sample, or
+// to no endpoint if its samples predate the run journal.
+func (db *DB) keyBucketsByEndpoint() error {
+	exists, err := db.hasColumn("buckets", "endpoint")
+	if err != nil || exists {
+		return err
 	}
 
-	return usages, nil
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	statements := []string{`
+		CREATE TABLE buckets_by_endpoint (
+			endpoint TEXT NOT NULL DEFAULT '',
+			name TEXT NOT NULL,
+			first_seen DATETIME NOT NULL,
+			last_seen DATETIME NOT NULL,
+			deleted_at DATETIME,
+			created_at DATETIME,
+			owner TEXT NOT NULL DEFAULT '',
+			quota_size_bytes INTEGER NOT NULL DEFAULT 0,
+			quota_objects INTEGER NOT NULL DEFAULT 0,
+			placement TEXT NOT NULL DEFAULT '',
+			PRIMARY KEY (endpoint, name)
+		)`, `
+		INSERT INTO buckets_by_endpoint (endpoint, name, first_seen, last_seen, deleted_at,
+			created_at, owner, quota_size_bytes, quota_objects, placement)
+		SELECT COALESCE((
+				SELECT r.endpoint
+				FROM bucket_usage u JOIN collection_runs r ON r.id = u.run_id
+				WHERE u.bucket_name = b.name
+				ORDER BY u.timestamp DESC LIMIT 1), ''),
+			name, first_seen, last_seen, deleted_at,
+			created_at, owner, quota_size_bytes, quota_objects, placement
+		FROM buckets b`,
+		`DROP TABLE buckets`,
+		`ALTER TABLE buckets_by_endpoint RENAME TO buckets`,
+	}
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return fmt.Errorf("failed to key buckets by endpoint: %w", err)
+		}
+	}
+
+	return tx.Commit()
 }
 
-// CalculateMonthlyAverages calculates the monthly averages for all buckets
-func (db *DB) CalculateMonthlyAverages(year, month int) error {
-	startDate := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	endDate := startDate.AddDate(0, 1, 0).Add(-time.Second)
+// StoreCollectedUsage stores all samples of a collection run in a single
+// transaction, so either all of them or none are written
+func (db *DB) StoreCollectedUsage(usages []models.BucketUsage) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	// Get all unique bucket names for the given month
-	bucketRows, err := db.Query(`
-		SELECT DISTINCT bucket_name
-		FROM bucket_usage
-		WHERE timestamp BETWEEN ? AND ?
-	`, startDate, endDate)
+	if err := storeUsage(tx, usages); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// StoreCollectionRun stores the outcome of a collection run of endpoint in a
+// single transaction: the lifecycle events of the listed buckets as recorded by
+// RecordBucketSet, the bucket metadata and the samples, whose RunID is set to
+// the run. Either all of them or nothing is written.
+func (db *DB) StoreCollectionRun(runID int64, endpoint string, result *models.CollectionResult, at time.Time) ([]models.BucketEvent, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	events, err := recordBucketSet(tx, runID, endpoint, result.Buckets, at)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record bucket events: %w", err)
+	}
+	if err := updateBucketInfo(tx, endpoint, result.Info); err != nil {
+		return nil, fmt.Errorf("failed to store bucket metadata: %w", err)
+	}
+	for i := range result.Usages {
+		result.Usages[i].RunID = runID
+	}
+	if err := storeUsage(tx, result.Usages); err != nil {
+		return nil, fmt.Errorf("failed to store usage data: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return events, nil
+}
+
+// storeUsage stores samples within tx
+func storeUsage(tx *sql.Tx, usages []models.BucketUsage) error {
+	stmt, err := tx.Prepare(`
+		INSERT INTO bucket_usage (bucket_name, size_bytes, object_count, timestamp, run_id, owner, placement)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`)
 	if err != nil {
 		return err
 	}
-	defer bucketRows.Close()
+	defer stmt.Close()
 
-	var buckets []string
-	for bucketRows.Next() {
-		var bucket string
-		if err := bucketRows.Scan(&bucket); err != nil {
-			return err
+	for _, usage := range usages {
+		var runID interface{}
+		if usage.RunID != 0 {
+			runID = usage.RunID
+		}
+		_, err := stmt.Exec(usage.BucketName, usage.SizeBytes, usage.ObjectCount, usage.Timestamp, runID,
+			usage.Owner, usage.Placement)
+		if err != nil {
+			return fmt.Errorf("failed to store usage data for bucket %s: %w", usage.BucketName, err)
 		}
-		buckets = append(buckets, bucket)
 	}
+	return nil
+}
 
-	// For each bucket, calculate the average
-	for _, bucketName := range buckets {
-		// Calculate averages
-		var avgSize float64
-		var avgCount float64
-		var dataPoints int
-		err := db.QueryRow(`
-			SELECT AVG(size_bytes), AVG(object_count), COUNT(*)
-			FROM bucket_usage
-			WHERE bucket_name = ? AND timestamp BETWEEN ? AND ?
-		`, bucketName, startDate, endDate).Scan(&avgSize, &avgCount, &dataPoints)
+// RestoreBucketUsage re-imports previously archived samples. Samples that already
+// exist for the same bucket and timestamp are skipped. With dryRun set, nothing
+// is written and only the counts are returned.
+func (db *DB) RestoreBucketUsage(usages []models.BucketUsage, dryRun bool) (inserted, duplicates int64, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	seen := make(map[string]bool)
+	for _, usage := range usages {
+		key := usage.BucketName + "\x00" + usage.Timestamp.UTC().Format(time.RFC3339Nano)
+		if seen[key] {
+			duplicates++
+			continue
+		}
+		seen[key] = true
+
+		var exists bool
+		err := tx.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM bucket_usage
+				WHERE bucket_name = ? AND timestamp = ?
+			)
+		`, usage.BucketName, usage.Timestamp.UTC()).Scan(&exists)
 		if err != nil {
-			return err
+			return 0, 0, fmt.Errorf("failed to check for existing sample: %w", err)
+		}
+		if exists {
+			duplicates++
+			continue
 		}
 
-		// Insert or update the monthly average
-		_, err = db.Exec(`
-			INSERT INTO monthly_averages 
-			(bucket_name, year, month, avg_size_bytes, avg_object_count, data_points)
-			VALUES (?, ?, ?, ?, ?, ?)
-			ON CONFLICT(bucket_name, year, month) 
-			DO UPDATE SET 
-				avg_size_bytes = excluded.avg_size_bytes,
-				avg_object_count = excluded.avg_object_count,
-				data_points = excluded.data_points
-		`, bucketName, year, month, avgSize, avgCount, dataPoints)
-		if err != nil {
-			return err
+		if !dryRun {
+			_, err = tx.Exec(`
+				INSERT INTO bucket_usage (bucket_name, size_bytes, object_count, timestamp, owner, placement)
+				VALUES (?, ?, ?, ?, ?, ?)
+			`, usage.BucketName, usage.SizeBytes, usage.ObjectCount, usage.Timestamp.UTC(), usage.Owner, usage.Placement)
+			if err != nil {
+				return 0, 0, fmt.Errorf("failed to insert sample: %w", err)
+			}
 		}
+		inserted++
 	}
 
-	return nil
+	if dryRun {
+		return inserted, duplicates, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return inserted, duplicates, nil
+}
+
+// GetBucketUsage retrieves the usage data for a specific bucket
+func (db *DB) GetBucketUsage(bucketName string, startTime, endTime time.Time) ([]models.BucketUsage, error) {
+	rows, err := db.Query(`
+		SELECT id, bucket_name, size_bytes, object_count, timestamp, corrected
+		FROM bucket_usage
+		WHERE bucket_name = ? AND timestamp BETWEEN ? AND ?
+		ORDER BY timestamp
+	`, bucketName, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usages []models.BucketUsage
+	for rows.Next() {
+		var u models.BucketUsage
+		if err := rows.Scan(&u.ID, &u.BucketName, &u.SizeBytes, &u.ObjectCount, &u.Timestamp, &u.Corrected); err != nil {
+			return nil, err
+		}
+		usages = append(usages, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return usages, nil
 }
 
 // GetMonthlyAverage gets the monthly average for a specific bucket
 func (db *DB) GetMonthlyAverage(bucketName string, year, month int) (*models.MonthlyBucketAverage, error) {
 	var avg models.MonthlyBucketAverage
 	err := db.QueryRow(`
-		SELECT bucket_name, year, month, avg_size_bytes, avg_object_count, data_points
+		SELECT bucket_name, year, month, avg_size_bytes, avg_object_count, data_points,
+			aggregation, coverage_pct, longest_gap_seconds, fill_method, synthetic_points, owner, placement
 		FROM monthly_averages
 		WHERE bucket_name = ? AND year = ? AND month = ?
 	`, bucketName, year, month).Scan(
 		&avg.BucketName, &avg.Year, &avg.Month,
 		&avg.AvgSizeBytes, &avg.AvgObjectCount, &avg.DataPoints,
+		&avg.Aggregation, &avg.CoveragePct, &avg.LongestGapSeconds, &avg.FillMethod, &avg.SyntheticPoints,
+		&avg.Owner, &avg.Placement,
 	)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("no data available for bucket %s in %d-%02d", bucketName, year, month)
@@ -188,12 +636,19 @@ func (db *DB) GetMonthlyAverage(bucketName string, year, month int) (*models.Mon
 
 // GetAllMonthlyAverages gets all monthly averages for a specific month
 func (db *DB) GetAllMonthlyAverages(year, month int) ([]models.MonthlyBucketAverage, error) {
+	return db.GetMonthlyAveragesBetween(year, month, year, month)
+}
+
+// GetMonthlyAveragesBetween gets the monthly averages of all buckets from the
+// first to the last given month, both included, ordered by bucket and month
+func (db *DB) GetMonthlyAveragesBetween(fromYear, fromMonth, toYear, toMonth int) ([]models.MonthlyBucketAverage, error) {
 	rows, err := db.Query(`
-		SELECT bucket_name, year, month, avg_size_bytes, avg_object_count, data_points
+		SELECT bucket_name, year, month, avg_size_bytes, avg_object_count, data_points,
+			aggregation, coverage_pct, longest_gap_seconds, fill_method, synthetic_points, owner, placement
 		FROM monthly_averages
-		WHERE year = ? AND month = ?
-		ORDER BY bucket_name
-	`, year, month)
+		WHERE year * 12 + month BETWEEN ? AND ?
+		ORDER BY bucket_name, year, month
+	`, fromYear*12+fromMonth, toYear*12+toMonth)
 	if err != nil {
 		return nil, err
 	}
@@ -205,6 +660,8 @@ func (db *DB) GetAllMonthlyAverages(year, month int) ([]models.MonthlyBucketAver
 		if err := rows.Scan(
 			&avg.BucketName, &avg.Year, &avg.Month,
 			&avg.AvgSizeBytes, &avg.AvgObjectCount, &avg.DataPoints,
+			&avg.Aggregation, &avg.CoveragePct, &avg.LongestGapSeconds, &avg.FillMethod, &avg.SyntheticPoints,
+			&avg.Owner, &avg.Placement,
 		); err != nil {
 			return nil, err
 		}
@@ -218,31 +675,51 @@ func (db *DB) GetAllMonthlyAverages(year, month int) ([]models.MonthlyBucketAver
 	return averages, nil
 }
 
-// PruneOldData removes individual bucket usage data points from months that have
-// already been aggregated into monthly averages.
-// It keeps data from the current month and any months that don't have averages calculated.
-func (db *DB) PruneOldData() (int64, error) {
+// PruneOptions restricts which data points are removed by PruneOldData
+type PruneOptions struct {
+	// Before limits pruning to months that ended before this time.
+	// The zero value prunes all completed months.
+	Before time.Time
+	// Buckets limits pruning to the given buckets. Empty means all buckets.
+	Buckets []string
+	// Archive is called with all matching data points before any of them are
+	// deleted. If it returns an error, nothing is deleted.
+	Archive func([]models.BucketUsage) error
+}
+
+// bucketFilter returns an SQL condition and its arguments that restrict a query
+// to the given buckets
+func bucketFilter(buckets []string) (string, []interface{}) {
+	if len(buckets) == 0 {
+		return "", nil
+	}
+	args := make([]interface{}, len(buckets))
+	for i, b := range buckets {
+		args[i] = b
+	}
+	return " AND bucket_name IN (?" + strings.Repeat(", ?", len(buckets)-1) + ")", args
+}
+
+// prunableMonths returns the start of each completed month that has monthly
+// averages and matches the prune options
+func prunableMonths(q queryer, opts PruneOptions) ([]time.Time, error) {
 	// Get the current date
 	now := time.Now()
 
 	// Start of the current month
-	currentMonthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
-
-	// Begin a transaction
-	tx, err := db.Begin()
-	if err != nil {
-		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	limit := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if !opts.Before.IsZero() && opts.Before.Before(limit) {
+		limit = opts.Before
 	}
-	defer tx.Rollback() // Rollback if not committed
 
 	// Get a list of months for which we have monthly averages
-	rows, err := tx.Query(`
-		SELECT DISTINCT year, month 
+	rows, err := q.Query(`
+		SELECT DISTINCT year, month
 		FROM monthly_averages
 		ORDER BY year, month
 	`)
 	if err != nil {
-		return 0, fmt.Errorf("failed to query monthly averages: %w", err)
+		return nil, fmt.Errorf("failed to query monthly averages: %w", err)
 	}
 	defer rows.Close()
 
@@ -250,20 +727,80 @@ func (db *DB) PruneOldData() (int64, error) {
 	for rows.Next() {
 		var year, month int
 		if err := rows.Scan(&year, &month); err != nil {
-			return 0, fmt.Errorf("failed to scan monthly average row: %w", err)
+			return nil, fmt.Errorf("failed to scan monthly average row: %w", err)
 		}
 
 		// Convert to time.Time for easier comparison
 		monthStart := time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
 
-		// Only include months that are completed (before the current month)
-		if monthStart.Before(currentMonthStart) {
+		// Only include months that are completed before the limit
+		if !monthStart.AddDate(0, 1, 0).After(limit) {
 			completedMonths = append(completedMonths, monthStart)
 		}
 	}
 
 	if err = rows.Err(); err != nil {
-		return 0, fmt.Errorf("error iterating monthly average rows: %w", err)
+		return nil, fmt.Errorf("error iterating monthly average rows: %w", err)
+	}
+
+	return completedMonths, nil
+}
+
+// PrunePreview returns the number of data points per month and bucket that
+// PruneOldData would remove with the given options
+func (db *DB) PrunePreview(opts PruneOptions) ([]models.PruneCount, error) {
+	months, err := prunableMonths(db, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, filterArgs := bucketFilter(opts.Buckets)
+	var counts []models.PruneCount
+	for _, monthStart := range months {
+		args := append([]interface{}{monthStart, monthStart.AddDate(0, 1, 0)}, filterArgs...)
+		rows, err := db.Query(`
+			SELECT bucket_name, COUNT(*)
+			FROM bucket_usage
+			WHERE timestamp >= ? AND timestamp < ?`+filter+`
+			GROUP BY bucket_name
+			ORDER BY bucket_name
+		`, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count data points for %s: %w",
+				monthStart.Format("2006-01"), err)
+		}
+
+		for rows.Next() {
+			c := models.PruneCount{Year: monthStart.Year(), Month: int(monthStart.Month())}
+			if err := rows.Scan(&c.BucketName, &c.DataPoints); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			counts = append(counts, c)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return counts, nil
+}
+
+// PruneOldData removes individual bucket usage data points and the anomalies
+// found in them from months that have already been aggregated into monthly averages.
+// It keeps data from the current month and any months that don't have averages calculated.
+func (db *DB) PruneOldData(opts PruneOptions) (int64, error) {
+	// Begin a transaction
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback() // Rollback if not committed
+
+	completedMonths, err := prunableMonths(tx, opts)
+	if err != nil {
+		return 0, err
 	}
 
 	if len(completedMonths) == 0 {
@@ -271,17 +808,33 @@ func (db *DB) PruneOldData() (int64, error) {
 		return 0, nil
 	}
 
+	filter, filterArgs := bucketFilter(opts.Buckets)
+	monthArgs := func(monthStart time.Time) []interface{} {
+		return append([]interface{}{monthStart, monthStart.AddDate(0, 1, 0)}, filterArgs...)
+	}
+
+	// Archive the data points before any of them are deleted
+	if opts.Archive != nil {
+		var archived []models.BucketUsage
+		for _, monthStart := range completedMonths {
+			usages, err := queryUsage(tx, "timestamp >= ? AND timestamp < ?"+filter, monthArgs(monthStart)...)
+			if err != nil {
+				return 0, fmt.Errorf("failed to query data points for %s: %w",
+					monthStart.Format("2006-01"), err)
+			}
+			archived = append(archived, usages...)
+		}
+		if err := opts.Archive(archived); err != nil {
+			return 0, fmt.Errorf("failed to archive data points: %w", err)
+		}
+	}
+
 	// For each completed month, delete the individual data points
 	var totalDeleted int64 = 0
 	for _, monthStart := range completedMonths {
-		// End of the month
-		monthEnd := monthStart.AddDate(0, 1, 0).Add(-time.Second)
-
-		// Delete all individual data points for this month
 		result, err := tx.Exec(`
 			DELETE FROM bucket_usage
-			WHERE timestamp >= ? AND timestamp <= ?
-		`, monthStart, monthEnd)
+			WHERE timestamp >= ? AND timestamp < ?`+filter, monthArgs(monthStart)...)
 		if err != nil {
 			return 0, fmt.Errorf("failed to delete data points for %s: %w",
 				monthStart.Format("2006-01"), err)
@@ -293,6 +846,15 @@ func (db *DB) PruneOldData() (int64, error) {
 		}
 
 		totalDeleted += rowsAffected
+
+		// Anomalies refer to the deleted samples
+		_, err = tx.Exec(`
+			DELETE FROM anomalies
+			WHERE timestamp >= ? AND timestamp < ?`+filter, monthArgs(monthStart)...)
+		if err != nil {
+			return 0, fmt.Errorf("failed to delete anomalies for %s: %w",
+				monthStart.Format("2006-01"), err)
+		}
 	}
 
 	// Commit the transaction
@@ -0,0 +1,46 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// newTestDB returns an initialized database in a temporary directory
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+	database, err := NewDB(filepath.Join(t.TempDir(), "s3usage.db"))
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	return database
+}
+
+// sample returns a sample of a bucket with the same size and object count
+func sample(bucket string, at time.Time, size int64) models.BucketUsage {
+	return models.BucketUsage{BucketName: bucket, Timestamp: at, SizeBytes: size, ObjectCount: size}
+}
+
+// storeSamples stores the samples or fails the test
+func storeSamples(t *testing.T, database *DB, usages ...models.BucketUsage) {
+	t.Helper()
+	if err := database.StoreCollectedUsage(usages); err != nil {
+		t.Fatalf("StoreCollectedUsage: %v", err)
+	}
+}
+
+// count returns the result of a COUNT query or fails the test
+func count(t *testing.T, database *DB, query string, args ...interface{}) int {
+	t.Helper()
+	var n int
+	if err := database.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("%s: %v", query, err)
+	}
+	return n
+}
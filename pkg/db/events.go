@@ -0,0 +1,236 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// knownBucket is the state of a bucket as last seen by a collection run
+type knownBucket struct {
+	lastSeen time.Time
+	deleted  bool
+}
+
+// RecordBucketSet compares the buckets listed by a collection run of endpoint
+// with the buckets of that endpoint known from previous runs and records
+// created, deleted and reappeared events. Buckets of other endpoints are not
+// touched, so several clusters can be collected into the same database.
+//
+// The first time an endpoint is recorded, its known buckets are seeded from
+// the samples stored by its runs, so upgrading an existing database does not
+// report every bucket as new. Seeded buckets that are no longer listed are
+// recorded as deleted when they were last seen rather than now, since they
+// disappeared at some point before the lifecycle history was started. Without
+// samples to seed from, e.g. in a new database, the listed buckets are taken as
+// the known ones without recording events, since they were created at some
+// point before the first run rather than at it.
+func (db *DB) RecordBucketSet(runID int64, endpoint string, buckets []string, at time.Time) ([]models.BucketEvent, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	events, err := recordBucketSet(tx, runID, endpoint, buckets, at)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return events, nil
+}
+
+// recordBucketSet records the bucket set of a run within tx, see RecordBucketSet
+func recordBucketSet(tx *sql.Tx, runID int64, endpoint string, buckets []string, at time.Time) ([]models.BucketEvent, error) {
+	at = at.UTC()
+
+	// Buckets and samples of older versions are not linked to an endpoint.
+	// They can only be attributed if no other endpoint was ever collected.
+	var others int
+	err := tx.QueryRow(`SELECT COUNT(*) FROM collection_runs WHERE endpoint != ?`, endpoint).Scan(&others)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count collected endpoints: %w", err)
+	}
+	single := others == 0
+	if single {
+		if _, err := tx.Exec(`UPDATE OR IGNORE buckets SET endpoint = ? WHERE endpoint = ''`, endpoint); err != nil {
+			return nil, fmt.Errorf("failed to assign known buckets: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM buckets WHERE endpoint = ''`); err != nil {
+			return nil, fmt.Errorf("failed to assign known buckets: %w", err)
+		}
+	}
+
+	var count int
+	if err := tx.QueryRow(`SELECT COUNT(*) FROM buckets WHERE endpoint = ?`, endpoint).Scan(&count); err != nil {
+		return nil, fmt.Errorf("failed to count known buckets: %w", err)
+	}
+	seeded := count == 0
+	if seeded {
+		// go-sqlite3 only converts columns declared as DATETIME, so the first
+		// and last sample are looked up by ordering instead of MIN and MAX
+		_, err := tx.Exec(`
+			INSERT INTO buckets (endpoint, name, first_seen, last_seen)
+			SELECT DISTINCT ?, u.bucket_name,
+				(SELECT timestamp FROM bucket_usage f WHERE f.bucket_name = u.bucket_name ORDER BY timestamp LIMIT 1),
+				(SELECT timestamp FROM bucket_usage l WHERE l.bucket_name = u.bucket_name ORDER BY timestamp DESC LIMIT 1)
+			FROM bucket_usage u
+			WHERE u.run_id IN (SELECT id FROM collection_runs WHERE endpoint = ?)
+				OR (? AND u.run_id IS NULL)
+		`, endpoint, endpoint, single)
+		if err != nil {
+			return nil, fmt.Errorf("failed to seed known buckets: %w", err)
+		}
+	}
+
+	rows, err := tx.Query(`SELECT name, last_seen, deleted_at FROM buckets WHERE endpoint = ?`, endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query known buckets: %w", err)
+	}
+	known := make(map[string]knownBucket)
+	for rows.Next() {
+		var name string
+		var b knownBucket
+		var deletedAt sql.NullTime
+		if err := rows.Scan(&name, &b.lastSeen, &deletedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan known bucket: %w", err)
+		}
+		b.deleted = deletedAt.Valid
+		known[name] = b
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating known buckets: %w", err)
+	}
+
+	if seeded && len(known) == 0 {
+		for _, name := range buckets {
+			_, err := tx.Exec(`
+				INSERT INTO buckets (endpoint, name, first_seen, last_seen)
+				VALUES (?, ?, ?, ?)
+			`, endpoint, name, at, at)
+			if err != nil {
+				return nil, fmt.Errorf("failed to seed bucket %s: %w", name, err)
+			}
+			known[name] = knownBucket{lastSeen: at}
+		}
+	}
+
+	var events []models.BucketEvent
+	listed := make(map[string]bool, len(buckets))
+	for _, name := range buckets {
+		listed[name] = true
+		b, ok := known[name]
+		switch {
+		case !ok:
+			_, err = tx.Exec(`
+				INSERT INTO buckets (endpoint, name, first_seen, last_seen)
+				VALUES (?, ?, ?, ?)
+			`, endpoint, name, at, at)
+			events = append(events, models.BucketEvent{BucketName: name, Type: models.EventCreated, Timestamp: at})
+		case b.deleted:
+			_, err = tx.Exec(`
+				UPDATE buckets SET last_seen = ?, deleted_at = NULL WHERE endpoint = ? AND name = ?
+			`, at, endpoint, name)
+			events = append(events, models.BucketEvent{BucketName: name, Type: models.EventReappeared, Timestamp: at})
+		default:
+			_, err = tx.Exec(`UPDATE buckets SET last_seen = ? WHERE endpoint = ? AND name = ?`, at, endpoint, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to update bucket %s: %w", name, err)
+		}
+	}
+
+	for name, b := range known {
+		if listed[name] || b.deleted {
+			continue
+		}
+		deletedAt := at
+		if seeded {
+			deletedAt = b.lastSeen.UTC()
+		}
+		_, err := tx.Exec(`UPDATE buckets SET deleted_at = ? WHERE endpoint = ? AND name = ?`, deletedAt, endpoint, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update bucket %s: %w", name, err)
+		}
+		events = append(events, models.BucketEvent{
+			BucketName: name,
+			Type:       models.EventDeleted,
+			Timestamp:  deletedAt,
+			Details:    "last seen " + b.lastSeen.Format(time.RFC3339),
+		})
+	}
+
+	for i := range events {
+		events[i].Endpoint = endpoint
+		events[i].RunID = runID
+		res, err := tx.Exec(`
+			INSERT INTO bucket_events (endpoint, bucket_name, event_type, timestamp, run_id, details)
+			VALUES (?, ?, ?, ?, ?, ?)
+		`, endpoint, events[i].BucketName, events[i].Type, events[i].Timestamp, runID, events[i].Details)
+		if err != nil {
+			return nil, fmt.Errorf("failed to record event for bucket %s: %w", events[i].BucketName, err)
+		}
+		events[i].ID, _ = res.LastInsertId()
+	}
+	return events, nil
+}
+
+// updateBucketInfo stores the metadata the cluster at endpoint reported for the
+// given buckets within tx
+func updateBucketInfo(tx *sql.Tx, endpoint string, infos []models.BucketInfo) error {
+	for _, info := range infos {
+		var createdAt interface{}
+		if !info.CreatedAt.IsZero() {
+			createdAt = info.CreatedAt.UTC()
+		}
+		_, err := tx.Exec(`
+			UPDATE buckets SET created_at = COALESCE(?, created_at), owner = ?,
+				quota_size_bytes = ?, quota_objects = ?, placement = ?
+			WHERE endpoint = ? AND name = ?
+		`, createdAt, info.Owner, info.QuotaSizeBytes, info.QuotaObjects, info.Placement, endpoint, info.Name)
+		if err != nil {
+			return fmt.Errorf("failed to update bucket %s: %w", info.Name, err)
+		}
+	}
+	return nil
+}
+
+// GetBucketEvents returns the lifecycle events between startTime and endTime,
+// oldest first. An empty bucket name returns the events of all buckets.
+func (db *DB) GetBucketEvents(bucketName string, startTime, endTime time.Time) ([]models.BucketEvent, error) {
+	query := `
+		SELECT id, endpoint, bucket_name, event_type, timestamp, run_id, details
+		FROM bucket_events
+		WHERE timestamp BETWEEN ? AND ?`
+	args := []interface{}{startTime.UTC(), endTime.UTC()}
+	if bucketName != "" {
+		query += ` AND bucket_name = ?`
+		args = append(args, bucketName)
+	}
+	query += ` ORDER BY timestamp, id`
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.BucketEvent
+	for rows.Next() {
+		var e models.BucketEvent
+		var runID sql.NullInt64
+		if err := rows.Scan(&e.ID, &e.Endpoint, &e.BucketName, &e.Type, &e.Timestamp, &runID, &e.Details); err != nil {
+			return nil, err
+		}
+		e.RunID = runID.Int64
+		events = append(events, e)
+	}
+
+	return events, rows.Err()
+}
@@ -0,0 +1,145 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+func TestRecordBucketSetPerEndpoint(t *testing.T) {
+	database := newTestDB(t)
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	if _, err := database.RecordBucketSet(1, "http://a", []string{"logs", "data"}, at); err != nil {
+		t.Fatalf("RecordBucketSet(a): %v", err)
+	}
+	// A different cluster must neither delete nor re-create the buckets of the first
+	if _, err := database.RecordBucketSet(2, "http://b", []string{"media"}, at.Add(time.Hour)); err != nil {
+		t.Fatalf("RecordBucketSet(b): %v", err)
+	}
+	events, err := database.RecordBucketSet(3, "http://b", []string{"media", "backup"}, at.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("RecordBucketSet(b): %v", err)
+	}
+	if len(events) != 1 || events[0].BucketName != "backup" || events[0].Type != models.EventCreated {
+		t.Fatalf("events of b = %+v, want only backup created", events)
+	}
+	events, err = database.RecordBucketSet(4, "http://a", []string{"logs", "data"}, at.Add(3*time.Hour))
+	if err != nil {
+		t.Fatalf("RecordBucketSet(a): %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("events of a = %+v, want none", events)
+	}
+	if n := count(t, database, `SELECT COUNT(*) FROM buckets WHERE deleted_at IS NOT NULL`); n != 0 {
+		t.Errorf("%d buckets deleted, want 0", n)
+	}
+}
+
+func TestRecordBucketSetSeededDeletion(t *testing.T) {
+	database := newTestDB(t)
+	lastSeen := time.Date(2024, 1, 10, 0, 0, 0, 0, time.UTC)
+	storeSamples(t, database,
+		sample("gone", lastSeen.AddDate(0, 0, -1), 100),
+		sample("gone", lastSeen, 100),
+		sample("kept", lastSeen, 100),
+	)
+
+	// Seeded buckets missing from the first listing were deleted when last seen
+	now := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	events, err := database.RecordBucketSet(1, "http://a", []string{"kept"}, now)
+	if err != nil {
+		t.Fatalf("RecordBucketSet: %v", err)
+	}
+	if len(events) != 1 || events[0].BucketName != "gone" || events[0].Type != models.EventDeleted {
+		t.Fatalf("events = %+v, want gone deleted", events)
+	}
+	if !events[0].Timestamp.Equal(lastSeen) {
+		t.Errorf("deletion event at %v, want %v", events[0].Timestamp, lastSeen)
+	}
+	if n := count(t, database, `SELECT COUNT(*) FROM buckets WHERE name = 'gone' AND deleted_at = ?`, lastSeen); n != 1 {
+		t.Errorf("gone not stamped deleted at its last sample")
+	}
+
+	// Buckets that disappear later are deleted at the time of the run
+	later := now.Add(time.Hour)
+	events, err = database.RecordBucketSet(2, "http://a", nil, later)
+	if err != nil {
+		t.Fatalf("RecordBucketSet: %v", err)
+	}
+	if len(events) != 1 || !events[0].Timestamp.Equal(later) {
+		t.Fatalf("events = %+v, want kept deleted at %v", events, later)
+	}
+}
+
+func TestStoreCollectionRun(t *testing.T) {
+	database := newTestDB(t)
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	runID, err := database.StartCollectionRun("http://a", at)
+	if err != nil {
+		t.Fatalf("StartCollectionRun: %v", err)
+	}
+
+	result := &models.CollectionResult{
+		Buckets: []string{"logs"},
+		Usages:  []models.BucketUsage{sample("logs", at, 100)},
+		Info:    []models.BucketInfo{{Name: "logs", Owner: "alice"}},
+	}
+	if _, err := database.StoreCollectionRun(runID, "http://a", result, at); err != nil {
+		t.Fatalf("StoreCollectionRun: %v", err)
+	}
+	if result.Usages[0].RunID != runID {
+		t.Errorf("sample linked to run %d, want %d", result.Usages[0].RunID, runID)
+	}
+	if n := count(t, database, `SELECT COUNT(*) FROM bucket_usage WHERE run_id = ?`, runID); n != 1 {
+		t.Errorf("%d samples of the run stored, want 1", n)
+	}
+	if n := count(t, database, `SELECT COUNT(*) FROM buckets WHERE name = 'logs' AND owner = 'alice'`); n != 1 {
+		t.Errorf("bucket metadata not stored")
+	}
+
+	// A failing sample rolls back the events and metadata of the run as well
+	result = &models.CollectionResult{
+		Buckets: []string{"logs", "media"},
+		Usages:  []models.BucketUsage{sample("media", at.Add(time.Hour), 100)},
+		Info:    []models.BucketInfo{{Name: "logs", Owner: "bob"}},
+	}
+	if _, err := database.Exec(`CREATE TRIGGER fail BEFORE INSERT ON bucket_usage BEGIN SELECT RAISE(ABORT, 'disk full'); END`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := database.StoreCollectionRun(runID+1, "http://a", result, at.Add(time.Hour)); err == nil {
+		t.Fatalf("StoreCollectionRun succeeded despite a failing sample")
+	}
+	if n := count(t, database, `SELECT COUNT(*) FROM bucket_events WHERE bucket_name = 'media'`); n != 0 {
+		t.Errorf("%d events of the failed run stored, want 0", n)
+	}
+	if n := count(t, database, `SELECT COUNT(*) FROM buckets WHERE owner = 'bob'`); n != 0 {
+		t.Errorf("metadata of the failed run stored")
+	}
+}
+
+func TestRecordBucketSetFirstListing(t *testing.T) {
+	database := newTestDB(t)
+	at := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// Buckets that exist before the first run were not created by it
+	events, err := database.RecordBucketSet(1, "http://a", []string{"logs", "data"}, at)
+	if err != nil {
+		t.Fatalf("RecordBucketSet: %v", err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("events of the first run = %+v, want none", events)
+	}
+	if n := count(t, database, `SELECT COUNT(*) FROM buckets WHERE endpoint = 'http://a' AND deleted_at IS NULL`); n != 2 {
+		t.Fatalf("%d buckets known after the first run, want 2", n)
+	}
+
+	events, err = database.RecordBucketSet(2, "http://a", []string{"logs"}, at.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("RecordBucketSet: %v", err)
+	}
+	if len(events) != 1 || events[0].BucketName != "data" || events[0].Type != models.EventDeleted {
+		t.Fatalf("events = %+v, want data deleted", events)
+	}
+}
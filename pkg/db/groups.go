@@ -0,0 +1,157 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// AddGroupMembers adds buckets to a group in the mapping table
+func (db *DB) AddGroupMembers(group string, buckets []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, bucket := range buckets {
+		_, err := tx.Exec(`
+			INSERT OR IGNORE INTO bucket_groups (group_name, bucket_name) VALUES (?, ?)
+		`, group, bucket)
+		if err != nil {
+			return fmt.Errorf("failed to add bucket %s to group %s: %w", bucket, group, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// RemoveGroupMembers removes buckets from a group in the mapping table and
+// returns how many were removed. Without buckets the whole group is removed.
+func (db *DB) RemoveGroupMembers(group string, buckets []string) (int64, error) {
+	query := `DELETE FROM bucket_groups WHERE group_name = ?`
+	args := []interface{}{group}
+	if len(buckets) > 0 {
+		filter, filterArgs := bucketFilter(buckets)
+		query += filter
+		args = append(args, filterArgs...)
+	}
+
+	res, err := db.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to remove buckets from group %s: %w", group, err)
+	}
+	return res.RowsAffected()
+}
+
+// GetGroupMembers returns the buckets of each group in the mapping table
+func (db *DB) GetGroupMembers() (map[string][]string, error) {
+	rows, err := db.Query(`
+		SELECT group_name, bucket_name FROM bucket_groups ORDER BY group_name, bucket_name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := make(map[string][]string)
+	for rows.Next() {
+		var group, bucket string
+		if err := rows.Scan(&group, &bucket); err != nil {
+			return nil, err
+		}
+		members[group] = append(members[group], bucket)
+	}
+
+	return members, rows.Err()
+}
+
+// GetBucketInfos returns the metadata of all buckets seen by collection runs,
+// including deleted ones. Usage is stored by bucket name, so a name used on
+// several clusters is returned once with the metadata of the bucket that
+// currently exists or was seen last.
+func (db *DB) GetBucketInfos() ([]models.BucketInfo, error) {
+	rows, err := db.Query(`
+		SELECT endpoint, name, created_at, owner, quota_size_bytes, quota_objects, placement
+		FROM buckets ORDER BY name, deleted_at IS NULL, last_seen
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var infos []models.BucketInfo
+	for rows.Next() {
+		var info models.BucketInfo
+		var createdAt sql.NullTime
+		if err := rows.Scan(&info.Endpoint, &info.Name, &createdAt, &info.Owner, &info.QuotaSizeBytes, &info.QuotaObjects, &info.Placement); err != nil {
+			return nil, err
+		}
+		info.CreatedAt = createdAt.Time
+		if n := len(infos); n > 0 && infos[n-1].Name == info.Name {
+			infos[n-1] = info
+			continue
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, rows.Err()
+}
+
+// GetGroupHistory sums the usage history of the given buckets into the history
+// of a group. Raw samples of one collection run share their timestamp; samples
+// of older runs are matched to the minute. Each point counts the buckets that
+// have data at that time, since buckets may be missing or only available at a
+// different resolution. If a bucket has several samples within the same
+// minute, only the last one is used. The resolution and aggregation function
+// of the history are applied to each bucket before summing them up.
+func (db *DB) GetGroupHistory(group string, buckets []string, opts models.HistoryOptions) ([]models.GroupUsagePoint, error) {
+	type key struct {
+		timestamp  time.Time
+		resolution string
+	}
+	sums := make(map[key]*models.GroupUsagePoint)
+
+	for _, bucket := range buckets {
+		points, err := db.GetHistory(bucket, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve history of bucket %s: %w", bucket, err)
+		}
+		latest := make(map[key]models.UsagePoint)
+		var keys []key
+		for _, p := range points {
+			k := key{p.Timestamp.UTC().Truncate(time.Minute), p.Resolution}
+			if _, ok := latest[k]; !ok {
+				keys = append(keys, k)
+			}
+			latest[k] = p
+		}
+
+		for _, k := range keys {
+			p := latest[k]
+			sum, ok := sums[k]
+			if !ok {
+				sum = &models.GroupUsagePoint{Group: group, Timestamp: k.timestamp, Resolution: p.Resolution}
+				sums[k] = sum
+			}
+			sum.SizeBytes += p.SizeBytes
+			sum.ObjectCount += p.ObjectCount
+			sum.Buckets++
+		}
+	}
+
+	history := make([]models.GroupUsagePoint, 0, len(sums))
+	for _, sum := range sums {
+		history = append(history, *sum)
+	}
+	sort.Slice(history, func(i, j int) bool {
+		return history[i].Timestamp.Before(history[j].Timestamp)
+	})
+	return history, nil
+}
@@ -0,0 +1,105 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// truncateWeek returns the start of the UTC week containing t, weeks start on Monday
+func truncateWeek(t time.Time) time.Time {
+	day := truncateDay(t)
+	offset := (int(day.Weekday()) + 6) % 7
+	return day.AddDate(0, 0, -offset)
+}
+
+// truncateMonth returns the start of the UTC month containing t
+func truncateMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// resolutionRanks orders the resolutions from fine to coarse
+var resolutionRanks = map[string]int{
+	models.ResolutionRaw:     0,
+	models.ResolutionHourly:  1,
+	models.ResolutionDaily:   2,
+	models.ResolutionWeekly:  3,
+	models.ResolutionMonthly: 4,
+}
+
+// resolutionPeriods returns the start of the period containing a timestamp
+var resolutionPeriods = map[string]func(time.Time) time.Time{
+	models.ResolutionHourly:  truncateHour,
+	models.ResolutionDaily:   truncateDay,
+	models.ResolutionWeekly:  truncateWeek,
+	models.ResolutionMonthly: truncateMonth,
+}
+
+// applyFunction sets the size and object count of the points to the result of
+// the given aggregation function
+func applyFunction(points []models.UsagePoint, function string) error {
+	for i := range points {
+		p := &points[i]
+		switch function {
+		case models.AggregateAvg, "":
+			p.SizeBytes, p.ObjectCount = p.AvgSizeBytes, p.AvgObjectCount
+		case models.AggregateMax:
+			p.SizeBytes, p.ObjectCount = float64(p.MaxSizeBytes), float64(p.MaxObjectCount)
+		case models.AggregateLast:
+			p.SizeBytes, p.ObjectCount = float64(p.LastSizeBytes), float64(p.LastObjectCount)
+		default:
+			return fmt.Errorf("invalid aggregation function %q, use %s, %s or %s",
+				function, models.AggregateAvg, models.AggregateMax, models.AggregateLast)
+		}
+	}
+	return nil
+}
+
+// GetHistory retrieves the usage history of a bucket in the requested resolution.
+// Points of a finer resolution are combined into one point per period, while
+// hourly or daily rollups of periods whose raw samples have been removed are
+// kept as they are if they are coarser than the requested resolution. Without a
+// resolution the best available resolution is used, see GetBucketHistory. The
+// size and object count of each point are set by the aggregation function.
+func (db *DB) GetHistory(bucketName string, opts models.HistoryOptions) ([]models.UsagePoint, error) {
+	rank, ok := resolutionRanks[opts.Resolution]
+	if opts.Resolution != "" && !ok {
+		return nil, fmt.Errorf("invalid resolution %q", opts.Resolution)
+	}
+
+	var points []models.UsagePoint
+	var err error
+	if opts.Resolution == models.ResolutionRaw {
+		points, err = queryRawPoints(db, "bucket_name = ? AND timestamp BETWEEN ? AND ?", bucketName, opts.Start, opts.End)
+	} else {
+		points, err = db.GetBucketHistory(bucketName, opts.Start, opts.End)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if period, ok := resolutionPeriods[opts.Resolution]; ok {
+		// Combine the finer points, rollupPoints expects them ordered by
+		// bucket name and timestamp, which holds for the points of one bucket
+		var finer, coarser []models.UsagePoint
+		for _, p := range points {
+			if resolutionRanks[p.Resolution] < rank {
+				finer = append(finer, p)
+			} else {
+				coarser = append(coarser, p)
+			}
+		}
+		points = append(coarser, rollupPoints(finer, opts.Resolution, period)...)
+		sort.SliceStable(points, func(i, j int) bool {
+			return points[i].Timestamp.Before(points[j].Timestamp)
+		})
+	}
+
+	if err := applyFunction(points, opts.Function); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
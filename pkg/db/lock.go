@@ -0,0 +1,81 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// AcquireLock tries to take the named lock for owner until ttl has passed.
+// A lock held by a different owner is only taken over once it has expired.
+// If the lock is held by someone else, false and the current holder are returned.
+func (db *DB) AcquireLock(name, owner string, ttl time.Duration) (bool, *models.Lock, error) {
+	now := time.Now().UTC()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	holder := models.Lock{Name: name}
+	err = tx.QueryRow(`
+		SELECT owner, acquired_at, expires_at
+		FROM locks
+		WHERE name = ?
+	`, name).Scan(&holder.Owner, &holder.AcquiredAt, &holder.ExpiresAt)
+	if err != nil && err != sql.ErrNoRows {
+		return false, nil, fmt.Errorf("failed to query lock: %w", err)
+	}
+	if err == nil && holder.Owner != owner && holder.ExpiresAt.After(now) {
+		return false, &holder, nil
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO locks (name, owner, acquired_at, expires_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(name)
+		DO UPDATE SET
+			owner = excluded.owner,
+			acquired_at = excluded.acquired_at,
+			expires_at = excluded.expires_at
+	`, name, owner, now, now.Add(ttl))
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to store lock: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return true, nil, nil
+}
+
+// ReleaseLock releases the named lock if it is still held by owner
+func (db *DB) ReleaseLock(name, owner string) error {
+	_, err := db.Exec(`
+		DELETE FROM locks
+		WHERE name = ? AND owner = ?
+	`, name, owner)
+	return err
+}
+
+// RenewLock extends the named lock held by owner until ttl from now. False is
+// returned if owner no longer holds the lock, e.g. because it expired and was
+// taken over by someone else.
+func (db *DB) RenewLock(name, owner string, ttl time.Duration) (bool, error) {
+	result, err := db.Exec(`
+		UPDATE locks
+		SET expires_at = ?
+		WHERE name = ? AND owner = ?
+	`, time.Now().UTC().Add(ttl), name, owner)
+	if err != nil {
+		return false, fmt.Errorf("failed to renew lock: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to renew lock: %w", err)
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRenewLock(t *testing.T) {
+	database := newTestDB(t)
+
+	if ok, _, err := database.AcquireLock("collect", "a", time.Hour); err != nil || !ok {
+		t.Fatalf("AcquireLock(a) = %v, %v", ok, err)
+	}
+	if held, err := database.RenewLock("collect", "a", time.Hour); err != nil || !held {
+		t.Fatalf("RenewLock(a) = %v, %v, want held", held, err)
+	}
+
+	// Once the lock expired and was taken over, renewing it must fail
+	if ok, _, err := database.AcquireLock("collect", "a", -time.Second); err != nil || !ok {
+		t.Fatalf("AcquireLock(a) = %v, %v", ok, err)
+	}
+	if ok, _, err := database.AcquireLock("collect", "b", time.Hour); err != nil || !ok {
+		t.Fatalf("AcquireLock(b) = %v, %v", ok, err)
+	}
+	if held, err := database.RenewLock("collect", "a", time.Hour); err != nil || held {
+		t.Fatalf("RenewLock(a) after takeover = %v, %v, want lost", held, err)
+	}
+	if held, err := database.RenewLock("collect", "b", time.Hour); err != nil || !held {
+		t.Fatalf("RenewLock(b) = %v, %v, want held", held, err)
+	}
+}
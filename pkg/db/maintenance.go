@@ -0,0 +1,196 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// Backup writes a consistent copy of the database to destPath using SQLite's
+// online backup API. It is safe to run while other processes write to the database.
+func (db *DB) Backup(ctx context.Context, destPath string) error {
+	dest, err := sql.Open("sqlite3", destPath)
+	if err != nil {
+		return fmt.Errorf("failed to open backup database: %w", err)
+	}
+	defer dest.Close()
+
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to backup database: %w", err)
+	}
+	defer destConn.Close()
+
+	srcConn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destDriverConn interface{}) error {
+		return srcConn.Raw(func(srcDriverConn interface{}) error {
+			destSQLite, ok := destDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected backup connection type %T", destDriverConn)
+			}
+			srcSQLite, ok := srcDriverConn.(*sqlite3.SQLiteConn)
+			if !ok {
+				return fmt.Errorf("unexpected database connection type %T", srcDriverConn)
+			}
+
+			backup, err := destSQLite.Backup("main", srcSQLite, "main")
+			if err != nil {
+				return fmt.Errorf("failed to start backup: %w", err)
+			}
+
+			// Copy all pages in a single step so the copy reflects one snapshot
+			if _, err := backup.Step(-1); err != nil {
+				backup.Finish()
+				return fmt.Errorf("failed to copy database: %w", err)
+			}
+			if err := backup.Finish(); err != nil {
+				return fmt.Errorf("failed to finish backup: %w", err)
+			}
+			return nil
+		})
+	})
+}
+
+// IntegrityCheck runs SQLite's integrity check and returns the problems it
+// reports. An empty result means the database file is intact.
+func (db *DB) IntegrityCheck() ([]string, error) {
+	rows, err := db.Query(`PRAGMA integrity_check`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return nil, err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+
+	return problems, rows.Err()
+}
+
+// consistencyCheck is an application level check whose query returns one row
+// with a single description column per problem found
+type consistencyCheck struct {
+	name     string
+	severity string
+	query    string
+}
+
+var consistencyChecks = []consistencyCheck{
+	{
+		name:     "duplicate-samples",
+		severity: models.SeverityWarning,
+		query: `
+			SELECT printf('bucket %s has %d samples at %s', bucket_name, COUNT(*), timestamp)
+			FROM bucket_usage
+			GROUP BY bucket_name, timestamp
+			HAVING COUNT(*) > 1
+			ORDER BY bucket_name, timestamp
+		`,
+	},
+	{
+		name:     "negative-values",
+		severity: models.SeverityError,
+		query: `
+			SELECT printf('sample %d of bucket %s at %s has size %d and %d objects',
+				id, bucket_name, timestamp, size_bytes, object_count)
+			FROM bucket_usage
+			WHERE size_bytes < 0 OR object_count < 0
+			UNION ALL
+			SELECT printf('%s rollup of bucket %s at %s has negative values', resolution, bucket_name, period_start)
+			FROM usage_rollups
+			WHERE avg_size_bytes < 0 OR max_size_bytes < 0 OR last_size_bytes < 0
+				OR avg_object_count < 0 OR max_object_count < 0 OR last_object_count < 0
+			UNION ALL
+			SELECT printf('monthly average of bucket %s for %d-%02d has negative values', bucket_name, year, month)
+			FROM monthly_averages
+			WHERE avg_size_bytes < 0 OR avg_object_count < 0
+		`,
+	},
+	{
+		// Pruning and retention remove the data behind old monthly averages
+		// on purpose, so this is only reported for information
+		name:     "aggregates-without-data",
+		severity: models.SeverityInfo,
+		query: `
+			SELECT printf('monthly average of bucket %s for %d-%02d has neither raw samples nor daily rollups',
+				m.bucket_name, m.year, m.month)
+			FROM monthly_averages m
+			WHERE NOT EXISTS (
+				SELECT 1 FROM bucket_usage u
+				WHERE u.bucket_name = m.bucket_name
+				AND CAST(strftime('%Y', u.timestamp) AS INTEGER) = m.year
+				AND CAST(strftime('%m', u.timestamp) AS INTEGER) = m.month
+			) AND NOT EXISTS (
+				SELECT 1 FROM usage_rollups r
+				WHERE r.bucket_name = m.bucket_name AND r.resolution = 'daily'
+				AND CAST(strftime('%Y', r.period_start) AS INTEGER) = m.year
+				AND CAST(strftime('%m', r.period_start) AS INTEGER) = m.month
+			)
+			ORDER BY m.bucket_name, m.year, m.month
+		`,
+	},
+	{
+		name:     "orphaned-rows",
+		severity: models.SeverityWarning,
+		query: `
+			SELECT printf('%d rollups of bucket %s have no raw samples or monthly averages', COUNT(*), r.bucket_name)
+			FROM usage_rollups r
+			WHERE NOT EXISTS (SELECT 1 FROM bucket_usage u WHERE u.bucket_name = r.bucket_name)
+			AND NOT EXISTS (SELECT 1 FROM monthly_averages m WHERE m.bucket_name = r.bucket_name)
+			GROUP BY r.bucket_name
+			UNION ALL
+			SELECT printf('%d samples of bucket %s refer to missing collection run %d', COUNT(*), u.bucket_name, u.run_id)
+			FROM bucket_usage u
+			WHERE u.run_id IS NOT NULL
+			AND NOT EXISTS (SELECT 1 FROM collection_runs c WHERE c.id = u.run_id)
+			GROUP BY u.bucket_name, u.run_id
+			UNION ALL
+			SELECT printf('%d errors refer to missing collection run %d', COUNT(*), e.run_id)
+			FROM collection_run_errors e
+			WHERE NOT EXISTS (SELECT 1 FROM collection_runs c WHERE c.id = e.run_id)
+			GROUP BY e.run_id
+		`,
+	},
+}
+
+// CheckConsistency runs application level consistency checks on the stored
+// usage data and returns the problems found
+func (db *DB) CheckConsistency() ([]models.CheckIssue, error) {
+	var issues []models.CheckIssue
+	for _, check := range consistencyChecks {
+		rows, err := db.Query(check.query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to run check %s: %w", check.name, err)
+		}
+
+		for rows.Next() {
+			issue := models.CheckIssue{Check: check.name, Severity: check.severity}
+			if err := rows.Scan(&issue.Message); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan result of check %s: %w", check.name, err)
+			}
+			issues = append(issues, issue)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("error iterating results of check %s: %w", check.name, err)
+		}
+	}
+
+	return issues, nil
+}
@@ -0,0 +1,390 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// truncateDay returns the start of the UTC day containing t
+func truncateDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// truncateHour returns the start of the UTC hour containing t
+func truncateHour(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Hour)
+}
+
+// rollupPoints combines usage points into one point per bucket and period.
+// The points must be ordered by bucket name and timestamp.
+func rollupPoints(points []models.UsagePoint, resolution string, period func(time.Time) time.Time) []models.UsagePoint {
+	var rollups []models.UsagePoint
+	var sizeSum, countSum float64
+
+	for _, p := range points {
+		start := period(p.Timestamp)
+		n := len(rollups)
+		if n == 0 || rollups[n-1].BucketName != p.BucketName || !rollups[n-1].Timestamp.Equal(start) {
+			if n > 0 {
+				rollups[n-1].AvgSizeBytes = sizeSum / float64(rollups[n-1].DataPoints)
+				rollups[n-1].AvgObjectCount = countSum / float64(rollups[n-1].DataPoints)
+			}
+			rollups = append(rollups, models.UsagePoint{
+				BucketName: p.BucketName,
+				Timestamp:  start,
+				Resolution: resolution,
+			})
+			sizeSum, countSum = 0, 0
+			n++
+		}
+
+		r := &rollups[n-1]
+		sizeSum += p.AvgSizeBytes * float64(p.DataPoints)
+		countSum += p.AvgObjectCount * float64(p.DataPoints)
+		r.DataPoints += p.DataPoints
+		if p.MaxSizeBytes > r.MaxSizeBytes {
+			r.MaxSizeBytes = p.MaxSizeBytes
+		}
+		if p.MaxObjectCount > r.MaxObjectCount {
+			r.MaxObjectCount = p.MaxObjectCount
+		}
+		r.LastSizeBytes = p.LastSizeBytes
+		r.LastObjectCount = p.LastObjectCount
+		r.Corrected = r.Corrected || p.Corrected
+	}
+
+	if n := len(rollups); n > 0 {
+		rollups[n-1].AvgSizeBytes = sizeSum / float64(rollups[n-1].DataPoints)
+		rollups[n-1].AvgObjectCount = countSum / float64(rollups[n-1].DataPoints)
+	}
+
+	return rollups
+}
+
+// rawPoint converts a raw sample into a usage point
+func rawPoint(u models.BucketUsage) models.UsagePoint {
+	return models.UsagePoint{
+		BucketName:      u.BucketName,
+		Timestamp:       u.Timestamp,
+		Resolution:      models.ResolutionRaw,
+		AvgSizeBytes:    float64(u.SizeBytes),
+		MaxSizeBytes:    u.SizeBytes,
+		LastSizeBytes:   u.SizeBytes,
+		AvgObjectCount:  float64(u.ObjectCount),
+		MaxObjectCount:  u.ObjectCount,
+		LastObjectCount: u.ObjectCount,
+		DataPoints:      1,
+		Corrected:       u.Corrected,
+	}
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// queryUsage returns the raw samples matching the given condition ordered by
+// bucket name and timestamp
+func queryUsage(q queryer, where string, args ...interface{}) ([]models.BucketUsage, error) {
+	rows, err := q.Query(`
+		SELECT id, bucket_name, size_bytes, object_count, timestamp, corrected, owner, placement
+		FROM bucket_usage
+		WHERE `+where+`
+		ORDER BY bucket_name, timestamp
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usages []models.BucketUsage
+	for rows.Next() {
+		var u models.BucketUsage
+		if err := rows.Scan(&u.ID, &u.BucketName, &u.SizeBytes, &u.ObjectCount, &u.Timestamp, &u.Corrected,
+			&u.Owner, &u.Placement); err != nil {
+			return nil, err
+		}
+		usages = append(usages, u)
+	}
+
+	return usages, rows.Err()
+}
+
+// queryRawPoints returns the raw samples matching the given condition as usage points
+func queryRawPoints(q queryer, where string, args ...interface{}) ([]models.UsagePoint, error) {
+	usages, err := queryUsage(q, where, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]models.UsagePoint, len(usages))
+	for i, u := range usages {
+		points[i] = rawPoint(u)
+	}
+	return points, nil
+}
+
+// queryRollups returns the rollups of the given resolution matching the given condition
+func queryRollups(q queryer, resolution, where string, args ...interface{}) ([]models.UsagePoint, error) {
+	rows, err := q.Query(`
+		SELECT bucket_name, period_start, avg_size_bytes, max_size_bytes, last_size_bytes,
+			avg_object_count, max_object_count, last_object_count, data_points
+		FROM usage_rollups
+		WHERE resolution = ? AND `+where+`
+		ORDER BY bucket_name, period_start
+	`, append([]interface{}{resolution}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var points []models.UsagePoint
+	for rows.Next() {
+		p := models.UsagePoint{Resolution: resolution}
+		if err := rows.Scan(
+			&p.BucketName, &p.Timestamp, &p.AvgSizeBytes, &p.MaxSizeBytes, &p.LastSizeBytes,
+			&p.AvgObjectCount, &p.MaxObjectCount, &p.LastObjectCount, &p.DataPoints,
+		); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	return points, rows.Err()
+}
+
+// storeRollups inserts or updates the given rollups
+func storeRollups(tx *sql.Tx, rollups []models.UsagePoint) error {
+	stmt, err := tx.Prepare(`
+		INSERT INTO usage_rollups
+		(bucket_name, resolution, period_start, avg_size_bytes, max_size_bytes, last_size_bytes,
+			avg_object_count, max_object_count, last_object_count, data_points)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(bucket_name, resolution, period_start)
+		DO UPDATE SET
+			avg_size_bytes = excluded.avg_size_bytes,
+			max_size_bytes = excluded.max_size_bytes,
+			last_size_bytes = excluded.last_size_bytes,
+			avg_object_count = excluded.avg_object_count,
+			max_object_count = excluded.max_object_count,
+			last_object_count = excluded.last_object_count,
+			data_points = excluded.data_points
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range rollups {
+		_, err := stmt.Exec(
+			r.BucketName, r.Resolution, r.Timestamp, r.AvgSizeBytes, r.MaxSizeBytes, r.LastSizeBytes,
+			r.AvgObjectCount, r.MaxObjectCount, r.LastObjectCount, r.DataPoints,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lastRollup returns the start of the most recent rollup period of the given
+// resolution, or the zero time if there are no rollups yet
+func (db *DB) lastRollup(resolution string) (time.Time, error) {
+	var last time.Time
+	err := db.QueryRow(`
+		SELECT period_start
+		FROM usage_rollups
+		WHERE resolution = ?
+		ORDER BY period_start DESC
+		LIMIT 1
+	`, resolution).Scan(&last)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	return last, err
+}
+
+// RefreshRollups recalculates the hourly rollups for all raw samples recorded
+// since the given time and the daily rollups from those hourly rollups.
+// A zero time rebuilds the rollups from all raw samples in the database.
+func (db *DB) RefreshRollups(since time.Time) (hourly, daily int64, err error) {
+	hourStart := truncateHour(since)
+	dayStart := truncateDay(since)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	raw, err := queryRawPoints(tx, "timestamp >= ?", hourStart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query raw samples: %w", err)
+	}
+	hourlyRollups := rollupPoints(raw, models.ResolutionHourly, truncateHour)
+	if err := storeRollups(tx, hourlyRollups); err != nil {
+		return 0, 0, fmt.Errorf("failed to store hourly rollups: %w", err)
+	}
+
+	hours, err := queryRollups(tx, models.ResolutionHourly, "period_start >= ?", dayStart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query hourly rollups: %w", err)
+	}
+	dailyRollups := rollupPoints(hours, models.ResolutionDaily, truncateDay)
+	if err := storeRollups(tx, dailyRollups); err != nil {
+		return 0, 0, fmt.Errorf("failed to store daily rollups: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return int64(len(hourlyRollups)), int64(len(dailyRollups)), nil
+}
+
+// ApplyRetention deletes raw samples and rollups that are older than the
+// retention policy allows. Months that lose raw samples but have no monthly
+// average yet are aggregated with the given options before their samples are deleted.
+func (db *DB) ApplyRetention(policy models.RetentionPolicy, agg models.AggregationOptions, now time.Time) (models.CompactionResult, error) {
+	var result models.CompactionResult
+
+	if policy.Raw > 0 {
+		cutoff := truncateDay(now.Add(-policy.Raw))
+
+		// Make sure no bucket loses the raw samples of a month before that
+		// month has been aggregated for the bucket
+		rows, err := db.Query(`
+			SELECT DISTINCT CAST(strftime('%Y', timestamp) AS INTEGER), CAST(strftime('%m', timestamp) AS INTEGER)
+			FROM bucket_usage
+			WHERE timestamp < ? AND NOT EXISTS (
+				SELECT 1 FROM monthly_averages
+				WHERE bucket_name = bucket_usage.bucket_name
+				AND year = CAST(strftime('%Y', bucket_usage.timestamp) AS INTEGER)
+				AND month = CAST(strftime('%m', bucket_usage.timestamp) AS INTEGER)
+			)
+		`, cutoff)
+		if err != nil {
+			return result, fmt.Errorf("failed to query unaggregated months: %w", err)
+		}
+		var months [][2]int
+		for rows.Next() {
+			var year, month int
+			if err := rows.Scan(&year, &month); err != nil {
+				rows.Close()
+				return result, fmt.Errorf("failed to scan unaggregated month: %w", err)
+			}
+			months = append(months, [2]int{year, month})
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return result, fmt.Errorf("error iterating unaggregated months: %w", err)
+		}
+
+		for _, m := range months {
+			if err := db.CalculateMonthlyAverages(m[0], m[1], agg); err != nil {
+				return result, fmt.Errorf("failed to calculate monthly averages for %d-%02d: %w", m[0], m[1], err)
+			}
+		}
+		result.MonthsAveraged = len(months)
+
+		res, err := db.Exec(`DELETE FROM bucket_usage WHERE timestamp < ?`, cutoff)
+		if err != nil {
+			return result, fmt.Errorf("failed to delete raw samples: %w", err)
+		}
+		if result.RawDeleted, err = res.RowsAffected(); err != nil {
+			return result, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+	}
+
+	tiers := []struct {
+		resolution string
+		keep       time.Duration
+		deleted    *int64
+	}{
+		{models.ResolutionHourly, policy.Hourly, &result.HourlyDeleted},
+		{models.ResolutionDaily, policy.Daily, &result.DailyDeleted},
+	}
+	for _, tier := range tiers {
+		if tier.keep <= 0 {
+			continue
+		}
+		res, err := db.Exec(`
+			DELETE FROM usage_rollups
+			WHERE resolution = ? AND period_start < ?
+		`, tier.resolution, truncateDay(now.Add(-tier.keep)))
+		if err != nil {
+			return result, fmt.Errorf("failed to delete %s rollups: %w", tier.resolution, err)
+		}
+		if *tier.deleted, err = res.RowsAffected(); err != nil {
+			return result, fmt.Errorf("failed to get rows affected: %w", err)
+		}
+	}
+
+	return result, nil
+}
+
+// Compact brings the rollups up to date and then applies the retention policy.
+// If rebuild is set, all rollups are recalculated from the available raw samples
+// instead of only those since the last compaction.
+func (db *DB) Compact(policy models.RetentionPolicy, agg models.AggregationOptions, now time.Time, rebuild bool) (models.CompactionResult, error) {
+	var since time.Time
+	if !rebuild {
+		var err error
+		since, err = db.lastRollup(models.ResolutionHourly)
+		if err != nil {
+			return models.CompactionResult{}, fmt.Errorf("failed to query last rollup: %w", err)
+		}
+	}
+
+	hourly, daily, err := db.RefreshRollups(since)
+	if err != nil {
+		return models.CompactionResult{}, err
+	}
+
+	result, err := db.ApplyRetention(policy, agg, now)
+	result.HourlyRollups = hourly
+	result.DailyRollups = daily
+	return result, err
+}
+
+// GetBucketHistory retrieves the usage history of a bucket in the best available
+// resolution. Raw samples are used where they exist, hourly rollups cover the
+// time before the oldest raw sample and daily rollups the time before that.
+func (db *DB) GetBucketHistory(bucketName string, startTime, endTime time.Time) ([]models.UsagePoint, error) {
+	points, err := queryRawPoints(db, "bucket_name = ? AND timestamp BETWEEN ? AND ?", bucketName, startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := endTime
+	if len(points) > 0 {
+		cutoff = points[0].Timestamp
+	}
+	cutoff = truncateHour(cutoff)
+	hourly, err := queryRollups(db, models.ResolutionHourly,
+		"bucket_name = ? AND period_start >= ? AND period_start < ?", bucketName, truncateHour(startTime), cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(hourly) > 0 {
+		cutoff = hourly[0].Timestamp
+	}
+	cutoff = truncateDay(cutoff)
+	daily, err := queryRollups(db, models.ResolutionDaily,
+		"bucket_name = ? AND period_start >= ? AND period_start < ?", bucketName, truncateDay(startTime), cutoff)
+	if err != nil {
+		return nil, err
+	}
+
+	points = append(append(daily, hourly...), points...)
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Timestamp.Before(points[j].Timestamp)
+	})
+	return points, nil
+}
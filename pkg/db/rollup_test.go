@@ -0,0 +1,124 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+func TestRollupPoints(t *testing.T) {
+	base := time.Date(2025, 3, 1, 10, 0, 0, 0, time.UTC)
+	points := []models.UsagePoint{
+		rawPoint(sample("a", base.Add(10*time.Minute), 100)),
+		rawPoint(sample("a", base.Add(40*time.Minute), 300)),
+		rawPoint(sample("a", base.Add(70*time.Minute), 50)),
+		rawPoint(sample("b", base.Add(20*time.Minute), 10)),
+	}
+
+	rollups := rollupPoints(points, models.ResolutionHourly, truncateHour)
+	if len(rollups) != 3 {
+		t.Fatalf("got %d rollups, want 3", len(rollups))
+	}
+
+	first := rollups[0]
+	if first.BucketName != "a" || !first.Timestamp.Equal(base) {
+		t.Errorf("first rollup is %s at %s, want a at %s", first.BucketName, first.Timestamp, base)
+	}
+	if first.AvgSizeBytes != 200 || first.MaxSizeBytes != 300 || first.LastSizeBytes != 300 || first.DataPoints != 2 {
+		t.Errorf("first rollup avg %v max %d last %d points %d, want 200 300 300 2",
+			first.AvgSizeBytes, first.MaxSizeBytes, first.LastSizeBytes, first.DataPoints)
+	}
+	if rollups[1].LastSizeBytes != 50 || rollups[2].BucketName != "b" {
+		t.Errorf("unexpected rollups %+v", rollups[1:])
+	}
+}
+
+func TestRefreshRollups(t *testing.T) {
+	database := newTestDB(t)
+	day := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	storeSamples(t, database,
+		sample("a", day.Add(1*time.Hour), 100),
+		sample("a", day.Add(1*time.Hour+30*time.Minute), 200),
+		sample("a", day.Add(5*time.Hour), 300),
+		sample("a", day.Add(25*time.Hour), 400),
+	)
+
+	hourly, daily, err := database.RefreshRollups(time.Time{})
+	if err != nil {
+		t.Fatalf("RefreshRollups: %v", err)
+	}
+	if hourly != 3 || daily != 2 {
+		t.Fatalf("got %d hourly and %d daily rollups, want 3 and 2", hourly, daily)
+	}
+
+	days, err := queryRollups(database, models.ResolutionDaily, "bucket_name = ?", "a")
+	if err != nil {
+		t.Fatalf("queryRollups: %v", err)
+	}
+	if days[0].AvgSizeBytes != 200 || days[0].DataPoints != 3 || days[0].LastSizeBytes != 300 {
+		t.Errorf("first day avg %v points %d last %d, want 200 3 300",
+			days[0].AvgSizeBytes, days[0].DataPoints, days[0].LastSizeBytes)
+	}
+}
+
+func TestApplyRetentionAggregatesEveryBucket(t *testing.T) {
+	database := newTestDB(t)
+	old := time.Date(2025, 1, 10, 12, 0, 0, 0, time.UTC)
+	storeSamples(t, database, sample("a", old, 100), sample("b", old, 200))
+
+	// Only bucket a has been aggregated
+	_, err := database.Exec(`
+		INSERT INTO monthly_averages (bucket_name, year, month, avg_size_bytes, avg_object_count, data_points)
+		VALUES ('a', 2025, 1, 100, 100, 1)
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2025, 6, 1, 0, 0, 0, 0, time.UTC)
+	result, err := database.ApplyRetention(models.RetentionPolicy{Raw: 30 * 24 * time.Hour}, models.AggregationOptions{}, now)
+	if err != nil {
+		t.Fatalf("ApplyRetention: %v", err)
+	}
+	if result.RawDeleted != 2 || result.MonthsAveraged != 1 {
+		t.Errorf("deleted %d samples and averaged %d months, want 2 and 1", result.RawDeleted, result.MonthsAveraged)
+	}
+
+	avg, err := database.GetMonthlyAverage("b", 2025, 1)
+	if err != nil {
+		t.Fatalf("bucket b was not aggregated before its samples were deleted: %v", err)
+	}
+	if avg.AvgSizeBytes != 200 {
+		t.Errorf("average of bucket b is %v, want 200", avg.AvgSizeBytes)
+	}
+}
+
+func TestApplyRetentionKeepsRecentData(t *testing.T) {
+	database := newTestDB(t)
+	now := time.Date(2025, 6, 15, 0, 0, 0, 0, time.UTC)
+	storeSamples(t, database,
+		sample("a", now.AddDate(0, 0, -100), 100),
+		sample("a", now.AddDate(0, 0, -40), 200),
+		sample("a", now.AddDate(0, 0, -1), 300),
+	)
+	if _, _, err := database.RefreshRollups(time.Time{}); err != nil {
+		t.Fatalf("RefreshRollups: %v", err)
+	}
+
+	policy := models.RetentionPolicy{Raw: 7 * 24 * time.Hour, Hourly: 60 * 24 * time.Hour, Daily: 365 * 24 * time.Hour}
+	result, err := database.ApplyRetention(policy, models.AggregationOptions{}, now)
+	if err != nil {
+		t.Fatalf("ApplyRetention: %v", err)
+	}
+	if result.RawDeleted != 2 || result.HourlyDeleted != 1 || result.DailyDeleted != 0 {
+		t.Errorf("deleted %d raw, %d hourly and %d daily, want 2, 1 and 0",
+			result.RawDeleted, result.HourlyDeleted, result.DailyDeleted)
+	}
+	if n := count(t, database, `SELECT COUNT(*) FROM bucket_usage`); n != 1 {
+		t.Errorf("%d raw samples left, want 1", n)
+	}
+	if n := count(t, database, `SELECT COUNT(*) FROM usage_rollups WHERE resolution = 'daily'`); n != 3 {
+		t.Errorf("%d daily rollups left, want 3", n)
+	}
+}
@@ -0,0 +1,147 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// StartCollectionRun records the start of a collection run and returns its ID
+func (db *DB) StartCollectionRun(endpoint string, startedAt time.Time) (int64, error) {
+	result, err := db.Exec(`
+		INSERT INTO collection_runs (endpoint, started_at, status)
+		VALUES (?, ?, ?)
+	`, endpoint, startedAt.UTC(), models.RunStatusRunning)
+	if err != nil {
+		return 0, fmt.Errorf("failed to record collection run: %w", err)
+	}
+	return result.LastInsertId()
+}
+
+// FinishCollectionRun records the outcome of a collection run including the
+// errors of all buckets that could not be collected or stored
+func (db *DB) FinishCollectionRun(run models.CollectionRun) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		UPDATE collection_runs
+		SET finished_at = ?, status = ?, buckets_total = ?, buckets_succeeded = ?,
+			buckets_failed = ?, error = ?
+		WHERE id = ?
+	`, run.FinishedAt.UTC(), run.Status, run.BucketsTotal, run.BucketsSucceeded,
+		run.BucketsFailed, run.Error, run.ID)
+	if err != nil {
+		return fmt.Errorf("failed to update collection run: %w", err)
+	}
+
+	for _, e := range run.Errors {
+		_, err = tx.Exec(`
+			INSERT INTO collection_run_errors (run_id, bucket_name, message)
+			VALUES (?, ?, ?)
+		`, run.ID, e.BucketName, e.Message)
+		if err != nil {
+			return fmt.Errorf("failed to record collection error: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// scanCollectionRun scans a row of the collection_runs table
+func scanCollectionRun(scan func(dest ...interface{}) error) (models.CollectionRun, error) {
+	var run models.CollectionRun
+	var finishedAt sql.NullTime
+	err := scan(
+		&run.ID, &run.Endpoint, &run.StartedAt, &finishedAt, &run.Status,
+		&run.BucketsTotal, &run.BucketsSucceeded, &run.BucketsFailed, &run.Error,
+	)
+	if finishedAt.Valid {
+		run.FinishedAt = finishedAt.Time
+	}
+	return run, err
+}
+
+// GetCollectionRuns returns the most recent collection runs, newest first
+func (db *DB) GetCollectionRuns(limit int) ([]models.CollectionRun, error) {
+	rows, err := db.Query(`
+		SELECT id, endpoint, started_at, finished_at, status,
+			buckets_total, buckets_succeeded, buckets_failed, error
+		FROM collection_runs
+		ORDER BY id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []models.CollectionRun
+	for rows.Next() {
+		run, err := scanCollectionRun(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run)
+	}
+
+	return runs, rows.Err()
+}
+
+// GetCollectionRun returns a single collection run with its bucket errors and
+// the number of samples it stored
+func (db *DB) GetCollectionRun(id int64) (*models.CollectionRun, error) {
+	run, err := scanCollectionRun(db.QueryRow(`
+		SELECT id, endpoint, started_at, finished_at, status,
+			buckets_total, buckets_succeeded, buckets_failed, error
+		FROM collection_runs
+		WHERE id = ?
+	`, id).Scan)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no collection run with ID %d", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.QueryRow(`
+		SELECT COUNT(*)
+		FROM bucket_usage
+		WHERE run_id = ?
+	`, id).Scan(&run.Samples)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT bucket_name, message
+		FROM collection_run_errors
+		WHERE run_id = ?
+		ORDER BY id
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e models.BucketError
+		if err := rows.Scan(&e.BucketName, &e.Message); err != nil {
+			return nil, err
+		}
+		run.Errors = append(run.Errors, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
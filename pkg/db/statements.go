@@ -0,0 +1,61 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// AssignStatementNumber returns the number of the statement of an owner for a
+// month. The first statement gets the next number in sequence and its snapshot
+// is stored with it. Statements that are generated again keep their number and
+// the returned record holds the snapshot stored first, for the caller to
+// compare; statements numbered without a snapshot get the given one.
+func (db *DB) AssignStatementNumber(owner string, year, month int, at time.Time, snapshot string) (models.StatementRecord, error) {
+	record := models.StatementRecord{Owner: owner, Year: year, Month: month}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return record, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`
+		SELECT number, created_at, snapshot FROM statements
+		WHERE owner = ? AND year = ? AND month = ?
+	`, owner, year, month).Scan(&record.Number, &record.CreatedAt, &record.Snapshot)
+	if err == nil {
+		if record.Snapshot != "" {
+			return record, nil
+		}
+		record.Snapshot = snapshot
+		if _, err := tx.Exec(`UPDATE statements SET snapshot = ? WHERE number = ?`, snapshot, record.Number); err != nil {
+			return record, fmt.Errorf("failed to record statement snapshot: %w", err)
+		}
+		return record, tx.Commit()
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return record, err
+	}
+
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(number), 0) + 1 FROM statements`).Scan(&record.Number); err != nil {
+		return record, fmt.Errorf("failed to find next statement number: %w", err)
+	}
+	record.CreatedAt = at.UTC()
+	record.Snapshot = snapshot
+	_, err = tx.Exec(`
+		INSERT INTO statements (number, owner, year, month, created_at, snapshot)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, record.Number, owner, year, month, record.CreatedAt, snapshot)
+	if err != nil {
+		return record, fmt.Errorf("failed to record statement: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return record, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return record, nil
+}
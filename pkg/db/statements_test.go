@@ -0,0 +1,56 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAssignStatementNumberKeepsSnapshot(t *testing.T) {
+	db := newTestDB(t)
+	at := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	first, err := db.AssignStatementNumber("alice", 2025, 2, at, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Number != 1 || first.Snapshot != "v1" {
+		t.Fatalf("first statement = %+v, want number 1 with snapshot v1", first)
+	}
+
+	// Generating it again keeps the number and returns the issued snapshot
+	again, err := db.AssignStatementNumber("alice", 2025, 2, at.Add(time.Hour), "v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Number != 1 || again.Snapshot != "v1" || !again.CreatedAt.Equal(at) {
+		t.Fatalf("regenerated statement = %+v, want number 1 with snapshot v1", again)
+	}
+
+	other, err := db.AssignStatementNumber("bob", 2025, 2, at, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Number != 2 {
+		t.Fatalf("statement of another owner got number %d, want 2", other.Number)
+	}
+}
+
+func TestAssignStatementNumberFillsMissingSnapshot(t *testing.T) {
+	db := newTestDB(t)
+	at := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	// Statements numbered by older versions have no snapshot
+	if _, err := db.Exec(`INSERT INTO statements (number, owner, year, month, created_at) VALUES (1, 'alice', 2025, 2, ?)`, at); err != nil {
+		t.Fatal(err)
+	}
+	record, err := db.AssignStatementNumber("alice", 2025, 2, at, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.Number != 1 || record.Snapshot != "v1" {
+		t.Fatalf("statement = %+v, want number 1 with snapshot v1", record)
+	}
+	if n := count(t, db, `SELECT COUNT(*) FROM statements WHERE snapshot = 'v1'`); n != 1 {
+		t.Errorf("%d statements with the snapshot stored, want 1", n)
+	}
+}
@@ -0,0 +1,136 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// GetBucketsWithData returns the names of the buckets with raw samples or
+// rollups in the given period
+func (db *DB) GetBucketsWithData(startTime, endTime time.Time) ([]string, error) {
+	rows, err := db.Query(`
+		SELECT bucket_name FROM bucket_usage WHERE timestamp BETWEEN ? AND ?
+		UNION
+		SELECT bucket_name FROM usage_rollups WHERE period_start BETWEEN ? AND ?
+		ORDER BY bucket_name
+	`, startTime, endTime, truncateDay(startTime), endTime)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buckets []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		buckets = append(buckets, name)
+	}
+	return buckets, rows.Err()
+}
+
+// usageChange returns the change between the first and the last point of a
+// history. For rollups the last value within the period is used.
+func usageChange(points []models.UsagePoint) models.UsageChange {
+	first, last := points[0], points[len(points)-1]
+	c := models.UsageChange{
+		BucketName:        first.BucketName,
+		StartTime:         first.Timestamp,
+		EndTime:           last.Timestamp,
+		StartSizeBytes:    first.LastSizeBytes,
+		EndSizeBytes:      last.LastSizeBytes,
+		SizeChangeBytes:   last.LastSizeBytes - first.LastSizeBytes,
+		StartObjectCount:  first.LastObjectCount,
+		EndObjectCount:    last.LastObjectCount,
+		ObjectCountChange: last.LastObjectCount - first.LastObjectCount,
+	}
+	if days := last.Timestamp.Sub(first.Timestamp).Hours() / 24; days > 0 {
+		c.BytesPerDay = float64(c.SizeChangeBytes) / days
+		c.ObjectsPerDay = float64(c.ObjectCountChange) / days
+	}
+	return c
+}
+
+// deletedBuckets returns the names of the buckets recorded as deleted on every
+// cluster they were seen on
+func (db *DB) deletedBuckets() (map[string]bool, error) {
+	rows, err := db.Query(`
+		SELECT name FROM buckets
+		GROUP BY name
+		HAVING COUNT(deleted_at) = COUNT(*)
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deleted := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		deleted[name] = true
+	}
+	return deleted, rows.Err()
+}
+
+// TopBuckets ranks the buckets by their growth per day within the given
+// period, or by their size or object count at its end. The growth per day is
+// taken between each bucket's first and last value in the period, so buckets
+// created during the period are not ranked below older ones. The values are
+// taken from the best available resolution, see GetBucketHistory. Deleted
+// buckets are left out. A limit of zero returns all buckets.
+func (db *DB) TopBuckets(startTime, endTime time.Time, by string, limit int) ([]models.UsageChange, error) {
+	var less func(a, b models.UsageChange) bool
+	switch by {
+	case models.RankGrowth:
+		less = func(a, b models.UsageChange) bool {
+			if a.BytesPerDay != b.BytesPerDay {
+				return a.BytesPerDay > b.BytesPerDay
+			}
+			return a.SizeChangeBytes > b.SizeChangeBytes
+		}
+	case models.RankSize:
+		less = func(a, b models.UsageChange) bool { return a.EndSizeBytes > b.EndSizeBytes }
+	case models.RankObjects:
+		less = func(a, b models.UsageChange) bool { return a.EndObjectCount > b.EndObjectCount }
+	default:
+		return nil, fmt.Errorf("invalid ranking %q", by)
+	}
+
+	buckets, err := db.GetBucketsWithData(startTime, endTime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find buckets: %w", err)
+	}
+	deleted, err := db.deletedBuckets()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find deleted buckets: %w", err)
+	}
+
+	var changes []models.UsageChange
+	for _, bucket := range buckets {
+		if deleted[bucket] {
+			continue
+		}
+		points, err := db.GetBucketHistory(bucket, startTime, endTime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve history of bucket %s: %w", bucket, err)
+		}
+		if len(points) > 0 {
+			changes = append(changes, usageChange(points))
+		}
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return less(changes[i], changes[j])
+	})
+	if limit > 0 && len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
@@ -0,0 +1,41 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+func TestTopBucketsByGrowth(t *testing.T) {
+	database := newTestDB(t)
+	end := time.Date(2025, 3, 31, 0, 0, 0, 0, time.UTC)
+	start := end.AddDate(0, 0, -30)
+
+	storeSamples(t, database,
+		// old grows by 3000 bytes in 30 days, 100 per day
+		sample("old", start.Add(time.Hour), 1000),
+		sample("old", end.Add(-time.Hour), 4000),
+		// new grows by 1000 bytes in 2 days, 500 per day
+		sample("new", end.AddDate(0, 0, -2).Add(-time.Hour), 0),
+		sample("new", end.Add(-time.Hour), 1000),
+		// gone grew fastest but has been deleted
+		sample("gone", start.Add(time.Hour), 0),
+		sample("gone", start.Add(25*time.Hour), 100000),
+	)
+	if _, err := database.RecordBucketSet(1, "http://a", []string{"old", "new"}, end); err != nil {
+		t.Fatalf("RecordBucketSet: %v", err)
+	}
+
+	changes, err := database.TopBuckets(start, end, models.RankGrowth, 0)
+	if err != nil {
+		t.Fatalf("TopBuckets: %v", err)
+	}
+	var got []string
+	for _, c := range changes {
+		got = append(got, c.BucketName)
+	}
+	if len(got) != 2 || got[0] != "new" || got[1] != "old" {
+		t.Errorf("ranking = %v, want [new old]", got)
+	}
+}
@@ -0,0 +1,204 @@
+// Package forecast fits trends to the usage history of buckets and projects
+// them into the future
+package forecast
+
+import (
+	"errors"
+	"math"
+	"time"
+)
+
+// ErrTooFewPoints is returned if there are not enough points to fit a trend
+var ErrTooFewPoints = errors.New("at least three points at different times are required")
+
+// minSeasonalDays is the time the points must cover for a weekly seasonality
+// to be fitted, so every weekday is seen at least twice
+const minSeasonalDays = 14
+
+// day is the unit of the regression
+const day = 24 * time.Hour
+
+// Point is a value at a point in time
+type Point struct {
+	Time  time.Time
+	Value float64
+}
+
+// Projection is the value of a model at a point in time with the bounds of its
+// prediction interval
+type Projection struct {
+	Time  time.Time
+	Value float64
+	Lower float64
+	Upper float64
+}
+
+// Model is a linear trend, optionally with a weekly seasonality, fitted to
+// points by least squares
+type Model struct {
+	// Intercept is the value at the origin and Slope the change per day
+	Intercept float64
+	Slope     float64
+	// Seasonal is the deviation from the trend for each weekday, zero without
+	// seasonality
+	Seasonal [7]float64
+	// Residual is the standard deviation of the residuals
+	Residual float64
+	// Points is the number of points the model was fitted to
+	Points int
+
+	origin time.Time
+	meanX  float64
+	sxx    float64
+}
+
+// days returns the time since the origin of the model in days
+func (m *Model) days(t time.Time) float64 {
+	return float64(t.Sub(m.origin)) / float64(day)
+}
+
+// Fit fits a linear trend to the points. With weekly set, a weekly seasonality
+// is fitted as well if the points span at least two weeks and leave at least
+// one degree of freedom for the residuals; the deviations of each weekday from
+// the trend are averaged, removed from the points and the trend is fitted
+// again.
+func Fit(points []Point, weekly bool) (*Model, error) {
+	if len(points) < 3 {
+		return nil, ErrTooFewPoints
+	}
+
+	m := &Model{Points: len(points), origin: points[0].Time}
+	for _, p := range points {
+		if p.Time.Before(m.origin) {
+			m.origin = p.Time
+		}
+	}
+
+	xs := make([]float64, len(points))
+	ys := make([]float64, len(points))
+	var last float64
+	for i, p := range points {
+		xs[i] = m.days(p.Time)
+		ys[i] = p.Value
+		last = math.Max(last, xs[i])
+	}
+	if err := m.fitLine(xs, ys); err != nil {
+		return nil, err
+	}
+
+	// The trend uses two degrees of freedom
+	params := 2
+	var sums [7]float64
+	var counts [7]int
+	var seen int
+	if weekly && last >= minSeasonalDays {
+		for i, p := range points {
+			wd := p.Time.UTC().Weekday()
+			sums[wd] += ys[i] - (m.Intercept + m.Slope*xs[i])
+			if counts[wd] == 0 {
+				seen++
+			}
+			counts[wd]++
+		}
+	}
+	// Centered offsets of the weekdays seen use one degree of freedom each
+	// but one
+	if seen > 1 && len(points) > params+seen-1 {
+		params += seen - 1
+
+		// Center the deviations so they do not shift the trend
+		var mean float64
+		for wd := range sums {
+			if counts[wd] > 0 {
+				m.Seasonal[wd] = sums[wd] / float64(counts[wd])
+				mean += m.Seasonal[wd]
+			}
+		}
+		for wd := range m.Seasonal {
+			if counts[wd] > 0 {
+				m.Seasonal[wd] -= mean / float64(seen)
+			}
+		}
+
+		adjusted := make([]float64, len(ys))
+		for i, p := range points {
+			adjusted[i] = ys[i] - m.Seasonal[p.Time.UTC().Weekday()]
+		}
+		if err := m.fitLine(xs, adjusted); err != nil {
+			return nil, err
+		}
+		ys = adjusted
+	}
+
+	// Standard deviation of the residuals, less the degrees of freedom used
+	// by the trend and the seasonality
+	var ss float64
+	for i := range xs {
+		r := ys[i] - (m.Intercept + m.Slope*xs[i])
+		ss += r * r
+	}
+	m.Residual = math.Sqrt(ss / float64(len(xs)-params))
+
+	return m, nil
+}
+
+// fitLine sets the intercept and slope of the model to the least squares line
+// through the points
+func (m *Model) fitLine(xs, ys []float64) error {
+	n := float64(len(xs))
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	m.meanX = sumX / n
+	meanY := sumY / n
+
+	var sxy float64
+	m.sxx = 0
+	for i := range xs {
+		dx := xs[i] - m.meanX
+		m.sxx += dx * dx
+		sxy += dx * (ys[i] - meanY)
+	}
+	if m.sxx == 0 {
+		return ErrTooFewPoints
+	}
+
+	m.Slope = sxy / m.sxx
+	m.Intercept = meanY - m.Slope*m.meanX
+	return nil
+}
+
+// Trend returns the value of the trend at t, without the seasonality
+func (m *Model) Trend(t time.Time) float64 {
+	return m.Intercept + m.Slope*m.days(t)
+}
+
+// Project returns the value of the model at t together with the bounds of the
+// prediction interval for the given z score, e.g. 1.96 for 95% confidence. The
+// interval widens with the distance from the fitted points.
+func (m *Model) Project(t time.Time, z float64) Projection {
+	x := m.days(t)
+	value := m.Trend(t) + m.Seasonal[t.UTC().Weekday()]
+	n := float64(m.Points)
+	se := m.Residual * math.Sqrt(1+1/n+(x-m.meanX)*(x-m.meanX)/m.sxx)
+	return Projection{Time: t, Value: value, Lower: value - z*se, Upper: value + z*se}
+}
+
+// Reaches returns the first day from start until end at which the projected
+// value, or with upper set the upper bound of the prediction interval, reaches
+// the limit. The zero time is returned if the limit is not reached.
+func (m *Model) Reaches(limit float64, start, end time.Time, z float64, upper bool) time.Time {
+	for t := start; !t.After(end); t = t.Add(day) {
+		p := m.Project(t, z)
+		value := p.Value
+		if upper {
+			value = p.Upper
+		}
+		if value >= limit {
+			return t
+		}
+	}
+	return time.Time{}
+}
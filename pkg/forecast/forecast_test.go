@@ -0,0 +1,217 @@
+package forecast
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+// origin is a Monday
+var origin = time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC)
+
+// daily returns a point per day for the given number of days
+func daily(days int, value func(i int, t time.Time) float64) []Point {
+	points := make([]Point, days)
+	for i := range points {
+		t := origin.Add(time.Duration(i) * day)
+		points[i] = Point{Time: t, Value: value(i, t)}
+	}
+	return points
+}
+
+// weekend is a weekly pattern that is higher on weekends
+func weekend(t time.Time) float64 {
+	if wd := t.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return 50
+	}
+	return -20
+}
+
+// noise is a deterministic deviation alternating in sign and size
+func noise(i int) float64 {
+	return float64((i*7)%5-2) * 3
+}
+
+func near(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestFit(t *testing.T) {
+	// Four points on four weekdays leave no degree of freedom for the offsets
+	sparse := []Point{
+		{origin, 1000},
+		{origin.Add(8 * day), 1040 + 10},
+		{origin.Add(16 * day), 1080 - 10},
+		{origin.Add(24 * day), 1120 + 10},
+	}
+
+	tests := []struct {
+		name      string
+		points    []Point
+		weekly    bool
+		slope     float64
+		intercept float64
+		seasonal  bool
+		params    int
+	}{
+		{
+			name:      "linear",
+			points:    daily(10, func(i int, _ time.Time) float64 { return 100 + 10*float64(i) + noise(i) }),
+			slope:     10,
+			intercept: 100,
+			params:    2,
+		},
+		{
+			name:      "weekly pattern without seasonality",
+			points:    daily(28, func(i int, t time.Time) float64 { return 1000 + 5*float64(i) + weekend(t) }),
+			slope:     5,
+			intercept: 1000,
+			params:    2,
+		},
+		{
+			name:      "weekly seasonality",
+			points:    daily(28, func(i int, t time.Time) float64 { return 1000 + 5*float64(i) + weekend(t) + noise(i) }),
+			weekly:    true,
+			slope:     5,
+			intercept: 1000,
+			seasonal:  true,
+			params:    8,
+		},
+		{
+			name:      "seasonality needs two weeks",
+			points:    daily(10, func(i int, _ time.Time) float64 { return 1000 + 5*float64(i) + noise(i) }),
+			weekly:    true,
+			slope:     5,
+			intercept: 1000,
+			params:    2,
+		},
+		{
+			name:      "seasonality needs enough points",
+			points:    sparse,
+			weekly:    true,
+			slope:     5,
+			intercept: 1000,
+			params:    2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := Fit(tt.points, tt.weekly)
+			if err != nil {
+				t.Fatalf("Fit: %v", err)
+			}
+			if !near(m.Slope, tt.slope, 1) || !near(m.Intercept, tt.intercept, 30) {
+				t.Errorf("trend %v + %v per day, want %v + %v per day", m.Intercept, m.Slope, tt.intercept, tt.slope)
+			}
+			if got := m.Seasonal != [7]float64{}; got != tt.seasonal {
+				t.Errorf("seasonality fitted = %v, want %v", got, tt.seasonal)
+			}
+			if tt.seasonal {
+				// The offsets are centered and follow the weekly pattern
+				var sum float64
+				for _, s := range m.Seasonal {
+					sum += s
+				}
+				if !near(sum, 0, 1e-6) || m.Seasonal[time.Saturday] <= m.Seasonal[time.Wednesday] {
+					t.Errorf("seasonal offsets %v, want centered and higher on weekends", m.Seasonal)
+				}
+			}
+
+			// The residual is corrected for the parameters that were fitted
+			var ss float64
+			for _, p := range tt.points {
+				r := p.Value - m.Trend(p.Time) - m.Seasonal[p.Time.Weekday()]
+				ss += r * r
+			}
+			want := math.Sqrt(ss / float64(len(tt.points)-tt.params))
+			if !near(m.Residual, want, 1e-6) {
+				t.Errorf("residual %v, want %v", m.Residual, want)
+			}
+		})
+	}
+}
+
+func TestFitTooFewPoints(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []Point
+	}{
+		{"none", nil},
+		{"two points", daily(2, func(i int, _ time.Time) float64 { return float64(i) })},
+		{"same time", []Point{{origin, 1}, {origin, 2}, {origin, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, weekly := range []bool{false, true} {
+				if _, err := Fit(tt.points, weekly); !errors.Is(err, ErrTooFewPoints) {
+					t.Errorf("Fit(weekly=%v) = %v, want ErrTooFewPoints", weekly, err)
+				}
+			}
+		})
+	}
+}
+
+func TestProject(t *testing.T) {
+	m, err := Fit(daily(10, func(i int, _ time.Time) float64 { return 100 + 10*float64(i) + noise(i) }), false)
+	if err != nil {
+		t.Fatalf("Fit: %v", err)
+	}
+
+	near1 := m.Project(origin.Add(10*day), 1.96)
+	far := m.Project(origin.Add(100*day), 1.96)
+	for _, p := range []Projection{near1, far} {
+		if !near(p.Value, m.Trend(p.Time), 1e-9) {
+			t.Errorf("projected value %v, want the trend %v", p.Value, m.Trend(p.Time))
+		}
+		if !(p.Lower < p.Value && p.Value < p.Upper) || !near(p.Value-p.Lower, p.Upper-p.Value, 1e-9) {
+			t.Errorf("interval [%v, %v] not symmetric around %v", p.Lower, p.Upper, p.Value)
+		}
+	}
+	if far.Upper-far.Lower <= near1.Upper-near1.Lower {
+		t.Errorf("interval does not widen with the distance: %v at 10 days, %v at 100 days",
+			near1.Upper-near1.Lower, far.Upper-far.Lower)
+	}
+	if p := m.Project(origin.Add(10*day), 0); p.Lower != p.Value || p.Upper != p.Value {
+		t.Errorf("interval with z 0 = [%v, %v], want %v", p.Lower, p.Upper, p.Value)
+	}
+}
+
+func TestReaches(t *testing.T) {
+	// Exactly 100 + 10 per day, so the limit is reached on a known day
+	m, err := Fit(daily(10, func(i int, _ time.Time) float64 { return 100 + 10*float64(i) }), false)
+	if err != nil {
+		t.Fatalf("Fit: %v", err)
+	}
+	start, end := origin.Add(10*day), origin.Add(60*day)
+
+	tests := []struct {
+		name  string
+		limit float64
+		upper bool
+		want  time.Time
+	}{
+		{"reached", 300, false, origin.Add(20 * day)},
+		{"already reached", 150, false, start},
+		{"not reached", 1000, false, time.Time{}},
+		{"upper bound", 300, true, origin.Add(20 * day)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.Reaches(tt.limit, start, end, 1.96, tt.upper); !got.Equal(tt.want) {
+				t.Errorf("Reaches(%v) = %v, want %v", tt.limit, got, tt.want)
+			}
+		})
+	}
+
+	// With noise the upper bound reaches the limit before the value
+	m, err = Fit(daily(10, func(i int, _ time.Time) float64 { return 100 + 10*float64(i) + noise(i) }), false)
+	if err != nil {
+		t.Fatalf("Fit: %v", err)
+	}
+	value := m.Reaches(500, start, end, 1.96, false)
+	upper := m.Reaches(500, start, end, 1.96, true)
+	if value.IsZero() || upper.IsZero() || !upper.Before(value) {
+		t.Errorf("upper bound reaches the limit on %v, value on %v; want the upper bound first", upper, value)
+	}
+}
@@ -6,28 +6,598 @@ import (
 
 // BucketUsage represents the disk usage for a single bucket at a specific point in time
 type BucketUsage struct {
-	ID        int64     `json:"id"`
+	ID          int64     `json:"id"`
+	BucketName  string    `json:"bucket_name"`
+	SizeBytes   int64     `json:"size_bytes"`
+	ObjectCount int64     `json:"object_count"`
+	Timestamp   time.Time `json:"timestamp"`
+	RunID       int64     `json:"run_id,omitempty"`
+	// Corrected is set for samples that were added or edited manually
+	Corrected bool `json:"corrected,omitempty"`
+	// Owner and Placement are the owner and placement target of the bucket
+	// when the sample was collected, empty if unknown
+	Owner     string `json:"owner,omitempty"`
+	Placement string `json:"placement,omitempty"`
+}
+
+// BucketError represents a failure to collect or store the usage of a single bucket
+type BucketError struct {
+	BucketName string `json:"bucket_name"`
+	Message    string `json:"message"`
+}
+
+// BucketInfo holds metadata of a bucket as reported by the cluster
+type BucketInfo struct {
+	// Endpoint is the cluster the bucket belongs to
+	Endpoint  string    `json:"endpoint"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Owner     string    `json:"owner"`
+	// Quota limits of the bucket, zero if the bucket has no quota
+	QuotaSizeBytes int64 `json:"quota_size_bytes"`
+	QuotaObjects   int64 `json:"quota_objects"`
+	// Placement is the placement target the bucket's data is stored in
+	Placement string `json:"placement"`
+}
+
+// CollectionResult holds the outcome of collecting the usage of all buckets
+type CollectionResult struct {
+	Buckets  []string      `json:"buckets"`
+	Usages   []BucketUsage `json:"usages"`
+	Failures []BucketError `json:"failures"`
+	Info     []BucketInfo  `json:"info"`
+	// Excluded lists the buckets that were not collected because of the bucket filter
+	Excluded []string `json:"excluded"`
+}
+
+// Statuses of a collection run
+const (
+	RunStatusRunning = "running"
+	RunStatusSuccess = "success"
+	RunStatusPartial = "partial"
+	RunStatusFailed  = "failed"
+	// RunStatusSkipped is only reported in summaries of runs that did not start
+	// because another collection was running
+	RunStatusSkipped = "skipped"
+)
+
+// CollectionRun represents a single execution of the collect command
+type CollectionRun struct {
+	ID               int64         `json:"id"`
+	Endpoint         string        `json:"endpoint"`
+	StartedAt        time.Time     `json:"started_at"`
+	FinishedAt       time.Time     `json:"finished_at"`
+	Status           string        `json:"status"`
+	BucketsTotal     int           `json:"buckets_total"`
+	BucketsSucceeded int           `json:"buckets_succeeded"`
+	BucketsFailed    int           `json:"buckets_failed"`
+	Error            string        `json:"error,omitempty"`
+	Errors           []BucketError `json:"errors,omitempty"`
+	Samples          int           `json:"samples"`
+}
+
+// CollectionSummary is the machine-readable summary of a collect run
+type CollectionSummary struct {
+	RunID            int64         `json:"run_id"`
+	Endpoint         string        `json:"endpoint"`
+	Status           string        `json:"status"`
+	ExitCode         int           `json:"exit_code"`
+	Error            string        `json:"error,omitempty"`
+	StartedAt        time.Time     `json:"started_at"`
+	FinishedAt       time.Time     `json:"finished_at"`
+	DurationSeconds  float64       `json:"duration_seconds"`
+	BucketsTotal     int           `json:"buckets_total"`
+	BucketsSucceeded int           `json:"buckets_succeeded"`
+	BucketsFailed    int           `json:"buckets_failed"`
+	Failures         []BucketError `json:"failures"`
+	TotalSizeBytes   int64         `json:"total_size_bytes"`
+	TotalObjects     int64         `json:"total_objects"`
+	// Anomalies is the number of anomalies detected in the stored samples
+	Anomalies int `json:"anomalies"`
+}
+
+// Types of bucket lifecycle events
+const (
+	EventCreated    = "created"
+	EventDeleted    = "deleted"
+	EventReappeared = "reappeared"
+)
+
+// BucketEvent represents a change in the set of buckets detected by a collection run
+type BucketEvent struct {
+	ID int64 `json:"id"`
+	// Endpoint is the cluster the bucket belongs to
+	Endpoint   string    `json:"endpoint"`
 	BucketName string    `json:"bucket_name"`
-	SizeBytes  int64     `json:"size_bytes"`
-	ObjectCount int64    `json:"object_count"`
+	Type       string    `json:"type"`
 	Timestamp  time.Time `json:"timestamp"`
+	RunID      int64     `json:"run_id,omitempty"`
+	Details    string    `json:"details,omitempty"`
 }
 
 // MonthlyBucketAverage represents the average disk usage for a bucket over a month
 type MonthlyBucketAverage struct {
-	BucketName   string  `json:"bucket_name"`
-	Year         int     `json:"year"`
-	Month        int     `json:"month"`
-	AvgSizeBytes float64 `json:"avg_size_bytes"`
+	BucketName     string  `json:"bucket_name"`
+	Year           int     `json:"year"`
+	Month          int     `json:"month"`
+	AvgSizeBytes   float64 `json:"avg_size_bytes"`
+	AvgObjectCount float64 `json:"avg_object_count"`
+	DataPoints     int     `json:"data_points"`
+	// Aggregation is the mode the average was calculated with
+	Aggregation string `json:"aggregation"`
+	// CoveragePct is the percentage of the month covered by samples and
+	// LongestGapSeconds the longest time without a sample. Both are nil for
+	// averages calculated by older versions.
+	CoveragePct       *float64 `json:"coverage_pct"`
+	LongestGapSeconds *int64   `json:"longest_gap_seconds"`
+	// FillMethod is how gaps were filled and SyntheticPoints the number of
+	// points added to the samples when calculating the average
+	FillMethod      string `json:"fill_method"`
+	SyntheticPoints int    `json:"synthetic_points"`
+	// Owner and Placement are those of the bucket's last sample in the month
+	// that recorded them, empty for averages of older versions
+	Owner     string `json:"owner"`
+	Placement string `json:"placement"`
+}
+
+// States of a bucket in a comparison of two months
+const (
+	// ComparisonExisting buckets have an average in both months
+	ComparisonExisting = "existing"
+	// ComparisonNew buckets only have an average in the later month
+	ComparisonNew = "new"
+	// ComparisonVanished buckets only have an average in the earlier month
+	ComparisonVanished = "vanished"
+)
+
+// MonthComparison compares the monthly averages of a bucket in two months.
+// The values of a month without an average are nil and count as zero for the
+// change. Percentages are nil if the earlier value is missing or zero.
+type MonthComparison struct {
+	BucketName           string   `json:"bucket_name"`
+	Status               string   `json:"status"`
+	FromSizeBytes        *float64 `json:"from_size_bytes"`
+	ToSizeBytes          *float64 `json:"to_size_bytes"`
+	SizeChangeBytes      float64  `json:"size_change_bytes"`
+	SizeChangePct        *float64 `json:"size_change_pct"`
+	FromObjectCount      *float64 `json:"from_object_count"`
+	ToObjectCount        *float64 `json:"to_object_count"`
+	ObjectCountChange    float64  `json:"object_count_change"`
+	ObjectCountChangePct *float64 `json:"object_count_change_pct"`
+}
+
+// Rankings of buckets by the top command
+const (
+	// RankGrowth ranks by the increase of the size
+	RankGrowth = "growth"
+	// RankSize ranks by the size at the end of the window
+	RankSize = "size"
+	// RankObjects ranks by the object count at the end of the window
+	RankObjects = "objects"
+)
+
+// UsageChange is the change of a bucket's usage within a window, from its first
+// to its last sample or rollup
+type UsageChange struct {
+	BucketName        string    `json:"bucket_name"`
+	StartTime         time.Time `json:"start_time"`
+	EndTime           time.Time `json:"end_time"`
+	StartSizeBytes    int64     `json:"start_size_bytes"`
+	EndSizeBytes      int64     `json:"end_size_bytes"`
+	SizeChangeBytes   int64     `json:"size_change_bytes"`
+	StartObjectCount  int64     `json:"start_object_count"`
+	EndObjectCount    int64     `json:"end_object_count"`
+	ObjectCountChange int64     `json:"object_count_change"`
+	// Growth per day between the first and the last value, zero if the
+	// bucket has only one value in the window
+	BytesPerDay   float64 `json:"bytes_per_day"`
+	ObjectsPerDay float64 `json:"objects_per_day"`
+}
+
+// Trend models fitted by forecasts
+const (
+	ForecastLinear = "linear"
+	// ForecastWeekly is a linear trend with a weekly seasonality
+	ForecastWeekly = "linear+weekly"
+)
+
+// Sources of the limit a forecast is checked against
+const (
+	LimitQuota     = "quota"
+	LimitThreshold = "threshold"
+)
+
+// Forecast is the projected size of a bucket, or of all buckets if Total is set
+type Forecast struct {
+	BucketName string `json:"bucket_name"`
+	Total      bool   `json:"total"`
+	// Model is the fitted trend and Samples the number of daily values it
+	// was fitted to
+	Model             string  `json:"model"`
+	Samples           int     `json:"samples"`
+	CurrentSizeBytes  float64 `json:"current_size_bytes"`
+	GrowthBytesPerDay float64 `json:"growth_bytes_per_day"`
+	// Projected size at ForecastTime with the bounds of the prediction interval
+	ForecastTime      time.Time `json:"forecast_time"`
+	ForecastSizeBytes float64   `json:"forecast_size_bytes"`
+	LowerSizeBytes    float64   `json:"lower_size_bytes"`
+	UpperSizeBytes    float64   `json:"upper_size_bytes"`
+	ConfidencePct     float64   `json:"confidence_pct"`
+	// Limit is the quota or threshold the projection is checked against, zero
+	// if there is none
+	LimitBytes  int64  `json:"limit_bytes"`
+	LimitSource string `json:"limit_source"`
+	// ReachesLimit is the day the projected size reaches the limit and
+	// EarliestReachesLimit the day the upper bound reaches it, nil if the
+	// limit is not reached within the forecast horizon
+	ReachesLimit         *time.Time `json:"reaches_limit"`
+	EarliestReachesLimit *time.Time `json:"earliest_reaches_limit"`
+}
+
+// Rules detecting anomalies in new samples
+const (
+	// AnomalyChange flags a large relative change of the size since the
+	// previous sample
+	AnomalyChange = "change"
+	// AnomalyZScore flags a size far from the mean of the recent history,
+	// measured in standard deviations
+	AnomalyZScore = "zscore"
+	// AnomalyDivergence flags a size and object count that changed by very
+	// different amounts, e.g. data disappearing while all objects remain
+	AnomalyDivergence = "divergence"
+)
+
+// AnomalyRules holds the thresholds of the anomaly rules, a threshold of zero
+// disables its rule
+type AnomalyRules struct {
+	// MaxChangePct is the change of the size since the previous sample in
+	// percent at which a sample is flagged
+	MaxChangePct float64
+	// ZScore is the distance from the mean of the recent history in standard
+	// deviations at which a sample is flagged
+	ZScore float64
+	// DivergencePct is the difference between the changes of size and object
+	// count in percentage points at which a sample is flagged
+	DivergencePct float64
+	// Window is the recent history samples are compared with, and MinSamples
+	// the number of values it needs for the z-score rule
+	Window     time.Duration
+	MinSamples int
+	// Buckets smaller than MinSizeBytes before and after a sample are not checked
+	MinSizeBytes int64
+}
+
+// Anomaly is a sample that broke an anomaly rule when it was collected
+type Anomaly struct {
+	ID         int64     `json:"id"`
+	BucketName string    `json:"bucket_name"`
+	RunID      int64     `json:"run_id"`
+	Timestamp  time.Time `json:"timestamp"`
+	Rule       string    `json:"rule"`
+	// Value is the measured change, z-score or divergence and Threshold the
+	// value of the rule it exceeded
+	Value               float64 `json:"value"`
+	Threshold           float64 `json:"threshold"`
+	PreviousSizeBytes   int64   `json:"previous_size_bytes"`
+	SizeBytes           int64   `json:"size_bytes"`
+	PreviousObjectCount int64   `json:"previous_object_count"`
+	ObjectCount         int64   `json:"object_count"`
+	Message             string  `json:"message"`
+}
+
+// CostItem is the cost of a bucket in a month
+type CostItem struct {
+	BucketName string `json:"bucket_name"`
+	Year       int    `json:"year"`
+	Month      int    `json:"month"`
+	// Owner is the owner whose buckets share the tiers, empty if unknown
+	Owner     string `json:"owner"`
+	Placement string `json:"placement"`
+	// Rates names the rates that were applied, e.g. "default" or "group acme"
+	Rates          string  `json:"rates"`
+	AvgSizeBytes   float64 `json:"avg_size_bytes"`
 	AvgObjectCount float64 `json:"avg_object_count"`
-	DataPoints   int     `json:"data_points"`
+	StorageCost    float64 `json:"storage_cost"`
+	ObjectCost     float64 `json:"object_cost"`
+	// MinimumCharge is the amount added to reach the minimum charge
+	MinimumCharge float64 `json:"minimum_charge"`
+	Total         float64 `json:"total"`
+	Currency      string  `json:"currency"`
+}
+
+// StatementRecord is the number assigned to the statement of an owner for a month
+type StatementRecord struct {
+	Number    int64     `json:"number"`
+	Owner     string    `json:"owner"`
+	Year      int       `json:"year"`
+	Month     int       `json:"month"`
+	CreatedAt time.Time `json:"created_at"`
+	// Snapshot is the content of the statement as issued, empty for
+	// statements numbered by older versions
+	Snapshot string `json:"snapshot"`
+}
+
+// Gap is a period in which samples of a bucket are missing. Start and End are
+// the surrounding samples or the boundaries of the examined period.
+type Gap struct {
+	BucketName string    `json:"bucket_name"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end"`
+}
+
+// Modes for calculating monthly averages
+const (
+	// AggregationSamples averages over the samples that exist in the month
+	AggregationSamples = "samples"
+	// AggregationProrated counts the time of the month before the bucket was
+	// created and after it was deleted as zero usage
+	AggregationProrated = "prorated"
+)
+
+// AggregationOptions controls how monthly averages are calculated
+type AggregationOptions struct {
+	Mode string
+	// Interval is the expected time between two samples, used to detect gaps
+	Interval time.Duration
+	// Fill is how gaps longer than FillThreshold are filled, see FillNone,
+	// FillLinear and FillCarry
+	Fill          string
+	FillThreshold time.Duration
+}
+
+// Methods for filling gaps in the samples when calculating monthly averages
+const (
+	FillNone   = "none"
+	FillLinear = "linear"
+	FillCarry  = "carry"
+)
+
+// Resolutions of the usage data kept in the database
+const (
+	ResolutionRaw    = "raw"
+	ResolutionHourly = "hourly"
+	ResolutionDaily  = "daily"
+	// Weekly and monthly points are only calculated for the history, weeks
+	// start on Monday
+	ResolutionWeekly  = "weekly"
+	ResolutionMonthly = "monthly"
+)
+
+// Functions combining the samples within a period of the history
+const (
+	AggregateAvg  = "avg"
+	AggregateMax  = "max"
+	AggregateLast = "last"
+)
+
+// HistoryOptions selects the time range, resolution and aggregation function
+// of a usage history
+type HistoryOptions struct {
+	Start time.Time
+	End   time.Time
+	// Resolution of the points, empty for the best available resolution
+	Resolution string
+	// Function is the aggregation function whose result is reported as the
+	// size and object count of each point, avg by default
+	Function string
+}
+
+// UsagePoint represents a bucket's usage at a point in time, either a raw sample
+// or a rollup of all samples within an hour or a day
+type UsagePoint struct {
+	BucketName      string    `json:"bucket_name"`
+	Timestamp       time.Time `json:"timestamp"`
+	Resolution      string    `json:"resolution"`
+	AvgSizeBytes    float64   `json:"avg_size_bytes"`
+	MaxSizeBytes    int64     `json:"max_size_bytes"`
+	LastSizeBytes   int64     `json:"last_size_bytes"`
+	AvgObjectCount  float64   `json:"avg_object_count"`
+	MaxObjectCount  int64     `json:"max_object_count"`
+	LastObjectCount int64     `json:"last_object_count"`
+	DataPoints      int       `json:"data_points"`
+	Corrected       bool      `json:"corrected,omitempty"`
+	// SizeBytes and ObjectCount are the result of the aggregation function
+	// selected for the history
+	SizeBytes   float64 `json:"size_bytes"`
+	ObjectCount float64 `json:"object_count"`
+}
+
+// Kinds of manual corrections of samples
+const (
+	CorrectionAdd    = "add"
+	CorrectionEdit   = "edit"
+	CorrectionDelete = "delete"
+)
+
+// Correction records a manual change of a sample. The old values are empty for
+// added samples and the new values are empty for deleted samples.
+type Correction struct {
+	ID             int64     `json:"id"`
+	SampleID       int64     `json:"sample_id"`
+	BucketName     string    `json:"bucket_name"`
+	Action         string    `json:"action"`
+	Timestamp      time.Time `json:"timestamp"`
+	OldSizeBytes   *int64    `json:"old_size_bytes"`
+	OldObjectCount *int64    `json:"old_object_count"`
+	NewSizeBytes   *int64    `json:"new_size_bytes"`
+	NewObjectCount *int64    `json:"new_object_count"`
+	Reason         string    `json:"reason"`
+	Author         string    `json:"author"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// Annotation is a note attached to a point in time, e.g. a migration of a
+// customer. Annotations without a bucket name apply to all buckets.
+type Annotation struct {
+	ID         int64     `json:"id"`
+	BucketName string    `json:"bucket_name,omitempty"`
+	Timestamp  time.Time `json:"timestamp"`
+	Text       string    `json:"text"`
+	Author     string    `json:"author"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// RetentionPolicy defines how long each resolution of usage data is kept.
+// A zero duration keeps the data forever.
+type RetentionPolicy struct {
+	Raw    time.Duration `json:"raw"`
+	Hourly time.Duration `json:"hourly"`
+	Daily  time.Duration `json:"daily"`
+}
+
+// CompactionResult summarizes the work done by a compaction run
+type CompactionResult struct {
+	HourlyRollups  int64 `json:"hourly_rollups"`
+	DailyRollups   int64 `json:"daily_rollups"`
+	RawDeleted     int64 `json:"raw_deleted"`
+	HourlyDeleted  int64 `json:"hourly_deleted"`
+	DailyDeleted   int64 `json:"daily_deleted"`
+	MonthsAveraged int   `json:"months_averaged"`
 }
 
 // Config represents the application configuration
 type Config struct {
-	S3Endpoint  string `json:"s3_endpoint"`
-	S3AccessKey string `json:"s3_access_key"`
-	S3SecretKey string `json:"s3_secret_key"`
-	S3Region    string `json:"s3_region"`
-	DBPath      string `json:"db_path"`
-} 
\ No newline at end of file
+	S3Endpoint  string          `json:"s3_endpoint" yaml:"s3_endpoint"`
+	S3AccessKey string          `json:"s3_access_key" yaml:"s3_access_key"`
+	S3SecretKey string          `json:"s3_secret_key" yaml:"s3_secret_key"`
+	S3Region    string          `json:"s3_region" yaml:"s3_region"`
+	DBPath      string          `json:"db_path" yaml:"db_path"`
+	Retention   RetentionConfig `json:"retention" yaml:"retention"`
+	LockTimeout string          `json:"lock_timeout" yaml:"lock_timeout"`
+	// OnPartialFailure selects what happens to a collection run in which some
+	// buckets failed, see PartialFailureCommit and PartialFailureAbort
+	OnPartialFailure string `json:"on_partial_failure" yaml:"on_partial_failure"`
+	// Aggregation selects how monthly averages are calculated, see
+	// AggregationSamples and AggregationProrated
+	Aggregation string `json:"aggregation" yaml:"aggregation"`
+	// CollectionInterval is how often collect is run, e.g. 24h or 1h
+	CollectionInterval string `json:"collection_interval" yaml:"collection_interval"`
+	// FillMethod and FillThreshold select whether and which gaps in the
+	// samples are filled when calculating monthly averages
+	FillMethod    string `json:"fill_method" yaml:"fill_method"`
+	FillThreshold string `json:"fill_threshold" yaml:"fill_threshold"`
+	// Include and Exclude select the buckets collect gathers usage for. Patterns
+	// are globs, or regular expressions if prefixed with "re:".
+	Include []string `json:"include" yaml:"include"`
+	Exclude []string `json:"exclude" yaml:"exclude"`
+	// Groups maps group names, e.g. projects or customers, to their buckets
+	Groups map[string]GroupConfig `json:"groups" yaml:"groups"`
+	// Anomalies configures the rules collect checks new samples against
+	Anomalies AnomalyConfig `json:"anomalies" yaml:"anomalies"`
+	// Pricing configures the prices of the cost report
+	Pricing PricingConfig `json:"pricing" yaml:"pricing"`
+	// StatementTemplates maps statement formats to templates overriding the
+	// built-in ones
+	StatementTemplates map[string]string `json:"statement_templates" yaml:"statement_templates"`
+}
+
+// PricingConfig holds the prices as given in the configuration. The default
+// rates can be overridden per placement target and per group.
+type PricingConfig struct {
+	Currency string `json:"currency" yaml:"currency"`
+	// Decimals is the number of decimal places costs are rounded to, and
+	// Rounding the direction: nearest, up or down
+	Decimals   *int   `json:"decimals" yaml:"decimals"`
+	Rounding   string `json:"rounding" yaml:"rounding"`
+	RateConfig `yaml:",inline"`
+	Placements map[string]RateConfig `json:"placements" yaml:"placements"`
+	Groups     map[string]RateConfig `json:"groups" yaml:"groups"`
+}
+
+// RateConfig holds a set of rates. Rates that are not set are inherited from
+// the less specific rates.
+type RateConfig struct {
+	// PerGBMonth is a flat price per GB-month, Tiers a graduated price that
+	// takes precedence over it
+	PerGBMonth        *float64     `json:"per_gb_month" yaml:"per_gb_month"`
+	Tiers             []TierConfig `json:"tiers" yaml:"tiers"`
+	PerMillionObjects *float64     `json:"per_million_objects" yaml:"per_million_objects"`
+	MinimumCharge     *float64     `json:"minimum_charge" yaml:"minimum_charge"`
+}
+
+// TierConfig is the price per GB-month of the part of a bucket's size up to
+// UpTo, e.g. "10TB". The last tier has no upper limit.
+type TierConfig struct {
+	UpTo       string  `json:"up_to" yaml:"up_to"`
+	PerGBMonth float64 `json:"per_gb_month" yaml:"per_gb_month"`
+}
+
+// AnomalyConfig holds the anomaly rules as given in the configuration. Rules
+// that are not set use their defaults, a threshold of 0 disables a rule.
+type AnomalyConfig struct {
+	MaxChangePct  *float64 `json:"max_change_pct" yaml:"max_change_pct"`
+	ZScore        *float64 `json:"z_score" yaml:"z_score"`
+	DivergencePct *float64 `json:"divergence_pct" yaml:"divergence_pct"`
+	Window        string   `json:"window" yaml:"window"`
+	MinSamples    int      `json:"min_samples" yaml:"min_samples"`
+	MinSize       string   `json:"min_size" yaml:"min_size"`
+}
+
+// GroupConfig defines the buckets of a group. A bucket belongs to the group if
+// it matches any of the patterns, is owned by any of the owners or is listed
+// explicitly. Patterns are globs, or regular expressions if prefixed with "re:".
+type GroupConfig struct {
+	Patterns []string `json:"patterns" yaml:"patterns"`
+	Owners   []string `json:"owners" yaml:"owners"`
+	Buckets  []string `json:"buckets" yaml:"buckets"`
+}
+
+// GroupUsagePoint is the usage of all buckets of a group at one point in time
+type GroupUsagePoint struct {
+	Group       string    `json:"group"`
+	Timestamp   time.Time `json:"timestamp"`
+	Resolution  string    `json:"resolution"`
+	SizeBytes   float64   `json:"size_bytes"`
+	ObjectCount float64   `json:"object_count"`
+	// Buckets is the number of buckets with data at this point in time
+	Buckets int `json:"buckets"`
+}
+
+// Policies for collection runs in which some buckets failed
+const (
+	// PartialFailureCommit stores the samples of all buckets that succeeded
+	PartialFailureCommit = "commit"
+	// PartialFailureAbort stores nothing if any bucket failed
+	PartialFailureAbort = "abort"
+)
+
+// RetentionConfig holds the retention periods as given in the configuration,
+// e.g. "90d", "1y" or "forever"
+type RetentionConfig struct {
+	Raw    string `json:"raw" yaml:"raw"`
+	Hourly string `json:"hourly" yaml:"hourly"`
+	Daily  string `json:"daily" yaml:"daily"`
+}
+
+// PruneCount represents the number of data points of a bucket that would be
+// removed from a month by pruning
+type PruneCount struct {
+	BucketName string `json:"bucket_name"`
+	Year       int    `json:"year"`
+	Month      int    `json:"month"`
+	DataPoints int64  `json:"data_points"`
+}
+
+// Severities of problems found by consistency checks
+const (
+	// SeverityInfo marks states that are expected, e.g. after pruning
+	SeverityInfo    = "info"
+	SeverityWarning = "warning"
+	SeverityError   = "error"
+)
+
+// CheckIssue represents a problem found by a database consistency check
+type CheckIssue struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// Lock represents an exclusive lease on an operation, such as the collection
+// for a cluster, held by one process until it expires
+type Lock struct {
+	Name       string    `json:"name"`
+	Owner      string    `json:"owner"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
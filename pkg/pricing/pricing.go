@@ -0,0 +1,271 @@
+// Package pricing calculates the cost of buckets from their monthly average
+// usage
+package pricing
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+// Directions costs are rounded in
+const (
+	RoundNearest = "nearest"
+	RoundUp      = "up"
+	RoundDown    = "down"
+)
+
+// bytesPerGB is the size of a GB, a power of 1024 like everywhere in s3usage
+const bytesPerGB = 1 << 30
+
+// Tier is the price per GB-month of the part of a size up to UpToBytes, zero
+// for the last tier
+type Tier struct {
+	UpToBytes  int64
+	PerGBMonth float64
+}
+
+// Rates is a set of rates. Nil fields are not set and inherited from the less
+// specific rates.
+type Rates struct {
+	Tiers             []Tier
+	PerMillionObjects *float64
+	MinimumCharge     *float64
+}
+
+// override returns the rates with the fields set in o replaced
+func (r Rates) override(o Rates) Rates {
+	if o.Tiers != nil {
+		r.Tiers = o.Tiers
+	}
+	if o.PerMillionObjects != nil {
+		r.PerMillionObjects = o.PerMillionObjects
+	}
+	if o.MinimumCharge != nil {
+		r.MinimumCharge = o.MinimumCharge
+	}
+	return r
+}
+
+// empty reports whether no rate is set
+func (r Rates) empty() bool {
+	return r.Tiers == nil && r.PerMillionObjects == nil && r.MinimumCharge == nil
+}
+
+// StorageCost returns the cost of storing the given average size for a
+// month. Each tier prices the part of the size that falls into it.
+func (r Rates) StorageCost(sizeBytes float64) float64 {
+	var cost, lower float64
+	for _, t := range r.Tiers {
+		upper := float64(t.UpToBytes)
+		if t.UpToBytes == 0 || sizeBytes < upper {
+			upper = sizeBytes
+		}
+		if upper > lower {
+			cost += (upper - lower) / bytesPerGB * t.PerGBMonth
+		}
+		if t.UpToBytes == 0 || sizeBytes <= float64(t.UpToBytes) {
+			break
+		}
+		lower = float64(t.UpToBytes)
+	}
+	return cost
+}
+
+// ObjectCost returns the cost of storing the given average number of objects
+// for a month
+func (r Rates) ObjectCost(objects float64) float64 {
+	if r.PerMillionObjects == nil {
+		return 0
+	}
+	return objects / 1e6 * *r.PerMillionObjects
+}
+
+// Pricing holds the default rates with their overrides and how costs are
+// rounded
+type Pricing struct {
+	Currency string
+	Decimals int
+	Rounding string
+	Default  Rates
+	// Placements and Groups override the default rates for the buckets of a
+	// placement target or group
+	Placements map[string]Rates
+	Groups     map[string]Rates
+}
+
+// Validate checks that rates are configured and the tiers are ordered
+func (p *Pricing) Validate() error {
+	switch p.Rounding {
+	case RoundNearest, RoundUp, RoundDown:
+	default:
+		return fmt.Errorf("invalid rounding %q, use %s, %s or %s", p.Rounding, RoundNearest, RoundUp, RoundDown)
+	}
+	if p.Decimals < 0 {
+		return fmt.Errorf("decimals must not be negative")
+	}
+	if p.Default.empty() && len(p.Placements) == 0 && len(p.Groups) == 0 {
+		return fmt.Errorf("no prices configured")
+	}
+
+	check := func(name string, r Rates) error {
+		for i, t := range r.Tiers {
+			last := i == len(r.Tiers)-1
+			switch {
+			case t.PerGBMonth < 0:
+				return fmt.Errorf("%s: prices must not be negative", name)
+			case last && t.UpToBytes != 0:
+				return fmt.Errorf("%s: the last tier must not have an upper limit", name)
+			case !last && t.UpToBytes <= 0:
+				return fmt.Errorf("%s: every tier but the last needs an upper limit", name)
+			case i > 0 && !last && t.UpToBytes <= r.Tiers[i-1].UpToBytes:
+				return fmt.Errorf("%s: tiers must be ordered by their upper limit", name)
+			}
+		}
+		if (r.PerMillionObjects != nil && *r.PerMillionObjects < 0) || (r.MinimumCharge != nil && *r.MinimumCharge < 0) {
+			return fmt.Errorf("%s: prices must not be negative", name)
+		}
+		return nil
+	}
+	if err := check("default rates", p.Default); err != nil {
+		return err
+	}
+	for name, r := range p.Placements {
+		if err := check("placement "+name, r); err != nil {
+			return err
+		}
+	}
+	for name, r := range p.Groups {
+		if err := check("group "+name, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// round rounds an amount to the configured decimals. A small tolerance keeps
+// amounts that are exact up to floating point errors from being rounded up or
+// down a whole step.
+func (p *Pricing) round(amount float64) float64 {
+	factor := math.Pow(10, float64(p.Decimals))
+	v := amount * factor
+	switch p.Rounding {
+	case RoundUp:
+		v = math.Ceil(v - 1e-9)
+	case RoundDown:
+		v = math.Floor(v + 1e-9)
+	default:
+		v = math.Round(v)
+	}
+	return v / factor
+}
+
+// RatesFor returns the rates of a bucket and a description of where they come
+// from. Group rates take precedence over placement rates, which take
+// precedence over the default rates; each rate is looked up separately. Of
+// several groups with rates the first one in the given order is used.
+func (p *Pricing) RatesFor(placement string, groups []string) (Rates, string) {
+	rates := p.Default
+	var sources []string
+	if r, ok := p.Placements[placement]; ok && placement != "" {
+		rates = rates.override(r)
+		sources = append(sources, "placement "+placement)
+	}
+	for _, group := range groups {
+		if r, ok := p.Groups[group]; ok {
+			rates = rates.override(r)
+			sources = append(sources, "group "+group)
+			break
+		}
+	}
+	if len(sources) == 0 {
+		if placement == "" && len(p.Placements) > 0 {
+			return rates, "default (placement unknown)"
+		}
+		return rates, "default"
+	}
+	return rates, strings.Join(sources, ", ")
+}
+
+// Line is the monthly average of a bucket to be priced, with the owner,
+// placement and groups it is priced by
+type Line struct {
+	Average   models.MonthlyBucketAverage
+	Owner     string
+	Placement string
+	Groups    []string
+}
+
+// Costs calculates the cost of the monthly averages of several buckets. The
+// tiers apply to the total size of the buckets of an owner that are priced with
+// the same rates, and the storage cost of that total is split across the
+// buckets by their size, so many small buckets reach the higher tiers like a
+// single large one. Buckets without an owner are priced on their own. Storage
+// and object costs are rounded separately per bucket and the minimum charge
+// applies to each bucket that stored any data.
+func (p *Pricing) Costs(lines []Line) []models.CostItem {
+	type pool struct {
+		rates Rates
+		size  float64
+	}
+	pools := make(map[string]*pool)
+	keys := make([]string, len(lines))
+	sources := make([]string, len(lines))
+	for i, line := range lines {
+		rates, source := p.RatesFor(line.Placement, line.Groups)
+		sources[i] = source
+		keys[i] = "bucket\x00" + line.Average.BucketName
+		if line.Owner != "" {
+			keys[i] = "owner\x00" + line.Owner + "\x00" + source
+		}
+		if pools[keys[i]] == nil {
+			pools[keys[i]] = &pool{rates: rates}
+		}
+		pools[keys[i]].size += line.Average.AvgSizeBytes
+	}
+
+	items := make([]models.CostItem, len(lines))
+	for i, line := range lines {
+		pl := pools[keys[i]]
+		var storage float64
+		if pl.size > 0 {
+			storage = pl.rates.StorageCost(pl.size) * line.Average.AvgSizeBytes / pl.size
+		}
+		items[i] = p.cost(line, pl.rates, sources[i], storage)
+	}
+	return items
+}
+
+// Cost calculates the cost of a single bucket's monthly average, with the
+// tiers applied to its size alone
+func (p *Pricing) Cost(avg models.MonthlyBucketAverage, placement string, groups []string) models.CostItem {
+	return p.Costs([]Line{{Average: avg, Placement: placement, Groups: groups}})[0]
+}
+
+// cost returns the cost item of a line with the given share of the storage cost
+// of its pool
+func (p *Pricing) cost(line Line, rates Rates, source string, storage float64) models.CostItem {
+	avg := line.Average
+	item := models.CostItem{
+		BucketName:     avg.BucketName,
+		Year:           avg.Year,
+		Month:          avg.Month,
+		Owner:          line.Owner,
+		Placement:      line.Placement,
+		Rates:          source,
+		AvgSizeBytes:   avg.AvgSizeBytes,
+		AvgObjectCount: avg.AvgObjectCount,
+		StorageCost:    p.round(storage),
+		ObjectCost:     p.round(rates.ObjectCost(avg.AvgObjectCount)),
+		Currency:       p.Currency,
+	}
+	item.Total = item.StorageCost + item.ObjectCost
+	if rates.MinimumCharge != nil && (avg.AvgSizeBytes > 0 || avg.AvgObjectCount > 0) && item.Total < *rates.MinimumCharge {
+		item.MinimumCharge = p.round(*rates.MinimumCharge - item.Total)
+		item.Total += item.MinimumCharge
+	}
+	item.Total = p.round(item.Total)
+	return item
+}
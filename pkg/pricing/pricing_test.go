@@ -0,0 +1,170 @@
+package pricing
+
+import (
+	"math"
+	"testing"
+
+	"github.com/thannaske/s3usage/pkg/models"
+)
+
+func ptr(v float64) *float64 { return &v }
+
+func TestStorageCostTiers(t *testing.T) {
+	// 1 per GB up to 100 GB, 0.5 up to 1 TB, 0.25 above
+	rates := Rates{Tiers: []Tier{
+		{UpToBytes: 100 * bytesPerGB, PerGBMonth: 1},
+		{UpToBytes: 1024 * bytesPerGB, PerGBMonth: 0.5},
+		{PerGBMonth: 0.25},
+	}}
+
+	tests := []struct {
+		name string
+		gb   float64
+		want float64
+	}{
+		{"empty", 0, 0},
+		{"within first tier", 50, 50},
+		{"at first limit", 100, 100},
+		{"just above first limit", 101, 100.5},
+		{"at second limit", 1024, 100 + 924*0.5},
+		{"in last tier", 2048, 100 + 924*0.5 + 1024*0.25},
+		{"fraction of a GB", 0.5, 0.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rates.StorageCost(tt.gb * bytesPerGB); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("StorageCost(%v GB) = %v, want %v", tt.gb, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorageCostFlat(t *testing.T) {
+	rates := Rates{Tiers: []Tier{{PerGBMonth: 0.02}}}
+	if got := rates.StorageCost(500 * bytesPerGB); math.Abs(got-10) > 1e-9 {
+		t.Errorf("StorageCost = %v, want 10", got)
+	}
+}
+
+func TestCost(t *testing.T) {
+	p := &Pricing{
+		Decimals: 2,
+		Rounding: RoundNearest,
+		Default: Rates{
+			Tiers:             []Tier{{UpToBytes: 100 * bytesPerGB, PerGBMonth: 1}, {PerGBMonth: 0.5}},
+			PerMillionObjects: ptr(1),
+			MinimumCharge:     ptr(5),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		avg     models.MonthlyBucketAverage
+		storage float64
+		objects float64
+		minimum float64
+		total   float64
+	}{
+		{
+			// A bucket holding 200 GB for half of the month averages 100 GB
+			name:    "prorated average",
+			avg:     models.MonthlyBucketAverage{AvgSizeBytes: 100 * bytesPerGB, AvgObjectCount: 2e6},
+			storage: 100,
+			objects: 2,
+			total:   102,
+		},
+		{
+			name:    "prorated across tiers",
+			avg:     models.MonthlyBucketAverage{AvgSizeBytes: 150 * bytesPerGB},
+			storage: 125,
+			total:   125,
+		},
+		{
+			name:    "minimum charge",
+			avg:     models.MonthlyBucketAverage{AvgSizeBytes: 1.5 * bytesPerGB, AvgObjectCount: 1000},
+			storage: 1.5,
+			minimum: 3.5,
+			total:   5,
+		},
+		{
+			name: "no minimum for empty bucket",
+			avg:  models.MonthlyBucketAverage{},
+		},
+		{
+			name:    "rounded",
+			avg:     models.MonthlyBucketAverage{AvgSizeBytes: 123.456 * bytesPerGB, AvgObjectCount: 1234},
+			storage: 111.73,
+			minimum: 0,
+			total:   111.73,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := p.Cost(tt.avg, "", nil)
+			got := []float64{item.StorageCost, item.ObjectCost, item.MinimumCharge, item.Total}
+			want := []float64{tt.storage, tt.objects, tt.minimum, tt.total}
+			for i := range got {
+				if math.Abs(got[i]-want[i]) > 1e-9 {
+					t.Fatalf("Cost = storage %v, objects %v, minimum %v, total %v; want %v", got[0], got[1], got[2], got[3], want)
+				}
+			}
+		})
+	}
+}
+
+func TestRatesForUnknownPlacement(t *testing.T) {
+	p := &Pricing{
+		Default:    Rates{Tiers: []Tier{{PerGBMonth: 1}}},
+		Placements: map[string]Rates{"cold": {Tiers: []Tier{{PerGBMonth: 0.5}}}},
+	}
+	if _, source := p.RatesFor("cold", nil); source != "placement cold" {
+		t.Errorf("source = %q, want placement cold", source)
+	}
+	if _, source := p.RatesFor("", nil); source != "default (placement unknown)" {
+		t.Errorf("source = %q, want default (placement unknown)", source)
+	}
+}
+
+func TestCostsShareTiersPerOwner(t *testing.T) {
+	p := &Pricing{
+		Decimals: 2,
+		Rounding: RoundNearest,
+		// 1 per GB up to 100 GB, 0.5 above
+		Default:    Rates{Tiers: []Tier{{UpToBytes: 100 * bytesPerGB, PerGBMonth: 1}, {PerGBMonth: 0.5}}},
+		Placements: map[string]Rates{"cold": {Tiers: []Tier{{UpToBytes: 100 * bytesPerGB, PerGBMonth: 0.2}, {PerGBMonth: 0.1}}}},
+	}
+	gb := func(bucket string, size float64) models.MonthlyBucketAverage {
+		return models.MonthlyBucketAverage{BucketName: bucket, AvgSizeBytes: size * bytesPerGB}
+	}
+	lines := []Line{
+		// 120 GB of alice cost 100 + 10, split by size
+		{Average: gb("a1", 60), Owner: "alice"},
+		{Average: gb("a2", 60), Owner: "alice"},
+		// Other rates form a pool of their own
+		{Average: gb("a3", 120), Owner: "alice", Placement: "cold"},
+		// Buckets of other owners and without an owner are priced on their own
+		{Average: gb("b1", 60), Owner: "bob"},
+		{Average: gb("x1", 60)},
+		{Average: gb("x2", 60)},
+		{Average: gb("empty", 0), Owner: "alice"},
+	}
+	want := map[string]float64{"a1": 55, "a2": 55, "a3": 22, "b1": 60, "x1": 60, "x2": 60, "empty": 0}
+
+	items := p.Costs(lines)
+	if len(items) != len(lines) {
+		t.Fatalf("%d items for %d lines", len(items), len(lines))
+	}
+	for i, item := range items {
+		if item.BucketName != lines[i].Average.BucketName || item.Owner != lines[i].Owner {
+			t.Errorf("item %d is %s of %q, want %s of %q", i, item.BucketName, item.Owner, lines[i].Average.BucketName, lines[i].Owner)
+		}
+		if math.Abs(item.StorageCost-want[item.BucketName]) > 1e-9 {
+			t.Errorf("storage cost of %s = %v, want %v", item.BucketName, item.StorageCost, want[item.BucketName])
+		}
+	}
+
+	// A single bucket is priced alone
+	if item := p.Cost(gb("a1", 60), "", nil); item.StorageCost != 60 {
+		t.Errorf("Cost = %v, want 60", item.StorageCost)
+	}
+}